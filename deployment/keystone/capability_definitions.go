@@ -1,6 +1,46 @@
 package keystone
 
-import kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+)
+
+// validateCapabilityType returns an error if t is not a capability type known to the registry
+func validateCapabilityType(t uint8) error {
+	if int(t) >= len(capabilityTypeNames) {
+		return fmt.Errorf("unknown capability type %d: must be one of 0 (trigger), 1 (action), 2 (consensus) or 3 (target)", t)
+	}
+	return nil
+}
+
+// maxCapabilityNameLength is the maximum length of a capability labelled name
+const maxCapabilityNameLength = 128
+
+// ValidateCapabilityName checks that the labelled name has the form of the registry's capability ids,
+// {name}:{label1_key}_{label1_value}:..., eg data-streams-reports:chain:ethereum. The name and labels are made of
+// lowercase letters, digits, hyphens and underscores
+func ValidateCapabilityName(name string) error {
+	if name == "" {
+		return errors.New("empty capability name")
+	}
+	if len(name) > maxCapabilityNameLength {
+		return fmt.Errorf("capability name '%s' is %d characters, the maximum is %d", name, len(name), maxCapabilityNameLength)
+	}
+	for _, part := range strings.Split(name, ":") {
+		if part == "" {
+			return fmt.Errorf("capability name '%s' has an empty name or label", name)
+		}
+		for _, r := range part {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+				return fmt.Errorf("capability name '%s' has invalid character %q: must be lowercase letters, digits, '-' or '_'", name, r)
+			}
+		}
+	}
+	return nil
+}
 
 // TODO: KS-457 configuration management for capabilities from external sources
 var StreamTriggerCap = kcr.CapabilitiesRegistryCapability{
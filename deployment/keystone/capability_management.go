@@ -1,9 +1,16 @@
 package keystone
 
 import (
+	"bytes"
+	"cmp"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/crypto"
+
 	"github.com/smartcontractkit/chainlink-common/pkg/logger"
 	"github.com/smartcontractkit/chainlink/deployment"
 	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
@@ -68,3 +75,142 @@ func AddCapabilities(lggr logger.Logger, registry *kcr.CapabilitiesRegistry, cha
 func CapabilityID(c kcr.CapabilitiesRegistryCapability) string {
 	return fmt.Sprintf("%s@%s", c.LabelledName, c.Version)
 }
+
+var capabilityIDArgs = abi.Arguments{{Type: mustABIType("string")}, {Type: mustABIType("string")}}
+
+// HashedCapabilityID computes the capability id as the registry does in getHashedCapabilityId,
+// keccak256(abi.encode(labelledName, version)). It is the id of the capability in the registry, eg in DONInfo
+func HashedCapabilityID(labelledName, version string) [32]byte {
+	// packing two strings into string arguments cannot fail
+	b, _ := capabilityIDArgs.Pack(labelledName, version)
+	return crypto.Keccak256Hash(b)
+}
+
+// HashedCapabilityIDOf is HashedCapabilityID of the capability's labelled name and version.
+// CapabilityID is the human readable name@version form of the same id
+func HashedCapabilityIDOf(c kcr.CapabilitiesRegistryCapability) [32]byte {
+	return HashedCapabilityID(c.LabelledName, c.Version)
+}
+
+// CapabilityConfigHash is the keccak256 hash of a capability config, eg to compare the configs of a don's capabilities in
+// the registry with the desired ones without holding on to both configs
+func CapabilityConfigHash(config []byte) [32]byte {
+	return crypto.Keccak256Hash(config)
+}
+
+// CapabilityConfigDrift returns the ids of the capabilities of the don info whose registry config has a different
+// CapabilityConfigHash than the desired config, keyed by hashed capability id, ordered by id. Capabilities that are
+// only in one of them are not drift; they are added or removed capabilities, as in DiffDons
+func CapabilityConfigDrift(info kcr.CapabilitiesRegistryDONInfo, desired map[[32]byte][]byte) [][32]byte {
+	var out [][32]byte
+	for _, cfg := range info.CapabilityConfigurations {
+		want, ok := desired[cfg.CapabilityId]
+		if !ok || CapabilityConfigHash(want) == CapabilityConfigHash(cfg.Config) {
+			continue
+		}
+		out = append(out, cfg.CapabilityId)
+	}
+	slices.SortFunc(out, func(a, b [32]byte) int { return bytes.Compare(a[:], b[:]) })
+	return out
+}
+
+// CompareCapabilityVersion compares semver like capability versions, returning -1, 0 or 1 as a is less than, equal to
+// or greater than b. The dot separated components are compared numerically, so 1.9.0 < 1.10.0, and missing components
+// are 0. As in semver, a version with a pre-release suffix (1.0.0-beta) is less than the version without one, and
+// build metadata (+build) is ignored. Components that are not numbers are compared as strings
+func CompareCapabilityVersion(a, b string) int {
+	aCore, aPre := splitCapabilityVersion(a)
+	bCore, bPre := splitCapabilityVersion(b)
+	if c := compareVersionIdentifiers(strings.Split(aCore, "."), strings.Split(bCore, "."), "0"); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareVersionIdentifiers(strings.Split(aPre, "."), strings.Split(bPre, "."), "")
+}
+
+// splitCapabilityVersion returns the core and pre-release parts of the version, without build metadata
+func splitCapabilityVersion(v string) (core, pre string) {
+	v, _, _ = strings.Cut(strings.TrimSpace(v), "+")
+	core, pre, _ = strings.Cut(v, "-")
+	return core, pre
+}
+
+// compareVersionIdentifiers compares the identifiers pairwise, with missing identifiers set to pad. An empty
+// identifier is less than any other, so a shorter pre-release is less than a longer one with the same prefix
+func compareVersionIdentifiers(a, b []string, pad string) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		x, y := pad, pad
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if c := compareVersionIdentifier(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareVersionIdentifier compares numbers numerically. Numbers are less than other identifiers, which are compared
+// as strings
+func compareVersionIdentifier(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmp.Compare(an, bn)
+	case aErr == nil && b != "":
+		return -1
+	case bErr == nil && a != "":
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareCapabilities orders capabilities by labelled name, then version as in CompareCapabilityVersion and
+// then capability id, so that versions with the same ordering, eg 1.0 and 1.0.0, have a stable order
+func compareCapabilities(a, b kcr.CapabilitiesRegistryCapability) int {
+	if c := strings.Compare(a.LabelledName, b.LabelledName); c != 0 {
+		return c
+	}
+	if c := CompareCapabilityVersion(a.Version, b.Version); c != 0 {
+		return c
+	}
+	return strings.Compare(CapabilityID(a), CapabilityID(b))
+}
+
+// UnregisteredCapabilities returns the desired capabilities whose hashed id is not in known, eg the ids of the
+// capabilities in the registry. Duplicates in desired are returned once
+func UnregisteredCapabilities(desired []kcr.CapabilitiesRegistryCapability, known [][32]byte) []kcr.CapabilitiesRegistryCapability {
+	seen := make(map[[32]byte]struct{}, len(known))
+	for _, id := range known {
+		seen[id] = struct{}{}
+	}
+	var out []kcr.CapabilitiesRegistryCapability
+	for _, c := range desired {
+		id := HashedCapabilityIDOf(c)
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		out = append(out, c)
+	}
+	return out
+}
+
+func mustABIType(t string) abi.Type {
+	typ, err := abi.NewType(t, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
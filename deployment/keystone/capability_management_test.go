@@ -0,0 +1,115 @@
+package keystone
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/require"
+
+	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+)
+
+func TestHashedCapabilityID(t *testing.T) {
+	// expected ids are from CapabilitiesRegistry.getHashedCapabilityId
+	tests := []struct {
+		name string
+		cap  kcr.CapabilitiesRegistryCapability
+		want string
+	}{
+		{
+			name: "contract test fixture",
+			cap:  kcr.CapabilitiesRegistryCapability{LabelledName: "ccip1", Version: "1.0.0"},
+			want: "bd81825da87dd1fea20935a669a981589126d6ed34dda55c295ccdc83a323f9c",
+		},
+		{
+			name: "ocr3",
+			cap:  OCR3Cap,
+			want: "578ebf7413c15e36dfd792396c5a4d75c43f0ee7bbabdb703f7c5acd0bb65a1b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := hex.DecodeString(tt.want)
+			require.NoError(t, err)
+			got := HashedCapabilityID(tt.cap.LabelledName, tt.cap.Version)
+			assert.Equal(t, want, got[:])
+			assert.Equal(t, got, HashedCapabilityIDOf(tt.cap))
+		})
+	}
+	// the contract test also checks that the encoding does not clash on the name/version boundary
+	assert.NotEqual(t, HashedCapabilityID("ccip1", "1.0.0"), HashedCapabilityID("ccip", "11.0.0"))
+}
+
+func TestCapabilityConfigHash(t *testing.T) {
+	for _, tt := range []struct {
+		config []byte
+		want   string
+	}{
+		{config: nil, want: "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{config: []byte("hello"), want: "1c8aff950685c2ed4bc3174f3472287b56d9517b9c948127319a09a7a36deac8"},
+	} {
+		got := CapabilityConfigHash(tt.config)
+		assert.Equal(t, tt.want, hex.EncodeToString(got[:]))
+	}
+
+	var (
+		ocr3   = HashedCapabilityIDOf(OCR3Cap)
+		writer = HashedCapabilityIDOf(WriteChainCap)
+		stream = HashedCapabilityIDOf(StreamTriggerCap)
+	)
+	info := kcr.CapabilitiesRegistryDONInfo{
+		CapabilityConfigurations: []kcr.CapabilitiesRegistryCapabilityConfiguration{
+			{CapabilityId: ocr3, Config: []byte("ocr3")},
+			{CapabilityId: writer, Config: []byte("writer")},
+			{CapabilityId: stream, Config: []byte("stream")},
+		},
+	}
+	assert.Empty(t, CapabilityConfigDrift(info, map[[32]byte][]byte{ocr3: []byte("ocr3"), writer: []byte("writer")}))
+	drift := CapabilityConfigDrift(info, map[[32]byte][]byte{
+		ocr3:   []byte("ocr3"),
+		writer: []byte("writer v2"),
+		stream: nil,
+		HashedCapabilityID("read-chain", "1.0.0"): []byte("new"),
+	})
+	require.Len(t, drift, 2)
+	assert.ElementsMatch(t, [][32]byte{writer, stream}, drift)
+}
+
+func TestUnregisteredCapabilities(t *testing.T) {
+	desired := []kcr.CapabilitiesRegistryCapability{StreamTriggerCap, WriteChainCap, OCR3Cap, WriteChainCap}
+	known := [][32]byte{HashedCapabilityIDOf(OCR3Cap)}
+
+	got := UnregisteredCapabilities(desired, known)
+	assert.Equal(t, []kcr.CapabilitiesRegistryCapability{StreamTriggerCap, WriteChainCap}, got)
+
+	assert.Empty(t, UnregisteredCapabilities(desired, [][32]byte{
+		HashedCapabilityIDOf(StreamTriggerCap), HashedCapabilityIDOf(WriteChainCap), HashedCapabilityIDOf(OCR3Cap),
+	}))
+}
+
+func TestCompareCapabilityVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.0.0", b: "1.0.0", want: 0},
+		{a: "1.9.0", b: "1.10.0", want: -1},
+		{a: "2.0.0", b: "1.10.0", want: 1},
+		{a: "1.0", b: "1.0.0", want: 0},
+		{a: "1.0.0+build.1", b: "1.0.0", want: 0},
+		{a: "1.0.0-beta", b: "1.0.0", want: -1},
+		{a: "1.0.0", b: "1.0.0-rc.1", want: 1},
+		{a: "1.0.0-alpha", b: "1.0.0-alpha.1", want: -1},
+		{a: "1.0.0-alpha.1", b: "1.0.0-alpha.beta", want: -1},
+		{a: "1.0.0-beta.2", b: "1.0.0-beta.11", want: -1},
+		{a: "1.0.0-rc.1", b: "1.0.0-beta.11", want: 1},
+		{a: "1.10.0-beta", b: "1.9.0", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, CompareCapabilityVersion(tt.a, tt.b))
+			assert.Equal(t, -tt.want, CompareCapabilityVersion(tt.b, tt.a))
+		})
+	}
+}
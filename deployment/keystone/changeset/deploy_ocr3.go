@@ -1,6 +1,7 @@
 package changeset
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/smartcontractkit/chainlink-common/pkg/logger"
@@ -29,7 +30,7 @@ func DeployOCR3(env deployment.Environment, config interface{}) (deployment.Chan
 
 func ConfigureOCR3Contract(lggr logger.Logger, env deployment.Environment, ab deployment.AddressBook, registryChainSel uint64, nodes []*models.Node, cfg kslib.OracleConfigWithSecrets) (deployment.ChangesetOutput, error) {
 
-	err := kslib.ConfigureOCR3ContractFromCLO(&env, registryChainSel, nodes, ab, &cfg)
+	err := kslib.ConfigureOCR3ContractFromCLO(context.TODO(), &env, registryChainSel, nodes, ab, &cfg)
 	if err != nil {
 		return deployment.ChangesetOutput{}, fmt.Errorf("failed to configure OCR3Capability: %w", err)
 	}
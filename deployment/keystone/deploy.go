@@ -20,8 +20,6 @@ import (
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
 
-	chainsel "github.com/smartcontractkit/chain-selectors"
-
 	capabilitiespb "github.com/smartcontractkit/chainlink-common/pkg/capabilities/pb"
 	"github.com/smartcontractkit/chainlink-common/pkg/values"
 	"github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
@@ -41,6 +39,15 @@ type ConfigureContractsRequest struct {
 
 	AddressBook      deployment.AddressBook
 	DoContractDeploy bool // if false, the contracts are assumed to be deployed and the address book is used
+
+	Ocr2NodeCache *Ocr2NodeCache // optional, reuses the conversion of nodes that are shared by dons or configured more than once
+
+	SelectorResolver SelectorResolver // optional, resolves the registry chain selector. Defaults to DefaultSelectorResolver
+}
+
+// mapDonsToNodesOpts returns the options of the node conversions of the request
+func (r ConfigureContractsRequest) mapDonsToNodesOpts() []func(*mapDonsToNodesOpts) {
+	return []func(*mapDonsToNodesOpts){withOcr2NodeCache(r.Ocr2NodeCache), WithSelectorResolver(r.SelectorResolver)}
 }
 
 func (r ConfigureContractsRequest) Validate() error {
@@ -56,9 +63,8 @@ func (r ConfigureContractsRequest) Validate() error {
 	if len(r.Dons) == 0 {
 		return errors.New("no DONS")
 	}
-	_, ok := chainsel.ChainBySelector(r.RegistryChainSel)
-	if !ok {
-		return fmt.Errorf("chain %d not found in environment", r.RegistryChainSel)
+	if _, err := newMapDonsToNodesOpts(r.mapDonsToNodesOpts()).selectorResolver().EVMChainID(r.RegistryChainSel); err != nil {
+		return fmt.Errorf("chain %d not found in environment: %w", r.RegistryChainSel, err)
 	}
 	return nil
 }
@@ -95,7 +101,7 @@ func ConfigureContracts(ctx context.Context, lggr logger.Logger, req ConfigureCo
 	}
 
 	// now we have the capability registry set up we need to configure the forwarder contracts and the OCR3 contract
-	dons, err := joinInfoAndNodes(cfgRegistryResp.DonInfos, req.Dons, req.RegistryChainSel)
+	dons, err := joinInfoAndNodes(ctx, cfgRegistryResp.DonInfos, req.Dons, req.RegistryChainSel, req.mapDonsToNodesOpts()...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to assimilate registry to Dons: %w", err)
 	}
@@ -171,24 +177,27 @@ func ConfigureRegistry(ctx context.Context, lggr logger.Logger, req ConfigureCon
 
 	// all the subsequent calls to the registry are in terms of nodes
 	// compute the mapping of dons to their nodes for reuse in various registry calls
-	donToOcr2Nodes, err := mapDonsToNodes(req.Dons, true, req.RegistryChainSel)
+	donToOcr2Nodes, err := mapDonsToNodes(ctx, req.Dons, true, req.RegistryChainSel, req.mapDonsToNodesOpts()...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to map dons to nodes: %w", err)
 	}
 
 	// TODO: we can remove this abstractions and refactor the functions that accept them to accept []DonCapabilities
 	// they are unnecessary indirection
-	donToCapabilities := mapDonsToCaps(req.Dons)
-	nodeIdToNop, err := nodesToNops(req.Dons, req.RegistryChainSel)
+	donToCapabilities := mapDonsToCaps(lggr, req.Dons)
+	nodeToCapabilities := mapNodesToCaps(req.Dons)
+	o := newMapDonsToNodesOpts(req.mapDonsToNodesOpts())
+	nodeIdToNop, err := nodesToNops(req.Dons, req.RegistryChainSel, o.bundleRole, o.selectorResolver())
 	if err != nil {
 		return nil, fmt.Errorf("failed to map nodes to nops: %w", err)
 	}
 
 	// register capabilities
 	capabilitiesResp, err := registerCapabilities(lggr, registerCapabilitiesRequest{
-		chain:             registryChain,
-		registry:          registry,
-		donToCapabilities: donToCapabilities,
+		chain:              registryChain,
+		registry:           registry,
+		donToCapabilities:  donToCapabilities,
+		nodeToCapabilities: nodeToCapabilities,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to register capabilities: %w", err)
@@ -196,10 +205,7 @@ func ConfigureRegistry(ctx context.Context, lggr logger.Logger, req ConfigureCon
 	lggr.Infow("registered capabilities", "capabilities", capabilitiesResp.donToCapabilities)
 
 	// register node operators
-	var nops []kcr.CapabilitiesRegistryNodeOperator
-	for _, nop := range nodeIdToNop {
-		nops = append(nops, nop)
-	}
+	nops := SortedNops(nodeIdToNop)
 	nopsResp, err := RegisterNOPS(ctx, RegisterNOPSRequest{
 		Chain:    registryChain,
 		Registry: registry,
@@ -212,12 +218,13 @@ func ConfigureRegistry(ctx context.Context, lggr logger.Logger, req ConfigureCon
 
 	// register nodes
 	nodesResp, err := registerNodes(lggr, &registerNodesRequest{
-		registry:          registry,
-		chain:             registryChain,
-		nodeIdToNop:       nodeIdToNop,
-		donToOcr2Nodes:    donToOcr2Nodes,
-		donToCapabilities: capabilitiesResp.donToCapabilities,
-		nops:              nopsResp.Nops,
+		registry:           registry,
+		chain:              registryChain,
+		nodeIdToNop:        nodeIdToNop,
+		donToOcr2Nodes:     donToOcr2Nodes,
+		donToCapabilities:  capabilitiesResp.donToCapabilities,
+		nodeToCapabilities: capabilitiesResp.nodeToCapabilities,
+		nops:               nopsResp.Nops,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to register nodes: %w", err)
@@ -226,11 +233,13 @@ func ConfigureRegistry(ctx context.Context, lggr logger.Logger, req ConfigureCon
 
 	// register DONS
 	donsResp, err := registerDons(lggr, registerDonsRequest{
-		registry:          registry,
-		chain:             registryChain,
-		nodeIDToParams:    nodesResp.nodeIDToParams,
-		donToCapabilities: capabilitiesResp.donToCapabilities,
-		donToOcr2Nodes:    donToOcr2Nodes,
+		registry:               registry,
+		chain:                  registryChain,
+		nodeIDToParams:         nodesResp.nodeIDToParams,
+		donToCapabilities:      capabilitiesResp.donToCapabilities,
+		donToOcr2Nodes:         donToOcr2Nodes,
+		donToCapabilityConfigs: mapDonsToCapConfigs(req.Dons),
+		donToF:                 mapDonsToF(req.Dons),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to register DONS: %w", err)
@@ -318,7 +327,7 @@ func ConfigureOCR3Contract(env *deployment.Environment, chainSel uint64, dons []
 	return nil
 }
 
-func ConfigureOCR3ContractFromCLO(env *deployment.Environment, chainSel uint64, nodes []*models.Node, addrBook deployment.AddressBook, cfg *OracleConfigWithSecrets) error {
+func ConfigureOCR3ContractFromCLO(ctx context.Context, env *deployment.Environment, chainSel uint64, nodes []*models.Node, addrBook deployment.AddressBook, cfg *OracleConfigWithSecrets) error {
 	registryChain, ok := env.Chains[chainSel]
 	if !ok {
 		return fmt.Errorf("chain %d not found in environment", chainSel)
@@ -338,9 +347,9 @@ func ConfigureOCR3ContractFromCLO(env *deployment.Environment, chainSel uint64,
 	if contract == nil {
 		return fmt.Errorf("no ocr3 contract found for chain %d", chainSel)
 	}
-	var ocr2nodes []*ocr2Node
+	var ocr2nodes []*Ocr2Node
 	for _, node := range nodes {
-		n, err := newOcr2NodeFromClo(node, chainSel)
+		n, err := newOcr2NodeFromClo(ctx, node, chainSel, defaultBundleRole, DefaultSelectorResolver)
 		if err != nil {
 			return fmt.Errorf("failed to create ocr2 node from clo node: %w", err)
 		}
@@ -356,13 +365,15 @@ func ConfigureOCR3ContractFromCLO(env *deployment.Environment, chainSel uint64,
 }
 
 type registerCapabilitiesRequest struct {
-	chain             deployment.Chain
-	registry          *kcr.CapabilitiesRegistry
-	donToCapabilities map[string][]kcr.CapabilitiesRegistryCapability
+	chain              deployment.Chain
+	registry           *kcr.CapabilitiesRegistry
+	donToCapabilities  map[DonName][]kcr.CapabilitiesRegistryCapability
+	nodeToCapabilities map[string][]kcr.CapabilitiesRegistryCapability // optional per node overrides
 }
 
 type registerCapabilitiesResponse struct {
-	donToCapabilities map[string][]RegisteredCapability
+	donToCapabilities  map[DonName][]RegisteredCapability
+	nodeToCapabilities map[string][]RegisteredCapability
 }
 
 type RegisteredCapability struct {
@@ -376,12 +387,13 @@ func registerCapabilities(lggr logger.Logger, req registerCapabilitiesRequest) (
 		return nil, fmt.Errorf("no capabilities to register")
 	}
 	resp := &registerCapabilitiesResponse{
-		donToCapabilities: make(map[string][]RegisteredCapability),
+		donToCapabilities:  make(map[DonName][]RegisteredCapability),
+		nodeToCapabilities: make(map[string][]RegisteredCapability),
 	}
 
-	// capability could be hosted on multiple dons. need to deduplicate
+	// capability could be hosted on multiple dons and nodes. need to deduplicate
 	uniqueCaps := make(map[kcr.CapabilitiesRegistryCapability][32]byte)
-	for don, caps := range req.donToCapabilities {
+	hashCaps := func(caps []kcr.CapabilitiesRegistryCapability) ([]RegisteredCapability, error) {
 		var registerCaps []RegisteredCapability
 		for _, cap := range caps {
 			id, ok := uniqueCaps[cap]
@@ -400,8 +412,22 @@ func registerCapabilities(lggr logger.Logger, req registerCapabilitiesRequest) (
 			lggr.Debugw("hashed capability id", "capability", cap, "id", id)
 			registerCaps = append(registerCaps, registerCap)
 		}
+		return registerCaps, nil
+	}
+	for don, caps := range req.donToCapabilities {
+		registerCaps, err := hashCaps(caps)
+		if err != nil {
+			return nil, err
+		}
 		resp.donToCapabilities[don] = registerCaps
 	}
+	for nodeID, caps := range req.nodeToCapabilities {
+		registerCaps, err := hashCaps(caps)
+		if err != nil {
+			return nil, err
+		}
+		resp.nodeToCapabilities[nodeID] = registerCaps
+	}
 
 	var capabilities []kcr.CapabilitiesRegistryCapability
 	for cap := range uniqueCaps {
@@ -460,6 +486,19 @@ func RegisterNOPS(ctx context.Context, req RegisterNOPSRequest) (*RegisterNOPSRe
 	return resp, nil
 }
 
+// capabilityConfig returns the registry config of the capability, from configs if it has one and otherwise the
+// default config for the capability type
+func capabilityConfig(cap RegisteredCapability, nNodes int, configs map[[32]byte][]byte) ([]byte, error) {
+	if cfg, ok := configs[cap.ID]; ok {
+		return cfg, nil
+	}
+	cfgb, err := proto.Marshal(defaultCapConfig(cap.CapabilityType, nNodes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal capability config for %v: %w", cap, err)
+	}
+	return cfgb, nil
+}
+
 func defaultCapConfig(capType uint8, nNodes int) *capabilitiespb.CapabilityConfig {
 	switch capType {
 	// TODO: use the enum defined in ??
@@ -523,9 +562,11 @@ type registerNodesRequest struct {
 	registry          *kcr.CapabilitiesRegistry
 	chain             deployment.Chain
 	nodeIdToNop       map[string]kcr.CapabilitiesRegistryNodeOperator
-	donToOcr2Nodes    map[string][]*ocr2Node
-	donToCapabilities map[string][]RegisteredCapability
-	nops              []*kcr.CapabilitiesRegistryNodeOperatorAdded
+	donToOcr2Nodes    map[DonName][]*Ocr2Node
+	donToCapabilities map[DonName][]RegisteredCapability
+	// nodeToCapabilities overrides the don capabilities for the nodes in it
+	nodeToCapabilities map[string][]RegisteredCapability
+	nops               []*kcr.CapabilitiesRegistryNodeOperatorAdded
 }
 type registerNodesResponse struct {
 	nodeIDToParams map[string]kcr.CapabilitiesRegistryNodeParams
@@ -557,6 +598,57 @@ func registerNodes(lggr logger.Logger, req *registerNodesRequest) (*registerNode
 		}
 	}
 
+	nodeIDToParams, err := makeNodeParams(lggr, req, nodeToRegisterNop)
+	if err != nil {
+		return nil, err
+	}
+
+	var uniqueNodeParams []kcr.CapabilitiesRegistryNodeParams
+	for _, v := range nodeIDToParams {
+		uniqueNodeParams = append(uniqueNodeParams, v)
+	}
+	lggr.Debugw("unique node params to add", "count", len(uniqueNodeParams))
+	tx, err := req.registry.AddNodes(req.chain.DeployerKey, uniqueNodeParams)
+	if err != nil {
+		err = DecodeErr(kcr.CapabilitiesRegistryABI, err)
+		// no typed errors in the abi, so we have to do string matching
+		// try to add all nodes in one go, if that fails, fall back to 1-by-1
+		if !strings.Contains(err.Error(), "NodeAlreadyExists") {
+			return nil, fmt.Errorf("failed to call AddNodes for bulk add nodes: %w", err)
+		}
+		lggr.Warn("nodes already exist, falling back to 1-by-1")
+		for _, singleNodeParams := range uniqueNodeParams {
+			tx, err = req.registry.AddNodes(req.chain.DeployerKey, []kcr.CapabilitiesRegistryNodeParams{singleNodeParams})
+			if err != nil {
+				err = DecodeErr(kcr.CapabilitiesRegistryABI, err)
+				if strings.Contains(err.Error(), "NodeAlreadyExists") {
+					lggr.Warnw("node already exists, skipping", "p2pid", singleNodeParams.P2pId)
+					continue
+				}
+				return nil, fmt.Errorf("failed to call AddNode for node with p2pid %v: %w", singleNodeParams.P2pId, err)
+			}
+			// 1-by-1 tx is pending and we need to wait for it to be mined
+			_, err = req.chain.Confirm(tx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to confirm AddNode of p2pid node %v transaction %s: %w", singleNodeParams.P2pId, tx.Hash().String(), err)
+			}
+			lggr.Debugw("registered node", "p2pid", singleNodeParams.P2pId)
+		}
+	} else {
+		// the bulk add tx is pending and we need to wait for it to be mined
+		_, err = req.chain.Confirm(tx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to confirm AddNode confirm transaction %s: %w", tx.Hash().String(), err)
+		}
+	}
+	return &registerNodesResponse{
+		nodeIDToParams: nodeIDToParams,
+	}, nil
+}
+
+// makeNodeParams computes the registry params of every node in the request. nodes that host capabilities in more than
+// one don are merged and nodes with an override in nodeToCapabilities host those capabilities instead of the don ones
+func makeNodeParams(lggr logger.Logger, req *registerNodesRequest, nodeToRegisterNop map[string]*kcr.CapabilitiesRegistryNodeOperatorAdded) (map[string]kcr.CapabilitiesRegistryNodeParams, error) {
 	nodeIDToParams := make(map[string]kcr.CapabilitiesRegistryNodeParams)
 	for don, ocr2nodes := range req.donToOcr2Nodes {
 		caps, ok := req.donToCapabilities[don]
@@ -577,6 +669,13 @@ func registerNodes(lggr logger.Logger, req *registerNodesRequest) (*registerNode
 			if !ok {
 				return nil, fmt.Errorf("node operator not found for node %s", n.ID)
 			}
+			nodeCapabilityIds := hashedCapabilityIds
+			if nodeCaps, ok := req.nodeToCapabilities[n.ID]; ok {
+				nodeCapabilityIds = nil
+				for _, cap := range nodeCaps {
+					nodeCapabilityIds = append(nodeCapabilityIds, cap.ID)
+				}
+			}
 			params, ok := nodeIDToParams[n.ID]
 
 			if !ok {
@@ -585,12 +684,12 @@ func registerNodes(lggr logger.Logger, req *registerNodesRequest) (*registerNode
 					Signer:              n.Signer,
 					P2pId:               n.P2PKey,
 					EncryptionPublicKey: n.EncryptionPublicKey,
-					HashedCapabilityIds: hashedCapabilityIds,
+					HashedCapabilityIds: nodeCapabilityIds,
 				}
 			} else {
 				// when we have a node operator, we need to dedup capabilities against the existing ones
 				var newCapIds [][32]byte
-				for _, proposedCapId := range hashedCapabilityIds {
+				for _, proposedCapId := range nodeCapabilityIds {
 					shouldAdd := true
 					for _, existingCapId := range params.HashedCapabilityIds {
 						if existingCapId == proposedCapId {
@@ -607,48 +706,7 @@ func registerNodes(lggr logger.Logger, req *registerNodesRequest) (*registerNode
 			nodeIDToParams[n.ID] = params
 		}
 	}
-
-	var uniqueNodeParams []kcr.CapabilitiesRegistryNodeParams
-	for _, v := range nodeIDToParams {
-		uniqueNodeParams = append(uniqueNodeParams, v)
-	}
-	lggr.Debugw("unique node params to add", "count", len(uniqueNodeParams))
-	tx, err := req.registry.AddNodes(req.chain.DeployerKey, uniqueNodeParams)
-	if err != nil {
-		err = DecodeErr(kcr.CapabilitiesRegistryABI, err)
-		// no typed errors in the abi, so we have to do string matching
-		// try to add all nodes in one go, if that fails, fall back to 1-by-1
-		if !strings.Contains(err.Error(), "NodeAlreadyExists") {
-			return nil, fmt.Errorf("failed to call AddNodes for bulk add nodes: %w", err)
-		}
-		lggr.Warn("nodes already exist, falling back to 1-by-1")
-		for _, singleNodeParams := range uniqueNodeParams {
-			tx, err = req.registry.AddNodes(req.chain.DeployerKey, []kcr.CapabilitiesRegistryNodeParams{singleNodeParams})
-			if err != nil {
-				err = DecodeErr(kcr.CapabilitiesRegistryABI, err)
-				if strings.Contains(err.Error(), "NodeAlreadyExists") {
-					lggr.Warnw("node already exists, skipping", "p2pid", singleNodeParams.P2pId)
-					continue
-				}
-				return nil, fmt.Errorf("failed to call AddNode for node with p2pid %v: %w", singleNodeParams.P2pId, err)
-			}
-			// 1-by-1 tx is pending and we need to wait for it to be mined
-			_, err = req.chain.Confirm(tx)
-			if err != nil {
-				return nil, fmt.Errorf("failed to confirm AddNode of p2pid node %v transaction %s: %w", singleNodeParams.P2pId, tx.Hash().String(), err)
-			}
-			lggr.Debugw("registered node", "p2pid", singleNodeParams.P2pId)
-		}
-	} else {
-		// the bulk add tx is pending and we need to wait for it to be mined
-		_, err = req.chain.Confirm(tx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to confirm AddNode confirm transaction %s: %w", tx.Hash().String(), err)
-		}
-	}
-	return &registerNodesResponse{
-		nodeIDToParams: nodeIDToParams,
-	}, nil
+	return nodeIDToParams, nil
 }
 
 type registerDonsRequest struct {
@@ -656,8 +714,12 @@ type registerDonsRequest struct {
 	chain    deployment.Chain
 
 	nodeIDToParams    map[string]kcr.CapabilitiesRegistryNodeParams
-	donToCapabilities map[string][]RegisteredCapability
-	donToOcr2Nodes    map[string][]*ocr2Node
+	donToCapabilities map[DonName][]RegisteredCapability
+	donToOcr2Nodes    map[DonName][]*Ocr2Node
+	// donToCapabilityConfigs are the optional capability configs of each don, keyed by capability id
+	donToCapabilityConfigs map[DonName]map[[32]byte][]byte
+	// donToF is the optional fault tolerance of each don. It is computed from the number of nodes for the other dons
+	donToF map[DonName]uint8
 }
 
 type registerDonsResponse struct {
@@ -681,7 +743,7 @@ func registerDons(lggr logger.Logger, req registerDonsRequest) (*registerDonsRes
 	}
 	// track hash of sorted p2pids to don name because the registry return value does not include the don name
 	// and we need to map it back to the don name to access the other mapping data such as the don's capabilities & nodes
-	p2pIdsToDon := make(map[string]string)
+	p2pIdsToDon := make(map[string]DonName)
 	var registeredDons = 0
 
 	for don, ocr2nodes := range req.donToOcr2Nodes {
@@ -696,6 +758,11 @@ func registerDons(lggr logger.Logger, req registerDonsRequest) (*registerDonsRes
 			}
 			p2pIds = append(p2pIds, params.P2pId)
 		}
+		// a don of only bootstraps, see PartitionDons, has no members to register
+		if len(p2pIds) == 0 {
+			lggr.Debugw("skipping don without non-bootstrap nodes", "don", don)
+			continue
+		}
 
 		p2pSortedHash := sortedHash(p2pIds)
 		p2pIdsToDon[p2pSortedHash] = don
@@ -709,11 +776,9 @@ func registerDons(lggr logger.Logger, req registerDonsRequest) (*registerDonsRes
 			if cap.CapabilityType == 2 { // OCR3 capability => WF supported
 				wfSupported = true
 			}
-			// TODO: accept configuration from external source for each (don,capability)
-			capCfg := defaultCapConfig(cap.CapabilityType, len(p2pIds))
-			cfgb, err := proto.Marshal(capCfg)
+			cfgb, err := capabilityConfig(cap, len(p2pIds), req.donToCapabilityConfigs[don])
 			if err != nil {
-				return nil, fmt.Errorf("failed to marshal capability config for %v: %w", cap, err)
+				return nil, err
 			}
 			cfgs = append(cfgs, kcr.CapabilitiesRegistryCapabilityConfiguration{
 				CapabilityId: cap.ID,
@@ -721,7 +786,13 @@ func registerDons(lggr logger.Logger, req registerDonsRequest) (*registerDonsRes
 			})
 		}
 
-		f := len(p2pIds) / 3 // assuming n=3f+1. TODO should come for some config.
+		f := len(p2pIds) / 3 // assuming n=3f+1 unless the don sets f
+		if donF, ok := req.donToF[don]; ok {
+			if err := validateF(string(don), donF, len(p2pIds)); err != nil {
+				return nil, err
+			}
+			f = int(donF)
+		}
 		tx, err := req.registry.AddDON(req.chain.DeployerKey, p2pIds, cfgs, true, wfSupported, uint8(f))
 		if err != nil {
 			err = DecodeErr(kcr.CapabilitiesRegistryABI, err)
@@ -761,7 +832,7 @@ func registerDons(lggr logger.Logger, req registerDonsRequest) (*registerDonsRes
 			return nil, fmt.Errorf("don not found for p2pids %s in %v", sortedHash(donInfo.NodeP2PIds), p2pIdsToDon)
 		}
 		lggr.Debugw("adding don info", "don", donName, "cnt", i)
-		resp.donInfos[donName] = donInfos[i]
+		resp.donInfos[string(donName)] = donInfos[i]
 	}
 	lggr.Debugw("found registered DONs", "count", len(resp.donInfos))
 	if len(resp.donInfos) != registeredDons {
@@ -780,8 +851,12 @@ func configureForwarder(lggr logger.Logger, chain deployment.Chain, fwdr *kf.Key
 		if !dn.Info.AcceptsWorkflows {
 			continue
 		}
+		signers, f, donID, err := dn.ForwarderConfig()
+		if err != nil {
+			return fmt.Errorf("invalid forwarder config for chain %d: %w", chain.Selector, err)
+		}
 		ver := dn.Info.ConfigCount // note config count on the don info is the version on the forwarder
-		tx, err := fwdr.SetConfig(chain.DeployerKey, dn.Info.Id, ver, dn.Info.F, dn.signers())
+		tx, err := fwdr.SetConfig(chain.DeployerKey, donID, ver, f, signers)
 		if err != nil {
 			err = DecodeErr(kf.KeystoneForwarderABI, err)
 			return fmt.Errorf("failed to call SetConfig for forwarder %s on chain %d: %w", fwdr.Address().String(), chain.Selector, err)
@@ -791,7 +866,7 @@ func configureForwarder(lggr logger.Logger, chain deployment.Chain, fwdr *kf.Key
 			err = DecodeErr(kf.KeystoneForwarderABI, err)
 			return fmt.Errorf("failed to confirm SetConfig for forwarder %s: %w", fwdr.Address().String(), err)
 		}
-		lggr.Debugw("configured forwarder", "forwarder", fwdr.Address().String(), "donId", dn.Info.Id, "version", ver, "f", dn.Info.F, "signers", dn.signers())
+		lggr.Debugw("configured forwarder", "forwarder", fwdr.Address().String(), "donId", donID, "version", ver, "f", f, "signers", signers)
 	}
 	return nil
 }
@@ -800,7 +875,7 @@ type configureOCR3Request struct {
 	cfg      *OracleConfigWithSecrets
 	chain    deployment.Chain
 	contract *kocr3.OCR3Capability
-	nodes    []*ocr2Node
+	nodes    []*Ocr2Node
 }
 type configureOCR3Response struct {
 	ocrConfig Orc2drOracleConfig
@@ -810,7 +885,10 @@ func configureOCR3contract(req configureOCR3Request) (*configureOCR3Response, er
 	if req.contract == nil {
 		return nil, fmt.Errorf("OCR3 contract is nil")
 	}
-	nks := makeNodeKeysSlice(req.nodes)
+	nks, err := makeNodeKeysSlice(req.nodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node keys: %w", err)
+	}
 	ocrConfig, err := GenerateOCR3Config(*req.cfg, nks)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate OCR3 config: %w", err)
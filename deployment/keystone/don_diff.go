@@ -0,0 +1,189 @@
+package keystone
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/keys/p2pkey"
+)
+
+// DonDiff is the set of changes needed to bring the registry to the desired state. Only dons with changes are included,
+// ordered by name
+type DonDiff struct {
+	Dons []DonChange
+}
+
+// DonChange are the changes to a single don
+type DonChange struct {
+	Name                string
+	New                 bool // the don is not in the registry
+	AddedNodes          []p2pkey.PeerID
+	RemovedNodes        []p2pkey.PeerID
+	AddedCapabilities   []kcr.CapabilitiesRegistryCapability
+	RemovedCapabilities [][32]byte // hashed capability ids, the registry does not store the capability of a don by name
+	NopAdminChanges     []NopAdminChange
+}
+
+// NopAdminChange is a node operator of the don whose admin in the registry differs from the desired admin
+type NopAdminChange struct {
+	Nop     string
+	Onchain common.Address
+	Desired common.Address
+}
+
+// IsEmpty returns true if there are no changes
+func (d DonDiff) IsEmpty() bool {
+	return len(d.Dons) == 0
+}
+
+func (c DonChange) isEmpty() bool {
+	return !c.New && len(c.AddedNodes) == 0 && len(c.RemovedNodes) == 0 &&
+		len(c.AddedCapabilities) == 0 && len(c.RemovedCapabilities) == 0 && len(c.NopAdminChanges) == 0
+}
+
+// String returns a line per change, grouped by don
+func (d DonDiff) String() string {
+	if d.IsEmpty() {
+		return "no changes"
+	}
+	var b strings.Builder
+	for i, c := range d.Dons {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(c.String())
+	}
+	return b.String()
+}
+
+func (c DonChange) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "don %s", c.Name)
+	if c.New {
+		b.WriteString(" (new)")
+	}
+	b.WriteString(":")
+	for _, p := range c.AddedNodes {
+		fmt.Fprintf(&b, "\n  + node %s", p)
+	}
+	for _, p := range c.RemovedNodes {
+		fmt.Fprintf(&b, "\n  - node %s", p)
+	}
+	for _, cap := range c.AddedCapabilities {
+		fmt.Fprintf(&b, "\n  + capability %s", CapabilityID(cap))
+	}
+	for _, id := range c.RemovedCapabilities {
+		fmt.Fprintf(&b, "\n  - capability %x", id)
+	}
+	for _, n := range c.NopAdminChanges {
+		fmt.Fprintf(&b, "\n  ~ nop %s admin %s -> %s", n.Nop, n.Onchain.Hex(), n.Desired.Hex())
+	}
+	return b.String()
+}
+
+// DiffDons compares the desired dons to the dons in the registry, keyed by don name. NOP admin changes are not reported
+// because the don info does not include the node operators; use DiffDonsAndNops for that
+func DiffDons(ctx context.Context, desired []DonCapabilities, onchain map[string]kcr.CapabilitiesRegistryDONInfo, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (DonDiff, error) {
+	return DiffDonsAndNops(ctx, desired, onchain, nil, registryChainSel, opts...)
+}
+
+// DiffDonsAndNops is DiffDons that also reports the node operators of each don whose admin differs from the one in onchainNops,
+// keyed by node operator name. Node operators that are not in onchainNops are not reported
+func DiffDonsAndNops(ctx context.Context, desired []DonCapabilities, onchain map[string]kcr.CapabilitiesRegistryDONInfo, onchainNops map[string]kcr.CapabilitiesRegistryNodeOperator, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (DonDiff, error) {
+	o := newMapDonsToNodesOpts(opts)
+	// bootstraps are not registered as members of the don
+	donToNodes, err := mapDonsToNodes(ctx, desired, true, registryChainSel, opts...)
+	if err != nil {
+		return DonDiff{}, fmt.Errorf("failed to map dons to nodes: %w", err)
+	}
+	var out DonDiff
+	for _, don := range desired {
+		info, exists := onchain[don.Name]
+		change := DonChange{
+			Name: don.Name,
+			New:  !exists,
+		}
+
+		wantNodes := make(map[p2pkey.PeerID]struct{})
+		for _, n := range donToNodes[DonName(don.Name)] {
+			wantNodes[n.P2PKey] = struct{}{}
+		}
+		haveNodes := make(map[p2pkey.PeerID]struct{})
+		for _, id := range info.NodeP2PIds {
+			haveNodes[p2pkey.PeerID(id)] = struct{}{}
+		}
+		for p := range wantNodes {
+			if _, ok := haveNodes[p]; !ok {
+				change.AddedNodes = append(change.AddedNodes, p)
+			}
+		}
+		for p := range haveNodes {
+			if _, ok := wantNodes[p]; !ok {
+				change.RemovedNodes = append(change.RemovedNodes, p)
+			}
+		}
+		sortPeerIDs(change.AddedNodes)
+		sortPeerIDs(change.RemovedNodes)
+
+		wantCaps := make(map[[32]byte]struct{})
+		for _, c := range don.Capabilities {
+			id := HashedCapabilityID(c.LabelledName, c.Version)
+			if _, dup := wantCaps[id]; dup {
+				continue
+			}
+			wantCaps[id] = struct{}{}
+			if !slices.ContainsFunc(info.CapabilityConfigurations, func(cfg kcr.CapabilitiesRegistryCapabilityConfiguration) bool {
+				return cfg.CapabilityId == id
+			}) {
+				change.AddedCapabilities = append(change.AddedCapabilities, c)
+			}
+		}
+		for _, cfg := range info.CapabilityConfigurations {
+			if _, ok := wantCaps[cfg.CapabilityId]; !ok {
+				change.RemovedCapabilities = append(change.RemovedCapabilities, cfg.CapabilityId)
+			}
+		}
+
+		if len(onchainNops) > 0 {
+			nops, err := don.nodeIdToNop(registryChainSel, o.bundleRole, o.selectorResolver())
+			if err != nil {
+				return DonDiff{}, fmt.Errorf("failed to get registry NOPs for don %s: %w", don.Name, err)
+			}
+			seen := make(map[string]struct{})
+			for _, nop := range nops {
+				if _, ok := seen[nop.Name]; ok {
+					continue
+				}
+				seen[nop.Name] = struct{}{}
+				have, ok := onchainNops[nop.Name]
+				if !ok || have.Admin == nop.Admin {
+					continue
+				}
+				change.NopAdminChanges = append(change.NopAdminChanges, NopAdminChange{
+					Nop:     nop.Name,
+					Onchain: have.Admin,
+					Desired: nop.Admin,
+				})
+			}
+			sort.Slice(change.NopAdminChanges, func(i, j int) bool {
+				return change.NopAdminChanges[i].Nop < change.NopAdminChanges[j].Nop
+			})
+		}
+
+		if !change.isEmpty() {
+			out.Dons = append(out.Dons, change)
+		}
+	}
+	sort.Slice(out.Dons, func(i, j int) bool { return out.Dons[i].Name < out.Dons[j].Name })
+	return out, nil
+}
+
+func sortPeerIDs(ids []p2pkey.PeerID) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+}
@@ -0,0 +1,110 @@
+package keystone
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/require"
+
+	chainsel "github.com/smartcontractkit/chain-selectors"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/utils/tests"
+
+	"github.com/smartcontractkit/chainlink/deployment/environment/clo/models"
+	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/keys/p2pkey"
+)
+
+func TestDiffDons(t *testing.T) {
+	registryChainSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	var (
+		peerIDs []p2pkey.PeerID
+		nodes   []*models.Node
+	)
+	for i := 1; i <= 3; i++ {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		peerIDs = append(peerIDs, p)
+		nodes = append(nodes, newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), false))
+	}
+	desired := []DonCapabilities{
+		{
+			Name: "don",
+			Nops: []*models.NodeOperator{
+				{Name: "nop 1", Nodes: nodes[:2]},
+				{Name: "nop 2", Nodes: nodes[2:]},
+			},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		},
+	}
+	ocr3ID := HashedCapabilityID(OCR3Cap.LabelledName, OCR3Cap.Version)
+	writeID := HashedCapabilityID(WriteChainCap.LabelledName, WriteChainCap.Version)
+	// the registry has the first two nodes and an extra capability
+	onchain := map[string]kcr.CapabilitiesRegistryDONInfo{
+		"don": {
+			Id:         1,
+			NodeP2PIds: [][32]byte{peerIDs[0], peerIDs[1]},
+			CapabilityConfigurations: []kcr.CapabilitiesRegistryCapabilityConfiguration{
+				{CapabilityId: ocr3ID},
+				{CapabilityId: writeID},
+			},
+		},
+	}
+
+	t.Run("adds a node and removes a capability", func(t *testing.T) {
+		got, err := DiffDons(tests.Context(t), desired, onchain, registryChainSel)
+		require.NoError(t, err)
+		require.Len(t, got.Dons, 1)
+		change := got.Dons[0]
+		assert.Equal(t, "don", change.Name)
+		assert.False(t, change.New)
+		assert.Equal(t, []p2pkey.PeerID{peerIDs[2]}, change.AddedNodes)
+		assert.Empty(t, change.RemovedNodes)
+		assert.Empty(t, change.AddedCapabilities)
+		assert.Equal(t, [][32]byte{writeID}, change.RemovedCapabilities)
+		assert.Empty(t, change.NopAdminChanges)
+
+		s := got.String()
+		assert.Contains(t, s, "+ node "+peerIDs[2].String())
+		assert.Contains(t, s, fmt.Sprintf("- capability %x", writeID))
+	})
+
+	t.Run("new don", func(t *testing.T) {
+		got, err := DiffDons(tests.Context(t), desired, nil, registryChainSel)
+		require.NoError(t, err)
+		require.Len(t, got.Dons, 1)
+		assert.True(t, got.Dons[0].New)
+		assert.Len(t, got.Dons[0].AddedNodes, 3)
+		assert.Equal(t, []kcr.CapabilitiesRegistryCapability{OCR3Cap}, got.Dons[0].AddedCapabilities)
+		assert.Contains(t, got.String(), "don don (new):")
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		inSync := map[string]kcr.CapabilitiesRegistryDONInfo{
+			"don": {
+				NodeP2PIds:               [][32]byte{peerIDs[0], peerIDs[1], peerIDs[2]},
+				CapabilityConfigurations: []kcr.CapabilitiesRegistryCapabilityConfiguration{{CapabilityId: ocr3ID}},
+			},
+		}
+		got, err := DiffDons(tests.Context(t), desired, inSync, registryChainSel)
+		require.NoError(t, err)
+		assert.True(t, got.IsEmpty())
+		assert.Equal(t, "no changes", got.String())
+	})
+
+	t.Run("nop admin change", func(t *testing.T) {
+		// newTestCloNode uses admin 0x...01
+		onchainNops := map[string]kcr.CapabilitiesRegistryNodeOperator{
+			"nop 1": {Name: "nop 1", Admin: common.HexToAddress("0x02")},
+			"nop 2": {Name: "nop 2", Admin: common.HexToAddress("0x01")},
+		}
+		got, err := DiffDonsAndNops(tests.Context(t), desired, onchain, onchainNops, registryChainSel)
+		require.NoError(t, err)
+		require.Len(t, got.Dons, 1)
+		assert.Equal(t, []NopAdminChange{
+			{Nop: "nop 1", Onchain: common.HexToAddress("0x02"), Desired: common.HexToAddress("0x01")},
+		}, got.Dons[0].NopAdminChanges)
+	})
+}
@@ -0,0 +1,50 @@
+package keystone
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RenderDonsDOT writes the dons as a graphviz DOT graph. Each don is a cluster with an edge from each of its nops to each
+// of the nop's nodes. Bootstrap nodes are drawn as double octagons and labelled as bootstraps
+func RenderDonsDOT(w io.Writer, dons []DonCapabilities) error {
+	var b strings.Builder
+	b.WriteString("digraph dons {\n")
+	b.WriteString("  node [shape=box];\n")
+	for i, don := range dons {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%s;\n", dotQuote("don "+don.Name))
+		for _, nop := range don.Nops {
+			if nop == nil {
+				continue
+			}
+			// nop names are only unique within a don
+			nopID := dotQuote("nop:" + don.Name + ":" + nop.Name)
+			fmt.Fprintf(&b, "    %s [label=%s, shape=ellipse];\n", nopID, dotQuote(nop.Name))
+			for _, n := range nop.Nodes {
+				if n == nil {
+					continue
+				}
+				nodeID := dotQuote("node:" + n.ID)
+				if isCloBootstrap(n) {
+					fmt.Fprintf(&b, "    %s [label=%s, shape=doubleoctagon];\n", nodeID, dotQuote(n.ID+" (bootstrap)"))
+				} else {
+					fmt.Fprintf(&b, "    %s [label=%s];\n", nodeID, dotQuote(n.ID))
+				}
+				fmt.Fprintf(&b, "    %s -> %s;\n", nopID, nodeID)
+			}
+		}
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("failed to write dot graph: %w", err)
+	}
+	return nil
+}
+
+// dotQuote returns s as a quoted DOT identifier
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
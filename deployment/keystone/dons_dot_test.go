@@ -0,0 +1,63 @@
+package keystone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/require"
+
+	"github.com/smartcontractkit/chainlink/deployment/environment/clo/models"
+	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/keys/p2pkey"
+)
+
+func TestRenderDonsDOT(t *testing.T) {
+	newNode := func(i int, isBootstrap bool) *models.Node {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		return newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), isBootstrap)
+	}
+	dons := []DonCapabilities{
+		{
+			Name: "workflow",
+			Nops: []*models.NodeOperator{
+				{Name: "nop 1", Nodes: []*models.Node{newNode(1, true), newNode(2, false)}},
+				{Name: `nop "2"`, Nodes: []*models.Node{newNode(3, false)}},
+			},
+		},
+		{
+			Name: "writer",
+			Nops: []*models.NodeOperator{{Name: "nop 1", Nodes: []*models.Node{newNode(4, false)}}},
+		},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, RenderDonsDOT(&b, dons))
+	got := b.String()
+	for _, want := range []string{
+		"digraph dons {",
+		"subgraph cluster_0 {",
+		`label="don workflow";`,
+		"subgraph cluster_1 {",
+		`label="don writer";`,
+		`"nop:workflow:nop 1" [label="nop 1", shape=ellipse];`,
+		`"nop:workflow:nop \"2\"" [label="nop \"2\"", shape=ellipse];`,
+		`"node:node-1" [label="node-1 (bootstrap)", shape=doubleoctagon];`,
+		`"node:node-2" [label="node-2"];`,
+		`"nop:workflow:nop 1" -> "node:node-2";`,
+		`"nop:writer:nop 1" -> "node:node-4";`,
+	} {
+		assert.Contains(t, got, want)
+	}
+
+	t.Run("write error", func(t *testing.T) {
+		err := RenderDonsDOT(errWriter{}, dons)
+		require.ErrorContains(t, err, "failed to write dot graph")
+	})
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
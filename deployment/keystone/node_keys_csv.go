@@ -0,0 +1,123 @@
+package keystone
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// nodeKeysCSVHeader is the column order of the node keys csv, which follows the order of the NodeKeys fields
+var nodeKeysCSVHeader = []string{
+	"EthAddress",
+	"AptosAccount",
+	"AptosBundleID",
+	"AptosOnchainPublicKey",
+	"SolanaBundleID",
+	"SolanaOnchainPublicKey",
+	"StarknetBundleID",
+	"StarknetOnchainPublicKey",
+	"P2PPeerID",
+	"OCR2BundleID",
+	"OCR2OnchainPublicKey",
+	"OCR2OffchainPublicKey",
+	"OCR2ConfigPublicKey",
+	"CSAPublicKey",
+	"EncryptionPublicKey",
+	"IsBootstrap",
+}
+
+func (k NodeKeys) csvRecord() []string {
+	return []string{
+		k.EthAddress,
+		k.AptosAccount,
+		k.AptosBundleID,
+		k.AptosOnchainPublicKey,
+		k.SolanaBundleID,
+		k.SolanaOnchainPublicKey,
+		k.StarknetBundleID,
+		k.StarknetOnchainPublicKey,
+		k.P2PPeerID,
+		k.OCR2BundleID,
+		k.OCR2OnchainPublicKey,
+		k.OCR2OffchainPublicKey,
+		k.OCR2ConfigPublicKey,
+		k.CSAPublicKey,
+		k.EncryptionPublicKey,
+		strconv.FormatBool(k.IsBootstrap),
+	}
+}
+
+func nodeKeysFromCSVRecord(r []string) (NodeKeys, error) {
+	isBootstrap, err := strconv.ParseBool(r[15])
+	if err != nil {
+		return NodeKeys{}, fmt.Errorf("invalid IsBootstrap '%s': %w", r[15], err)
+	}
+	return NodeKeys{
+		EthAddress:               r[0],
+		AptosAccount:             r[1],
+		AptosBundleID:            r[2],
+		AptosOnchainPublicKey:    r[3],
+		SolanaBundleID:           r[4],
+		SolanaOnchainPublicKey:   r[5],
+		StarknetBundleID:         r[6],
+		StarknetOnchainPublicKey: r[7],
+		P2PPeerID:                r[8],
+		OCR2BundleID:             r[9],
+		OCR2OnchainPublicKey:     r[10],
+		OCR2OffchainPublicKey:    r[11],
+		OCR2ConfigPublicKey:      r[12],
+		CSAPublicKey:             r[13],
+		EncryptionPublicKey:      r[14],
+		IsBootstrap:              isBootstrap,
+	}, nil
+}
+
+// WriteNodeKeysCSV writes the keys as csv with a header row. Keys of chains that a node does not support are empty
+func WriteNodeKeysCSV(w io.Writer, keys []NodeKeys) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(nodeKeysCSVHeader); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	for i, k := range keys {
+		if err := cw.Write(k.csvRecord()); err != nil {
+			return fmt.Errorf("failed to write node keys %d: %w", i, err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to flush node keys: %w", err)
+	}
+	return nil
+}
+
+// ReadNodeKeysCSV reads keys written by WriteNodeKeysCSV. The header row must match the expected column order
+func ReadNodeKeysCSV(r io.Reader) ([]NodeKeys, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(nodeKeysCSVHeader)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !slices.Equal(header, nodeKeysCSVHeader) {
+		return nil, fmt.Errorf("unexpected header %v: expected %v", header, nodeKeysCSVHeader)
+	}
+	var out []NodeKeys
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read node keys %d: %w", len(out), err)
+		}
+		k, err := nodeKeysFromCSVRecord(rec)
+		if err != nil {
+			row, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("failed to read node keys %d on row %d: %w", len(out), row, err)
+		}
+		out = append(out, k)
+	}
+	return out, nil
+}
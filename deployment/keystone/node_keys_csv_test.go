@@ -0,0 +1,66 @@
+package keystone
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/require"
+)
+
+func TestNodeKeysCSV(t *testing.T) {
+	keys := []NodeKeys{
+		{
+			EthAddress:            "0x1234567890123456789012345678901234567890",
+			AptosAccount:          "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088",
+			AptosBundleID:         "aptosBundleId",
+			AptosOnchainPublicKey: "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088",
+			P2PPeerID:             "12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv",
+			OCR2BundleID:          "bundleId",
+			OCR2OnchainPublicKey:  "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+			OCR2OffchainPublicKey: "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
+			OCR2ConfigPublicKey:   "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
+			CSAPublicKey:          "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+			EncryptionPublicKey:   "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		},
+		// no aptos keys
+		{
+			EthAddress:            "0x0987654321098765432109876543210987654321",
+			P2PPeerID:             "12D3KooWNmhKZL1XW4Vv3rNjLXzJ6mqcVerihdijjGYuexPrFUFZ",
+			OCR2BundleID:          "bundleId2",
+			OCR2OnchainPublicKey:  "a35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+			OCR2OffchainPublicKey: "13dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
+			OCR2ConfigPublicKey:   "13dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
+			CSAPublicKey:          "fedcba0987654321fedcba0987654321fedcba0987654321fedcba0987654321",
+			EncryptionPublicKey:   "fedcba0987654321fedcba0987654321fedcba0987654321fedcba0987654321",
+		},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, WriteNodeKeysCSV(&b, keys))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, strings.Join(nodeKeysCSVHeader, ","), lines[0])
+
+	got, err := ReadNodeKeysCSV(&b)
+	require.NoError(t, err)
+	assert.Equal(t, keys, got)
+
+	t.Run("bad header", func(t *testing.T) {
+		_, err := ReadNodeKeysCSV(strings.NewReader(strings.Join(nodeKeysCSVHeader[1:], ",") + ",EthAddress\n"))
+		require.ErrorContains(t, err, "unexpected header")
+	})
+
+	t.Run("invalid IsBootstrap", func(t *testing.T) {
+		rec := keys[0].csvRecord()
+		rec[len(rec)-1] = "yes"
+		_, err := ReadNodeKeysCSV(strings.NewReader(lines[0] + "\n" + lines[1] + "\n" + strings.Join(rec, ",") + "\n"))
+		require.ErrorContains(t, err, "failed to read node keys 1 on row 3: invalid IsBootstrap 'yes'")
+	})
+
+	t.Run("wrong number of columns", func(t *testing.T) {
+		_, err := ReadNodeKeysCSV(strings.NewReader(lines[0] + "\n" + "a,b\n"))
+		require.Error(t, err)
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -55,17 +56,68 @@ type OracleConfig struct {
 }
 
 type NodeKeys struct {
-	EthAddress            string `json:"EthAddress"`
-	AptosAccount          string `json:"AptosAccount"`
-	AptosBundleID         string `json:"AptosBundleID"`
-	AptosOnchainPublicKey string `json:"AptosOnchainPublicKey"`
-	P2PPeerID             string `json:"P2PPeerID"`             // p2p_<key>
-	OCR2BundleID          string `json:"OCR2BundleID"`          // used only in job spec
-	OCR2OnchainPublicKey  string `json:"OCR2OnchainPublicKey"`  // ocr2on_evm_<key>
-	OCR2OffchainPublicKey string `json:"OCR2OffchainPublicKey"` // ocr2off_evm_<key>
-	OCR2ConfigPublicKey   string `json:"OCR2ConfigPublicKey"`   // ocr2cfg_evm_<key>
-	CSAPublicKey          string `json:"CSAPublicKey"`
-	EncryptionPublicKey   string `json:"EncryptionPublicKey"`
+	EthAddress               string `json:"EthAddress"`
+	AptosAccount             string `json:"AptosAccount"`
+	AptosBundleID            string `json:"AptosBundleID"`
+	AptosOnchainPublicKey    string `json:"AptosOnchainPublicKey"`
+	SolanaBundleID           string `json:"SolanaBundleID"`
+	SolanaOnchainPublicKey   string `json:"SolanaOnchainPublicKey"`
+	StarknetBundleID         string `json:"StarknetBundleID"`
+	StarknetOnchainPublicKey string `json:"StarknetOnchainPublicKey"` // stark curve public key, a felt
+	P2PPeerID                string `json:"P2PPeerID"`                // p2p_<key>
+	OCR2BundleID             string `json:"OCR2BundleID"`             // used only in job spec
+	OCR2OnchainPublicKey     string `json:"OCR2OnchainPublicKey"`     // ocr2on_evm_<key>
+	OCR2OffchainPublicKey    string `json:"OCR2OffchainPublicKey"`    // ocr2off_evm_<key>
+	OCR2ConfigPublicKey      string `json:"OCR2ConfigPublicKey"`      // ocr2cfg_evm_<key>
+	CSAPublicKey             string `json:"CSAPublicKey"`
+	EncryptionPublicKey      string `json:"EncryptionPublicKey"`
+	IsBootstrap              bool   `json:"IsBootstrap"` // bootstraps are not oracles of the ocr3 config
+}
+
+// Equal reports whether the keys are the same
+func (k NodeKeys) Equal(other NodeKeys) bool {
+	return k == other
+}
+
+// ValidateForOCR3 checks that the keys the ocr3 config is generated from are set and well formed: the evm onchain
+// signing address, the offchain and config public keys and the p2p peer id. All the invalid keys are reported
+func (k NodeKeys) ValidateForOCR3() error {
+	var errs []error
+	if k.OCR2OnchainPublicKey == "" {
+		errs = append(errs, errors.New("OCR2OnchainPublicKey is required"))
+	} else if _, err := parseOnchainSigner(chaintype.EVM, strings.TrimPrefix(k.OCR2OnchainPublicKey, "0x")); err != nil {
+		errs = append(errs, fmt.Errorf("invalid OCR2OnchainPublicKey: %w", err))
+	}
+	if k.OCR2OffchainPublicKey == "" {
+		errs = append(errs, errors.New("OCR2OffchainPublicKey is required"))
+	} else if b, err := hex.DecodeString(k.OCR2OffchainPublicKey); err != nil {
+		errs = append(errs, fmt.Errorf("invalid OCR2OffchainPublicKey '%s': %w", k.OCR2OffchainPublicKey, err))
+	} else if len(b) != ed25519.PublicKeySize {
+		errs = append(errs, fmt.Errorf("invalid OCR2OffchainPublicKey '%s': expected %d bytes got %d", k.OCR2OffchainPublicKey, ed25519.PublicKeySize, len(b)))
+	}
+	if k.OCR2ConfigPublicKey == "" {
+		errs = append(errs, errors.New("OCR2ConfigPublicKey is required"))
+	} else if err := validateConfigPublicKey(k.OCR2ConfigPublicKey); err != nil {
+		errs = append(errs, fmt.Errorf("invalid OCR2ConfigPublicKey: %w", err))
+	}
+	if k.P2PPeerID == "" {
+		errs = append(errs, errors.New("P2PPeerID is required"))
+	} else if _, err := normalizePeerID(k.P2PPeerID); err != nil {
+		errs = append(errs, fmt.Errorf("invalid P2PPeerID: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// PartitionNodeKeys splits the keys into those of bootstrap nodes and those of the signers, keeping their order
+func PartitionNodeKeys(keys []NodeKeys) (bootstraps, signers []NodeKeys) {
+	for _, k := range keys {
+		if k.IsBootstrap {
+			bootstraps = append(bootstraps, k)
+		} else {
+			signers = append(signers, k)
+		}
+	}
+	return bootstraps, signers
 }
 
 type Orc2drOracleConfig struct {
@@ -105,6 +157,28 @@ func (c Orc2drOracleConfig) MarshalJSON() ([]byte, error) {
 	return json.Marshal(alias)
 }
 
+// starknetOnchainPublicKeyLength is the length of a felt, which is how starknet nodes represent the key they sign reports with
+const starknetOnchainPublicKeyLength = 32
+
+// decodeStarknetOnchainPublicKey decodes a starknet public key. Unlike the other chains the key is a felt, which may
+// have a 0x prefix and omit leading zeros, so it is left padded to its full length
+func decodeStarknetOnchainPublicKey(key string) ([]byte, error) {
+	s := strings.TrimPrefix(key, "0x")
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid starknet onchain public key '%s': %w", key, err)
+	}
+	if len(b) > starknetOnchainPublicKeyLength {
+		return nil, fmt.Errorf("invalid starknet onchain public key '%s': expected at most %d bytes got %d", key, starknetOnchainPublicKeyLength, len(b))
+	}
+	out := make([]byte, starknetOnchainPublicKeyLength)
+	copy(out[starknetOnchainPublicKeyLength-len(b):], b)
+	return out, nil
+}
+
 func GenerateOCR3Config(cfg OracleConfigWithSecrets, nca []NodeKeys) (Orc2drOracleConfig, error) {
 	onchainPubKeys := [][]byte{}
 	allPubKeys := map[string]any{}
@@ -128,6 +202,22 @@ func GenerateOCR3Config(cfg OracleConfigWithSecrets, nca []NodeKeys) (Orc2drOrac
 			}
 			pubKeys[string(chaintype.Aptos)] = aptosPubKey
 		}
+		// add solana key if present
+		if n.SolanaOnchainPublicKey != "" {
+			solanaPubKey, err := hex.DecodeString(n.SolanaOnchainPublicKey)
+			if err != nil {
+				return Orc2drOracleConfig{}, fmt.Errorf("failed to decode SolanaOnchainPublicKey: %w", err)
+			}
+			pubKeys[string(chaintype.Solana)] = solanaPubKey
+		}
+		// add starknet key if present
+		if n.StarknetOnchainPublicKey != "" {
+			starknetPubKey, err := decodeStarknetOnchainPublicKey(n.StarknetOnchainPublicKey)
+			if err != nil {
+				return Orc2drOracleConfig{}, fmt.Errorf("failed to decode StarknetOnchainPublicKey: %w", err)
+			}
+			pubKeys[string(chaintype.StarkNet)] = starknetPubKey
+		}
 		// validate uniqueness of each individual key
 		for _, key := range pubKeys {
 			raw := hex.EncodeToString(key)
@@ -0,0 +1,279 @@
+package keystone
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+
+	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/keys/p2pkey"
+)
+
+// RegistrationActionType is a capabilities registry call
+type RegistrationActionType string
+
+const (
+	ActionAddCapabilities  RegistrationActionType = "addCapabilities"
+	ActionAddNodeOperators RegistrationActionType = "addNodeOperators"
+	ActionAddNodes         RegistrationActionType = "addNodes"
+	ActionAddDON           RegistrationActionType = "addDON"
+)
+
+// RegistrationAction is a registry call and its inputs. Only the inputs of the action type are set
+type RegistrationAction struct {
+	Type         RegistrationActionType
+	Capabilities []kcr.CapabilitiesRegistryCapability   // addCapabilities
+	Nops         []kcr.CapabilitiesRegistryNodeOperator // addNodeOperators
+	Nodes        []PlannedNode                          // addNodes
+	Don          *PlannedDon                            // addDON
+}
+
+// PlannedNode is a node to add. The registry assigns node operator ids, so the node operator is identified by name
+type PlannedNode struct {
+	NodeID       string
+	Nop          string
+	P2PID        p2pkey.PeerID
+	Signer       [32]byte
+	Capabilities []string // CapabilityID of the hosted capabilities
+}
+
+// PlannedDon is a don to add
+type PlannedDon struct {
+	Name             string
+	Nodes            []p2pkey.PeerID
+	Capabilities     []string // CapabilityID of the don capabilities
+	IsPublic         bool
+	AcceptsWorkflows bool
+	F                uint8
+}
+
+// RegistrationPlan is the ordered list of registry calls that ConfigureRegistry makes for the dons
+type RegistrationPlan struct {
+	Actions []RegistrationAction
+}
+
+// PlanRegistration returns the registry calls that ConfigureRegistry would make to register the dons, with the same inputs,
+// without making them. The calls are of the same types in the same sequence, capabilities, node operators, nodes then dons,
+// but ConfigureRegistry iterates over maps, so the order of the inputs and of the dons may differ; the plan follows the
+// input order. As in ConfigureRegistry, bootstrap nodes are not registered, dons of only bootstraps are not added, and
+// there is a node operator entry per node
+func PlanRegistration(ctx context.Context, dons []DonCapabilities, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (RegistrationPlan, error) {
+	donToNodes, err := mapDonsToNodes(ctx, dons, true, registryChainSel, opts...)
+	if err != nil {
+		return RegistrationPlan{}, fmt.Errorf("failed to map dons to nodes: %w", err)
+	}
+	// duplicate capabilities are logged when they are registered
+	donToCaps := mapDonsToCaps(logger.Nop(), dons)
+	nodeToCaps := mapNodesToCaps(dons)
+	donToF := mapDonsToF(dons)
+	o := newMapDonsToNodesOpts(opts)
+	nodeIDToNop, err := nodesToNops(dons, registryChainSel, o.bundleRole, o.selectorResolver())
+	if err != nil {
+		return RegistrationPlan{}, fmt.Errorf("failed to map nodes to nops: %w", err)
+	}
+
+	// capabilities are deduplicated across dons and node overrides
+	allCaps := AllCapabilities(dons)
+	for _, caps := range nodeToCaps {
+		for _, c := range caps {
+			if !slices.ContainsFunc(allCaps, func(a kcr.CapabilitiesRegistryCapability) bool { return CapabilityID(a) == CapabilityID(c) }) {
+				allCaps = append(allCaps, c)
+			}
+		}
+	}
+	slices.SortStableFunc(allCaps, compareCapabilities)
+
+	// a node in more than one don hosts the capabilities of all of them, unless it has an override
+	var nodes []PlannedNode
+	nodeIndex := make(map[string]int)
+	for _, don := range dons {
+		donCaps := capabilityIDs(donToCaps[DonName(don.Name)])
+		for _, n := range donToNodes[DonName(don.Name)] {
+			caps := donCaps
+			if override, ok := nodeToCaps[n.ID]; ok {
+				caps = capabilityIDs(override)
+			}
+			if i, exists := nodeIndex[n.ID]; exists {
+				for _, c := range caps {
+					if !slices.Contains(nodes[i].Capabilities, c) {
+						nodes[i].Capabilities = append(nodes[i].Capabilities, c)
+					}
+				}
+				continue
+			}
+			nodeIndex[n.ID] = len(nodes)
+			nodes = append(nodes, PlannedNode{
+				NodeID:       n.ID,
+				Nop:          nodeIDToNop[n.ID].Name,
+				P2PID:        n.P2PKey,
+				Signer:       n.Signer,
+				Capabilities: slices.Clone(caps),
+			})
+		}
+	}
+
+	plan := RegistrationPlan{
+		Actions: []RegistrationAction{
+			{Type: ActionAddCapabilities, Capabilities: allCaps},
+			{Type: ActionAddNodeOperators, Nops: SortedNops(nodeIDToNop)},
+			{Type: ActionAddNodes, Nodes: nodes},
+		},
+	}
+	for _, don := range dons {
+		donNodes := donToNodes[DonName(don.Name)]
+		if len(donNodes) == 0 {
+			continue
+		}
+		pd := &PlannedDon{
+			Name:         don.Name,
+			Capabilities: capabilityIDs(donToCaps[DonName(don.Name)]),
+			IsPublic:     true,
+			F:            uint8(len(donNodes) / 3), // as in registerDons
+		}
+		if f, ok := donToF[DonName(don.Name)]; ok {
+			if err := validateF(don.Name, f, len(donNodes)); err != nil {
+				return RegistrationPlan{}, err
+			}
+			pd.F = f
+		}
+		for _, n := range donNodes {
+			pd.Nodes = append(pd.Nodes, n.P2PKey)
+		}
+		for _, c := range donToCaps[DonName(don.Name)] {
+			if c.CapabilityType == 2 { // OCR3 capability => WF supported
+				pd.AcceptsWorkflows = true
+			}
+		}
+		plan.Actions = append(plan.Actions, RegistrationAction{Type: ActionAddDON, Don: pd})
+	}
+	return plan, nil
+}
+
+// EstimateRegistryTxCount returns the number of transactions that registering the dons submits to the registry, eg for gas
+// budgeting: a transaction for each of the addCapabilities, addNodeOperators and addNodes calls of the plan that have
+// inputs, and one per addDON. It is a lower bound; calls that fall back to adding their inputs one by one, such as when
+// some capabilities already exist, submit a transaction per input
+func EstimateRegistryTxCount(ctx context.Context, dons []DonCapabilities, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (int, error) {
+	plan, err := PlanRegistration(ctx, dons, registryChainSel, opts...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to plan registration: %w", err)
+	}
+	n := 0
+	for _, a := range plan.Actions {
+		switch a.Type {
+		case ActionAddCapabilities:
+			if len(a.Capabilities) == 0 {
+				continue
+			}
+		case ActionAddNodeOperators:
+			if len(a.Nops) == 0 {
+				continue
+			}
+		case ActionAddNodes:
+			if len(a.Nodes) == 0 {
+				continue
+			}
+		}
+		n++
+	}
+	return n, nil
+}
+
+// SummarizeRegistration returns a human readable report of what registering the dons adds to the registry: the number
+// of dons, distinct nops, distinct nodes and capabilities, followed by a line per don with its nodes and capabilities
+func SummarizeRegistration(dons []DonCapabilities, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (string, error) {
+	nNops, err := DistinctNops(dons, registryChainSel, opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to count nops: %w", err)
+	}
+	nodes := make(map[string]struct{})
+	bootstraps := make(map[string]struct{})
+	for _, don := range dons {
+		for _, nop := range don.Nops {
+			if nop == nil {
+				continue
+			}
+			for _, n := range nop.Nodes {
+				if n == nil {
+					continue
+				}
+				nodes[n.ID] = struct{}{}
+				if isCloBootstrap(n) {
+					bootstraps[n.ID] = struct{}{}
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "registration on chain selector %d\n", registryChainSel)
+	fmt.Fprintf(&b, "dons: %d\n", len(dons))
+	fmt.Fprintf(&b, "nops: %d\n", nNops)
+	fmt.Fprintf(&b, "nodes: %d (%d bootstrap)\n", len(nodes), len(bootstraps))
+	fmt.Fprintf(&b, "capabilities: %d", len(AllCapabilities(dons)))
+	for _, don := range dons {
+		n := 0
+		for _, nop := range don.Nops {
+			if nop == nil {
+				continue
+			}
+			for _, node := range nop.Nodes {
+				if node != nil {
+					n++
+				}
+			}
+		}
+		fmt.Fprintf(&b, "\ndon %s: %d nodes, capabilities [%s]", don.Name, n, strings.Join(capabilityIDs(don.Capabilities), ","))
+	}
+	return b.String(), nil
+}
+
+func capabilityIDs(caps []kcr.CapabilitiesRegistryCapability) []string {
+	out := make([]string, 0, len(caps))
+	for _, c := range caps {
+		out = append(out, CapabilityID(c))
+	}
+	return out
+}
+
+// String returns a line per action followed by an indented line per input
+func (p RegistrationPlan) String() string {
+	var b strings.Builder
+	for i, a := range p.Actions {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(a.String())
+	}
+	return b.String()
+}
+
+func (a RegistrationAction) String() string {
+	var b strings.Builder
+	b.WriteString(string(a.Type))
+	switch a.Type {
+	case ActionAddCapabilities:
+		for _, c := range a.Capabilities {
+			fmt.Fprintf(&b, "\n  capability %s", CapabilityID(c))
+		}
+	case ActionAddNodeOperators:
+		for _, nop := range a.Nops {
+			fmt.Fprintf(&b, "\n  nop %s admin %s", nop.Name, nop.Admin.Hex())
+		}
+	case ActionAddNodes:
+		for _, n := range a.Nodes {
+			fmt.Fprintf(&b, "\n  node %s nop %s p2p %s signer %x capabilities [%s]", n.NodeID, n.Nop, n.P2PID, n.Signer, strings.Join(n.Capabilities, ","))
+		}
+	case ActionAddDON:
+		if a.Don != nil {
+			fmt.Fprintf(&b, " %s f=%d public=%t workflows=%t capabilities [%s]", a.Don.Name, a.Don.F, a.Don.IsPublic, a.Don.AcceptsWorkflows, strings.Join(a.Don.Capabilities, ","))
+			for _, p := range a.Don.Nodes {
+				fmt.Fprintf(&b, "\n  node %s", p)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,232 @@
+package keystone
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/require"
+
+	chainsel "github.com/smartcontractkit/chain-selectors"
+
+	"github.com/smartcontractkit/chainlink-common/pkg/utils/tests"
+	"github.com/smartcontractkit/chainlink/deployment/environment/clo/models"
+	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/keys/p2pkey"
+)
+
+func TestPlanRegistration(t *testing.T) {
+	registryChainSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	var (
+		peerIDs []p2pkey.PeerID
+		nodes   []*models.Node
+	)
+	for i := 1; i <= 9; i++ {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		peerIDs = append(peerIDs, p)
+		// the last node is the workflow don bootstrap
+		nodes = append(nodes, newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), i == 9))
+	}
+	dons := []DonCapabilities{
+		{
+			Name: "workflow",
+			Nops: []*models.NodeOperator{
+				{Name: "nop 1", Nodes: []*models.Node{nodes[0], nodes[1], nodes[8]}},
+				{Name: "nop 2", Nodes: []*models.Node{nodes[2], nodes[3]}},
+			},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		},
+		{
+			Name: "writer",
+			Nops: []*models.NodeOperator{
+				{Name: "nop 1", Nodes: []*models.Node{nodes[4], nodes[5]}},
+				{Name: "nop 2", Nodes: []*models.Node{nodes[6], nodes[7]}},
+			},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap},
+		},
+	}
+
+	plan, err := PlanRegistration(tests.Context(t), dons, registryChainSel)
+	require.NoError(t, err)
+	var types []RegistrationActionType
+	for _, a := range plan.Actions {
+		types = append(types, a.Type)
+	}
+	require.Equal(t, []RegistrationActionType{ActionAddCapabilities, ActionAddNodeOperators, ActionAddNodes, ActionAddDON, ActionAddDON}, types)
+
+	assert.ElementsMatch(t, []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap}, plan.Actions[0].Capabilities)
+
+	// ConfigureRegistry adds a node operator per node, including bootstraps. newTestCloNode uses admin 0x...01
+	nops := plan.Actions[1].Nops
+	require.Len(t, nops, 9)
+	assert.Equal(t, kcr.CapabilitiesRegistryNodeOperator{Name: "nop 1", Admin: common.HexToAddress("0x01")}, nops[0])
+	assert.Equal(t, kcr.CapabilitiesRegistryNodeOperator{Name: "nop 2", Admin: common.HexToAddress("0x01")}, nops[8])
+
+	// the nodes of each don are ordered by peer id
+	byPeerID := func(ids []p2pkey.PeerID) []p2pkey.PeerID {
+		ids = slices.Clone(ids)
+		slices.SortFunc(ids, func(a, b p2pkey.PeerID) int { return strings.Compare(a.String(), b.String()) })
+		return ids
+	}
+	planned := plan.Actions[2].Nodes
+	require.Len(t, planned, 8, "the bootstrap is not registered")
+	i := slices.IndexFunc(planned, func(n PlannedNode) bool { return n.NodeID == "node-1" })
+	require.GreaterOrEqual(t, i, 0)
+	assert.Less(t, i, 4, "the workflow don nodes are first")
+	assert.Equal(t, PlannedNode{
+		NodeID:       "node-1",
+		Nop:          "nop 1",
+		P2PID:        peerIDs[0],
+		Signer:       [32]byte{19: 1},
+		Capabilities: []string{CapabilityID(OCR3Cap)},
+	}, planned[i])
+	for _, n := range planned[4:] {
+		assert.Equal(t, []string{CapabilityID(WriteChainCap)}, n.Capabilities)
+	}
+
+	workflow := plan.Actions[3].Don
+	require.NotNil(t, workflow)
+	assert.Equal(t, "workflow", workflow.Name)
+	assert.Equal(t, byPeerID(peerIDs[:4]), workflow.Nodes)
+	assert.Equal(t, uint8(1), workflow.F)
+	assert.True(t, workflow.AcceptsWorkflows)
+	writer := plan.Actions[4].Don
+	require.NotNil(t, writer)
+	assert.Equal(t, "writer", writer.Name)
+	assert.Equal(t, byPeerID(peerIDs[4:8]), writer.Nodes)
+	assert.False(t, writer.AcceptsWorkflows)
+
+	s := plan.String()
+	assert.Contains(t, s, "addCapabilities\n  capability ")
+	assert.Contains(t, s, "addNodeOperators\n  nop nop 1 admin ")
+	assert.Contains(t, s, "\n  node node-1 nop nop 1 p2p "+peerIDs[0].String())
+	assert.Contains(t, s, "addDON workflow f=1 public=true workflows=true capabilities ["+CapabilityID(OCR3Cap)+"]")
+
+	t.Run("explicit f", func(t *testing.T) {
+		dons := slices.Clone(dons)
+		zero := uint8(0)
+		dons[1].F = &zero
+		plan, err := PlanRegistration(tests.Context(t), dons, registryChainSel)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(1), plan.Actions[3].Don.F, "the workflow don f is computed")
+		assert.Equal(t, uint8(0), plan.Actions[4].Don.F)
+
+		two := uint8(2)
+		dons[1].F = &two
+		_, err = PlanRegistration(tests.Context(t), dons, registryChainSel)
+		require.ErrorContains(t, err, "don writer has f=2, which requires at least 7 signers but it has 4")
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		_, err := PlanRegistration(tests.Context(t), dons, chainsel.ETHEREUM_MAINNET.Selector)
+		require.Error(t, err)
+	})
+}
+
+func TestEstimateRegistryTxCount(t *testing.T) {
+	registryChainSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	var nodes []*models.Node
+	for i := 1; i <= 8; i++ {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		nodes = append(nodes, newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), false))
+	}
+	dons := []DonCapabilities{
+		{
+			Name:         "workflow",
+			Nops:         []*models.NodeOperator{{Name: "nop 1", Nodes: nodes[:4]}},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		},
+		{
+			Name:         "writer",
+			Nops:         []*models.NodeOperator{{Name: "nop 2", Nodes: nodes[4:]}},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap},
+		},
+	}
+
+	// addCapabilities, addNodeOperators and addNodes are one call each, with one addDON per don
+	got, err := EstimateRegistryTxCount(tests.Context(t), dons, registryChainSel)
+	require.NoError(t, err)
+	assert.Equal(t, 5, got)
+
+	got, err = EstimateRegistryTxCount(tests.Context(t), dons[:1], registryChainSel)
+	require.NoError(t, err)
+	assert.Equal(t, 4, got)
+
+	// a don of only bootstraps is not added
+	bootstrap := newTestCloNode("node-9", p2pkey.MustNewV2XXXTestingOnly(big.NewInt(9)).PeerID().String(), fmt.Sprintf("%040x", 9), true)
+	withBootstraps := append(slices.Clone(dons), DonCapabilities{
+		Name:         "bootstraps",
+		Nops:         []*models.NodeOperator{{Name: "nop 3", Nodes: []*models.Node{bootstrap}}},
+		Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+	})
+	got, err = EstimateRegistryTxCount(tests.Context(t), withBootstraps, registryChainSel)
+	require.NoError(t, err)
+	assert.Equal(t, 5, got)
+	plan, err := PlanRegistration(tests.Context(t), withBootstraps, registryChainSel)
+	require.NoError(t, err)
+	for _, a := range plan.Actions {
+		if a.Type == ActionAddDON {
+			assert.NotEqual(t, "bootstraps", a.Don.Name)
+		}
+	}
+
+	_, err = EstimateRegistryTxCount(tests.Context(t), dons, chainsel.ETHEREUM_MAINNET.Selector)
+	require.ErrorContains(t, err, "failed to plan registration")
+}
+
+func TestSummarizeRegistration(t *testing.T) {
+	registryChainSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	var nodes []*models.Node
+	for i := 1; i <= 7; i++ {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		nodes = append(nodes, newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), i == 7))
+	}
+	// nop 1 has a different admin, so it is a different nop in each don
+	nodes[4].ChainConfigs[0].AdminAddress = "0x0000000000000000000000000000000000000002"
+	dons := []DonCapabilities{
+		{
+			Name: "workflow",
+			Nops: []*models.NodeOperator{
+				{Name: "nop 1", Nodes: []*models.Node{nodes[0], nodes[1], nodes[6]}},
+				{Name: "nop 2", Nodes: []*models.Node{nodes[2], nodes[3]}},
+			},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		},
+		{
+			Name: "writer",
+			Nops: []*models.NodeOperator{
+				{Name: "nop 1", Nodes: []*models.Node{nodes[4], nodes[5]}},
+				{Name: "nop 2", Nodes: []*models.Node{nodes[3]}},
+			},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap, OCR3Cap},
+		},
+	}
+
+	got, err := SummarizeRegistration(dons, registryChainSel)
+	require.NoError(t, err)
+	for _, want := range []string{
+		fmt.Sprintf("registration on chain selector %d\n", registryChainSel),
+		"dons: 2\n",
+		"nops: 3\n",
+		"nodes: 7 (1 bootstrap)\n",
+		"capabilities: 2\n",
+		"don workflow: 5 nodes, capabilities [" + CapabilityID(OCR3Cap) + "]",
+		"don writer: 3 nodes, capabilities [" + CapabilityID(WriteChainCap) + "," + CapabilityID(OCR3Cap) + "]",
+	} {
+		assert.Contains(t, got, want)
+	}
+
+	// nil nodes and nops are reported by Validate and not counted
+	dons[0].Nops = append(dons[0].Nops, nil, &models.NodeOperator{Name: "nop 3", Nodes: []*models.Node{nil}})
+	got, err = SummarizeRegistration(dons, registryChainSel)
+	require.NoError(t, err)
+	assert.Contains(t, got, "nodes: 7 (1 bootstrap)\n")
+	assert.Contains(t, got, "don workflow: 5 nodes")
+
+	_, err = SummarizeRegistration(dons, chainsel.ETHEREUM_MAINNET.Selector)
+	require.ErrorContains(t, err, "failed to count nops")
+}
@@ -1,17 +1,31 @@
 package keystone
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"maps"
+	"math"
+	"net"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/sync/errgroup"
 
 	chainsel "github.com/smartcontractkit/chain-selectors"
 
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+
 	"github.com/smartcontractkit/chainlink/deployment"
 	"github.com/smartcontractkit/chainlink/deployment/environment/clo/models"
 
@@ -19,6 +33,7 @@ import (
 
 	"github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
 	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+	kf "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/forwarder"
 	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/chaintype"
 	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/keys/p2pkey"
 )
@@ -30,6 +45,13 @@ var (
 	FeedConsumer         deployment.ContractType = "FeedConsumer"         // no type and a version in contract https://github.com/smartcontractkit/chainlink/blob/89183a8a5d22b1aeca0ade3b76d16aa84067aa57/contracts/src/v0.8/keystone/KeystoneFeedsConsumer.sol#L1
 )
 
+// aliases of the capabilities registry types, so that callers do not have to import the generated wrapper package
+type (
+	Capability   = kcr.CapabilitiesRegistryCapability
+	NodeOperator = kcr.CapabilitiesRegistryNodeOperator
+	DONInfo      = kcr.CapabilitiesRegistryDONInfo
+)
+
 type DeployResponse struct {
 	Address common.Address
 	Tx      common.Hash // todo: chain agnostic
@@ -40,67 +62,388 @@ type DeployRequest struct {
 	Chain deployment.Chain
 }
 
+// BatchDeployRequest is a deploy request for multiple chains
+type BatchDeployRequest struct {
+	Chains []deployment.Chain
+}
+
+// BatchDeployResponse are the outcomes of a BatchDeployRequest keyed by chain selector.
+// Each chain is in exactly one of Responses or Errors
+type BatchDeployResponse struct {
+	Responses map[uint64]DeployResponse
+	Errors    map[uint64]error
+}
+
+// Err joins the errors of all failed chains, ordered by chain selector. It is nil if all chains succeeded
+func (r BatchDeployResponse) Err() error {
+	sels := make([]uint64, 0, len(r.Errors))
+	for sel := range r.Errors {
+		sels = append(sels, sel)
+	}
+	slices.Sort(sels)
+	var errs []error
+	for _, sel := range sels {
+		errs = append(errs, fmt.Errorf("chain %d: %w", sel, r.Errors[sel]))
+	}
+	return errors.Join(errs...)
+}
+
+// BatchDeploy runs deploy on each chain of the request. A failure on one chain does not prevent deploying to the
+// remaining chains; the outcome of every chain is reported in the response
+func BatchDeploy(req BatchDeployRequest, deploy func(DeployRequest) (*DeployResponse, error)) BatchDeployResponse {
+	resp := BatchDeployResponse{
+		Responses: make(map[uint64]DeployResponse),
+		Errors:    make(map[uint64]error),
+	}
+	for _, chain := range req.Chains {
+		sel := chain.Selector
+		_, deployed := resp.Responses[sel]
+		if _, failed := resp.Errors[sel]; deployed || failed {
+			// a chain is deployed to at most once
+			continue
+		}
+		r, err := deploy(DeployRequest{Chain: chain})
+		if err != nil {
+			resp.Errors[sel] = err
+			continue
+		}
+		if r == nil {
+			resp.Errors[sel] = fmt.Errorf("deploy returned no response")
+			continue
+		}
+		resp.Responses[sel] = *r
+	}
+	return resp
+}
+
 type DonNode struct {
 	Don  string
 	Node string // not unique across environments
 }
 
+func (d DonNode) String() string {
+	return fmt.Sprintf("don=%s node=%s", d.Don, d.Node)
+}
+
 type CapabilityHost struct {
 	NodeID       string // globally unique
 	Capabilities []capabilities_registry.CapabilitiesRegistryCapability
 }
 
+// MergeCapabilityHosts combines the hosts with the same node id into one host with the union of their capabilities.
+// Capabilities keep the order in which they are first seen and the hosts are ordered by node id
+func MergeCapabilityHosts(hosts []CapabilityHost) []CapabilityHost {
+	byNode := make(map[string]*CapabilityHost)
+	for _, h := range hosts {
+		merged, ok := byNode[h.NodeID]
+		if !ok {
+			merged = &CapabilityHost{NodeID: h.NodeID}
+			byNode[h.NodeID] = merged
+		}
+		for _, c := range h.Capabilities {
+			if !slices.Contains(merged.Capabilities, c) {
+				merged.Capabilities = append(merged.Capabilities, c)
+			}
+		}
+	}
+	out := make([]CapabilityHost, 0, len(byNode))
+	for _, h := range byNode {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeID < out[j].NodeID })
+	return out
+}
+
 type Nop struct {
 	capabilities_registry.CapabilitiesRegistryNodeOperator
 	NodeIDs []string // nodes run by this operator
 }
 
-// ocr2Node is a subset of the node configuration that is needed to register a node
+// String returns the name, admin and sorted node ids of the operator
+func (n Nop) String() string {
+	ids := slices.Clone(n.NodeIDs)
+	slices.Sort(ids)
+	return fmt.Sprintf("name=%s admin=%s nodes=[%s]", n.Name, n.Admin.Hex(), strings.Join(ids, ","))
+}
+
+// Ocr2Node is a subset of the node configuration that is needed to register a node
 // with the capabilities registry. Signer and P2PKey are chain agnostic.
 // TODO: KS-466 when we migrate fully to the JD offchain client, we should be able remove this shim and use environment.Node directly
-type ocr2Node struct {
+type Ocr2Node struct {
 	ID                  string
 	Signer              [32]byte // note that in capabilities registry we need a [32]byte, but in the forwarder we need a common.Address [20]byte
 	P2PKey              p2pkey.PeerID
 	EncryptionPublicKey [32]byte
 	IsBoostrap          bool
+	// Labels are free form labels of the node, eg region or environment, for observability. They are not registered
+	Labels map[string]string
 	// useful when have to register the ocr3 contract config
-	p2pKeyBundle       *v1.OCR2Config_P2PKeyBundle
-	ethOcr2KeyBundle   *v1.OCR2Config_OCRKeyBundle
-	aptosOcr2KeyBundle *v1.OCR2Config_OCRKeyBundle
-	csaKey             string // *v1.Node.PublicKey
-	accountAddress     string
+	p2pKeyBundle     *v1.OCR2Config_P2PKeyBundle
+	keyBundles       map[chaintype.ChainType]*v1.OCR2Config_OCRKeyBundle // evm is always present, other chains are optional
+	onchainSigners   map[chaintype.ChainType][]byte                      // decoded onchain signer of the aptos and solana key bundles
+	csaKey           string                                              // *v1.Node.PublicKey
+	accountAddresses map[chaintype.ChainType]string                      // account address of the node by chain type, from its chain configs
+	// encryptionPublicKey is the hex encoded encryption key when it is distinct from the csa key, empty otherwise
+	encryptionPublicKey string
+	// networkAddress is the p2p address of the node, from the multiaddr of its registry chain config. It is either a
+	// multiaddr such as /ip4/127.0.0.1/tcp/6690 or host:port, and empty when the chain config has none
+	networkAddress string
+}
+
+// IsBootstrap reports whether the node is a bootstrap node. It is the correctly spelled accessor for IsBoostrap
+func (o *Ocr2Node) IsBootstrap() bool {
+	return o.IsBoostrap
+}
+
+// EncryptionPublicKeyHex returns the lowercase hex encoding of EncryptionPublicKey, without a prefix
+func (o *Ocr2Node) EncryptionPublicKeyHex() string {
+	return hex.EncodeToString(o.EncryptionPublicKey[:])
+}
+
+// Equal reports whether the nodes have the same keys. The key bundles are compared by bundle id rather than by pointer
+func (o *Ocr2Node) Equal(other *Ocr2Node) bool {
+	if o == nil || other == nil {
+		return o == other
+	}
+	if o.ID != other.ID || o.Signer != other.Signer || o.P2PKey != other.P2PKey ||
+		o.EncryptionPublicKey != other.EncryptionPublicKey || o.IsBoostrap != other.IsBoostrap {
+		return false
+	}
+	if len(o.keyBundles) != len(other.keyBundles) {
+		return false
+	}
+	for ct, b := range o.keyBundles {
+		ob, ok := other.keyBundles[ct]
+		if !ok || b.GetBundleId() != ob.GetBundleId() {
+			return false
+		}
+	}
+	return maps.Equal(o.accountAddresses, other.accountAddresses)
 }
 
-func (o *ocr2Node) signerAddress() common.Address {
+func (o *Ocr2Node) signerAddress() common.Address {
 	// eth address is the first 20 bytes of the Signer
 	return common.BytesToAddress(o.Signer[:20])
 }
 
-func (o *ocr2Node) toNodeKeys() NodeKeys {
-	var aptosOcr2KeyBundleId string
-	var aptosOnchainPublicKey string
-	if o.aptosOcr2KeyBundle != nil {
-		aptosOcr2KeyBundleId = o.aptosOcr2KeyBundle.BundleId
-		aptosOnchainPublicKey = o.aptosOcr2KeyBundle.OnchainSigningAddress
-	}
-	return NodeKeys{
-		EthAddress:            o.accountAddress,
-		P2PPeerID:             strings.TrimPrefix(o.p2pKeyBundle.PeerId, "p2p_"),
-		OCR2BundleID:          o.ethOcr2KeyBundle.BundleId,
-		OCR2OnchainPublicKey:  o.ethOcr2KeyBundle.OnchainSigningAddress,
-		OCR2OffchainPublicKey: o.ethOcr2KeyBundle.OffchainPublicKey,
-		OCR2ConfigPublicKey:   o.ethOcr2KeyBundle.ConfigPublicKey,
+// NodeKeysOptions is the formatting of the keys of a node
+type NodeKeysOptions struct {
+	// StripPrefixes removes the p2p_ prefix of the peer id and the csa_ prefix of the csa key when it is used as the
+	// encryption key
+	StripPrefixes bool
+}
+
+func (o *Ocr2Node) toNodeKeys() NodeKeys {
+	return o.toNodeKeysWithOptions(NodeKeysOptions{StripPrefixes: true})
+}
+
+func (o *Ocr2Node) toNodeKeysWithOptions(opts NodeKeysOptions) NodeKeys {
+	evm := o.keyBundles[chaintype.EVM]
+	trim := func(s, prefix string) string {
+		if opts.StripPrefixes {
+			return strings.TrimPrefix(s, prefix)
+		}
+		return s
+	}
+	nk := NodeKeys{
+		EthAddress:            o.accountAddresses[chaintype.EVM],
+		AptosAccount:          o.accountAddresses[chaintype.Aptos],
+		P2PPeerID:             trim(o.p2pKeyBundle.PeerId, "p2p_"),
+		OCR2BundleID:          evm.BundleId,
+		OCR2OnchainPublicKey:  evm.OnchainSigningAddress,
+		OCR2OffchainPublicKey: evm.OffchainPublicKey,
+		OCR2ConfigPublicKey:   evm.ConfigPublicKey,
 		CSAPublicKey:          o.csaKey,
 		// default value of encryption public key is the CSA public key
 		// TODO: DEVSVCS-760
-		EncryptionPublicKey: strings.TrimPrefix(o.csaKey, "csa_"),
-		// TODO Aptos support. How will that be modeled in clo data?
-		AptosBundleID:         aptosOcr2KeyBundleId,
-		AptosOnchainPublicKey: aptosOnchainPublicKey,
+		EncryptionPublicKey: trim(o.csaKey, "csa_"),
+		IsBootstrap:         o.IsBoostrap,
+	}
+	if o.encryptionPublicKey != "" {
+		nk.EncryptionPublicKey = o.encryptionPublicKey
+	}
+	// TODO Aptos support. How will that be modeled in clo data?
+	if aptos, ok := o.keyBundles[chaintype.Aptos]; ok && aptos != nil {
+		nk.AptosBundleID = aptos.BundleId
+		nk.AptosOnchainPublicKey = aptos.OnchainSigningAddress
+	}
+	if solana, ok := o.keyBundles[chaintype.Solana]; ok && solana != nil {
+		nk.SolanaBundleID = solana.BundleId
+		nk.SolanaOnchainPublicKey = solana.OnchainSigningAddress
+	}
+	if starknet, ok := o.starknetOcr2KeyBundle(); ok {
+		nk.StarknetBundleID = starknet.BundleId
+		nk.StarknetOnchainPublicKey = starknet.OnchainSigningAddress
+	}
+	return nk
+}
+
+// AccountAddress returns the account address of the node on chains of the given type, if it has a chain config with one
+func (o *Ocr2Node) AccountAddress(ct chaintype.ChainType) (string, bool) {
+	addr, ok := o.accountAddresses[ct]
+	return addr, ok
+}
+
+// starknetOcr2KeyBundle returns the starknet key bundle of the node, if it has one. The onchain signing address of the
+// bundle is a stark curve public key rather than an address; see decodeStarknetOnchainPublicKey
+func (o *Ocr2Node) starknetOcr2KeyBundle() (*v1.OCR2Config_OCRKeyBundle, bool) {
+	kb, ok := o.keyBundles[chaintype.StarkNet]
+	return kb, ok && kb != nil
+}
+
+// aptosOnchainPublicKeyLength is the length of the ed25519 public key that aptos nodes sign reports with
+const aptosOnchainPublicKeyLength = 32
+
+// solanaOnchainPublicKeyLength is the length of the ed25519 public key that solana nodes sign reports with
+const solanaOnchainPublicKeyLength = 32
+
+// onchainSignerLength returns the length of the onchain signer of the chain type: the 20 byte address on evm and the
+// 32 byte ed25519 public key on aptos and solana
+func onchainSignerLength(ct chaintype.ChainType) (int, error) {
+	switch ct {
+	case chaintype.EVM:
+		return common.AddressLength, nil
+	case chaintype.Aptos:
+		return aptosOnchainPublicKeyLength, nil
+	case chaintype.Solana:
+		return solanaOnchainPublicKeyLength, nil
+	default:
+		return 0, fmt.Errorf("unsupported chain type %s", ct)
+	}
+}
+
+// parseOnchainSigner decodes the hex onchain signing address of a key bundle of the chain type. It returns all the
+// bytes of the signer, which must have the length of the chain type's signer
+func parseOnchainSigner(ct chaintype.ChainType, s string) ([]byte, error) {
+	wantLen, err := onchainSignerLength(ct)
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s signer '%s': %w", ct, s, err)
+	}
+	if len(b) != wantLen {
+		return nil, fmt.Errorf("invalid %s signer '%s': expected %d bytes got %d", ct, s, wantLen, len(b))
+	}
+	return b, nil
+}
+
+// signerForChain returns the onchain signer of the node on the chain type. The evm signer is the 20 byte address in
+// Signer; aptos and solana sign with a 32 byte key from their key bundle, so the node must have a bundle for them
+func (o *Ocr2Node) signerForChain(ct chaintype.ChainType) ([]byte, error) {
+	if _, err := onchainSignerLength(ct); err != nil {
+		return nil, fmt.Errorf("%w for signer of node %s", err, o.ID)
+	}
+	if ct == chaintype.EVM {
+		return o.signerAddress().Bytes(), nil
+	}
+	if b, ok := o.onchainSigners[ct]; ok {
+		return b, nil
+	}
+	kb, ok := o.keyBundles[ct]
+	if !ok || kb == nil {
+		return nil, fmt.Errorf("node %s has no %s key bundle", o.ID, ct)
+	}
+	b, err := parseOnchainSigner(ct, kb.OnchainSigningAddress)
+	if err != nil {
+		return nil, fmt.Errorf("%w for node %s", err, o.ID)
+	}
+	return b, nil
+}
+
+// toNodeKeysChecked is toNodeKeys that also validates the aptos onchain public key, if the node has an aptos bundle
+func (o *Ocr2Node) toNodeKeysChecked() (NodeKeys, error) {
+	if aptos, ok := o.keyBundles[chaintype.Aptos]; ok && aptos != nil {
+		if _, err := parseOnchainSigner(chaintype.Aptos, aptos.OnchainSigningAddress); err != nil {
+			return NodeKeys{}, fmt.Errorf("invalid aptos onchain public key for node %s: %w", o.ID, err)
+		}
+	}
+	if starknet, ok := o.starknetOcr2KeyBundle(); ok {
+		if _, err := decodeStarknetOnchainPublicKey(starknet.OnchainSigningAddress); err != nil {
+			return NodeKeys{}, fmt.Errorf("node %s: %w", o.ID, err)
+		}
+	}
+	return o.toNodeKeys(), nil
+}
+
+// ToOcr2Node reconstructs the node from its keys with the same validation as NewOcr2Node.
+// NodeKeys does not record the p2p public key, so it is left unset
+func (k NodeKeys) ToOcr2Node(id string) (*Ocr2Node, error) {
+	ccfgs := map[chaintype.ChainType]*v1.ChainConfig{
+		chaintype.EVM: {
+			AccountAddress: k.EthAddress,
+			Ocr2Config: &v1.OCR2Config{
+				IsBootstrap: k.IsBootstrap,
+				P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{
+					PeerId: k.P2PPeerID,
+				},
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					BundleId:              k.OCR2BundleID,
+					OnchainSigningAddress: k.OCR2OnchainPublicKey,
+					OffchainPublicKey:     k.OCR2OffchainPublicKey,
+					ConfigPublicKey:       k.OCR2ConfigPublicKey,
+				},
+			},
+		},
+	}
+	if k.AptosBundleID != "" || k.AptosOnchainPublicKey != "" || k.AptosAccount != "" {
+		ccfgs[chaintype.Aptos] = &v1.ChainConfig{
+			AccountAddress: k.AptosAccount,
+			Ocr2Config: &v1.OCR2Config{
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					BundleId:              k.AptosBundleID,
+					OnchainSigningAddress: k.AptosOnchainPublicKey,
+				},
+			},
+		}
+	}
+	if k.SolanaBundleID != "" || k.SolanaOnchainPublicKey != "" {
+		ccfgs[chaintype.Solana] = &v1.ChainConfig{
+			Ocr2Config: &v1.OCR2Config{
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					BundleId:              k.SolanaBundleID,
+					OnchainSigningAddress: k.SolanaOnchainPublicKey,
+				},
+			},
+		}
+	}
+	if k.StarknetBundleID != "" || k.StarknetOnchainPublicKey != "" {
+		ccfgs[chaintype.StarkNet] = &v1.ChainConfig{
+			Ocr2Config: &v1.OCR2Config{
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					BundleId:              k.StarknetBundleID,
+					OnchainSigningAddress: k.StarknetOnchainPublicKey,
+				},
+			},
+		}
+	}
+	var opts []func(*Ocr2NodeOpts)
+	if k.EncryptionPublicKey != strings.TrimPrefix(k.CSAPublicKey, "csa_") {
+		opts = append(opts, WithEncryptionPublicKey(k.EncryptionPublicKey))
+	}
+	n, err := NewOcr2Node(id, ccfgs, k.CSAPublicKey, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ocr2 node %s from node keys: %w", id, err)
+	}
+	if _, err := n.toNodeKeysChecked(); err != nil {
+		return nil, err
 	}
+	return n, nil
 }
-func newOcr2NodeFromClo(n *models.Node, registryChainSel uint64) (*ocr2Node, error) {
+
+// defaultBundleRole selects the first registry chain config of a node
+const defaultBundleRole = ""
+
+// newOcr2NodeFromClo converts a CLO node using its registry chain config. The node is a bootstrap if any of its
+// chain configs is a bootstrap config, not only the registry chain config, since CLO data does not always set the
+// flag on every chain config of a bootstrap node.
+// A node that has more than one ocr key bundle for the registry chain has a chain config per bundle. CLO does not label
+// chain configs, so bundleRole is the id of the ocr key bundle to use; defaultBundleRole uses the first chain config
+func newOcr2NodeFromClo(ctx context.Context, n *models.Node, registryChainSel uint64, bundleRole string, resolver SelectorResolver, nodeOpts ...func(*Ocr2NodeOpts)) (*Ocr2Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if n.PublicKey == nil {
 		return nil, errors.New("no public key")
 	}
@@ -109,21 +452,169 @@ func newOcr2NodeFromClo(n *models.Node, registryChainSel uint64) (*ocr2Node, err
 		return nil, errors.New("no chain configs")
 	}
 	// all nodes should have an evm chain config, specifically the registry chain
-	evmCC, err := registryChainConfig(n.ChainConfigs, chaintype.EVM, registryChainSel)
+	evmCC, err := registryChainConfig(n.ID, n.ChainConfigs, chaintype.EVM, registryChainSel, bundleRole, resolver)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get registry chain config for sel %d: %w", registryChainSel, err)
 	}
 	cfgs := map[chaintype.ChainType]*v1.ChainConfig{
 		chaintype.EVM: evmCC,
 	}
-	aptosCC, exists := firstChainConfigByType(n.ChainConfigs, chaintype.Aptos)
+	aptosCC, exists, err := firstChainConfigByType(n.ChainConfigs, chaintype.Aptos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get aptos chain config: %w", err)
+	}
 	if exists {
 		cfgs[chaintype.Aptos] = aptosCC
 	}
-	return newOcr2Node(n.ID, cfgs, *n.PublicKey)
+	solanaCC, exists, err := firstChainConfigByType(n.ChainConfigs, chaintype.Solana)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get solana chain config: %w", err)
+	}
+	if exists {
+		cfgs[chaintype.Solana] = solanaCC
+	}
+	starknetCC, exists, err := firstChainConfigByType(n.ChainConfigs, chaintype.StarkNet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get starknet chain config: %w", err)
+	}
+	if exists {
+		cfgs[chaintype.StarkNet] = starknetCC
+	}
+	// the bootstrap status is settled before the conversion, which does not check the keys that bootstraps do not use
+	if isCloBootstrap(n) {
+		nodeOpts = append(nodeOpts, WithBootstrap())
+	}
+	// neither the CLO node nor its chain configs have an encryption key, and the job distributor OCR2Config that they
+	// are mapped to has no field for one, so the encryption key is the csa key unless nodeOpts sets one
+	return NewOcr2Node(n.ID, cfgs, *n.PublicKey, nodeOpts...)
+}
+
+// Ocr2NodeFromModel converts a single CLO node to its registry representation, with the same validation as the
+// deployment. The registry chain config of the node is the first one for the chain of registryChainSel
+func Ocr2NodeFromModel(ctx context.Context, n *models.Node, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (*Ocr2Node, error) {
+	if n == nil {
+		return nil, errors.New("nil node")
+	}
+	o, err := newMapDonsToNodesOpts(opts).newOcr2Node(ctx, n, registryChainSel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert node %s: %w", n.ID, err)
+	}
+	return o, nil
+}
+
+// Ocr2NodeCache memoizes the conversion of CLO nodes, keyed by node id, registry chain selector, bundle role and
+// encryption key.
+// It assumes that the CLO data of a node does not change for the lifetime of the cache, and that a selector resolves to
+// the same chain for every conversion. It is safe for concurrent use
+type Ocr2NodeCache struct {
+	mu      sync.Mutex
+	entries map[ocr2NodeCacheKey]*ocr2NodeCacheEntry
+	decode  func(ctx context.Context, n *models.Node, registryChainSel uint64, o mapDonsToNodesOpts) (*Ocr2Node, error)
+}
+
+type ocr2NodeCacheKey struct {
+	nodeID           string
+	registryChainSel uint64
+	bundleRole       string
+	encryptionKey    string
+}
+
+type ocr2NodeCacheEntry struct {
+	once sync.Once
+	node *Ocr2Node
+	err  error
+}
+
+func NewOcr2NodeCache() *Ocr2NodeCache {
+	return &Ocr2NodeCache{
+		entries: make(map[ocr2NodeCacheKey]*ocr2NodeCacheEntry),
+		decode: func(ctx context.Context, n *models.Node, registryChainSel uint64, o mapDonsToNodesOpts) (*Ocr2Node, error) {
+			return o.newOcr2Node(ctx, n, registryChainSel)
+		},
+	}
+}
+
+// Get returns the converted node, decoding it on first use. Concurrent calls for the same node decode it once and
+// share the result, including the error. The returned node is shared and must not be modified.
+// A cancelled ctx fails the call but is not cached, since the result is shared with other callers
+func (c *Ocr2NodeCache) Get(ctx context.Context, n *models.Node, registryChainSel uint64) (*Ocr2Node, error) {
+	return c.get(ctx, n, registryChainSel, mapDonsToNodesOpts{})
+}
+
+func (c *Ocr2NodeCache) get(ctx context.Context, n *models.Node, registryChainSel uint64, o mapDonsToNodesOpts) (*Ocr2Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k := ocr2NodeCacheKey{nodeID: n.ID, registryChainSel: registryChainSel, bundleRole: o.bundleRole, encryptionKey: o.encryptionKeys[n.ID]}
+	c.mu.Lock()
+	e, ok := c.entries[k]
+	if !ok {
+		e = &ocr2NodeCacheEntry{}
+		c.entries[k] = e
+	}
+	c.mu.Unlock()
+	e.once.Do(func() {
+		e.node, e.err = c.decode(context.WithoutCancel(ctx), n, registryChainSel, o)
+	})
+	return e.node, e.err
+}
+
+// normalizePeerID parses a peer id with or without the p2p_ prefix. The canonical form of the returned id is p.String()
+// with the prefix and p.Raw() without it
+func normalizePeerID(s string) (p2pkey.PeerID, error) {
+	raw := strings.TrimPrefix(s, "p2p_")
+	if raw == "" {
+		return p2pkey.PeerID{}, errors.New("empty peer id")
+	}
+	var p p2pkey.PeerID
+	if err := p.UnmarshalString(raw); err != nil {
+		return p2pkey.PeerID{}, fmt.Errorf("failed to unmarshal peer id %s: %w", s, err)
+	}
+	return p, nil
+}
+
+// ErrEmptyCSAKey is returned when a node has no csa public key
+var ErrEmptyCSAKey = errors.New("empty csa public key")
+
+// ErrInvalidCSAKey is returned when the csa public key of a node is not a hex encoded 32 byte key
+type ErrInvalidCSAKey struct {
+	Reason string
+	Value  string
+}
+
+func (e *ErrInvalidCSAKey) Error() string {
+	return fmt.Sprintf("invalid csa public key '%s': %s", e.Value, e.Reason)
+}
+
+// Ocr2NodeOpts are the optional inputs of NewOcr2Node
+type Ocr2NodeOpts struct {
+	// EncryptionPublicKey is the hex encoded 32 byte encryption key of the node. The csa key is used when empty
+	EncryptionPublicKey string
+	// IsBootstrap marks the node as a bootstrap even if its evm chain config does not
+	IsBootstrap bool
 }
 
-func newOcr2Node(id string, ccfgs map[chaintype.ChainType]*v1.ChainConfig, csaPubKey string) (*ocr2Node, error) {
+// WithBootstrap marks the node as a bootstrap, eg when another of its chain configs is a bootstrap config
+func WithBootstrap() func(*Ocr2NodeOpts) {
+	return func(o *Ocr2NodeOpts) {
+		o.IsBootstrap = true
+	}
+}
+
+// WithEncryptionPublicKey sets the encryption key of the node when it is distinct from its csa key
+func WithEncryptionPublicKey(key string) func(*Ocr2NodeOpts) {
+	return func(o *Ocr2NodeOpts) {
+		o.EncryptionPublicKey = key
+	}
+}
+
+// NewOcr2Node creates the registry representation of a node from its chain configs and csa public key.
+// An evm chain config is required; aptos, solana and starknet configs are optional
+func NewOcr2Node(id string, ccfgs map[chaintype.ChainType]*v1.ChainConfig, csaPubKey string, opts ...func(*Ocr2NodeOpts)) (*Ocr2Node, error) {
+	var o Ocr2NodeOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
 	if ccfgs == nil {
 		return nil, errors.New("nil ocr2config")
 	}
@@ -133,63 +624,151 @@ func newOcr2Node(id string, ccfgs map[chaintype.ChainType]*v1.ChainConfig, csaPu
 	}
 
 	if csaPubKey == "" {
-		return nil, errors.New("empty csa public key")
+		return nil, ErrEmptyCSAKey
 	}
 	// parse csapublic key to
 	csaKey, err := hex.DecodeString(csaPubKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode csa public key %s: %w", csaPubKey, err)
+		return nil, &ErrInvalidCSAKey{Reason: fmt.Sprintf("failed to decode: %v", err), Value: csaPubKey}
 	}
 	if len(csaKey) != 32 {
-		return nil, fmt.Errorf("invalid csa public key '%s'. expected len 32 got %d", csaPubKey, len(csaKey))
+		return nil, &ErrInvalidCSAKey{Reason: fmt.Sprintf("expected len 32 got %d", len(csaKey)), Value: csaPubKey}
 	}
 	var csaKeyb [32]byte
 	copy(csaKeyb[:], csaKey)
+	encryptionKey := csaKeyb
+	if o.EncryptionPublicKey != "" {
+		b, err := hex.DecodeString(o.EncryptionPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode encryption public key %s: %w", o.EncryptionPublicKey, err)
+		}
+		if len(b) != 32 {
+			return nil, fmt.Errorf("invalid encryption public key '%s'. expected len 32 got %d", o.EncryptionPublicKey, len(b))
+		}
+		copy(encryptionKey[:], b)
+	}
 
+	// the evm chain config is the registry chain config, which is the only one that needs the chain agnostic p2p key.
+	// the registry signer is the evm onchain signing key so the ocr key bundle is required too, even if the node
+	// does not run ocr on evm
 	ocfg := evmCC.Ocr2Config
-	p := p2pkey.PeerID{}
-	if err := p.UnmarshalString(ocfg.P2PKeyBundle.PeerId); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal peer id %s: %w", ocfg.P2PKeyBundle.PeerId, err)
+	if ocfg == nil || ocfg.P2PKeyBundle == nil || ocfg.OcrKeyBundle == nil {
+		return nil, fmt.Errorf("evm chain config of node %s must have an ocr2 config with p2p and ocr key bundles", id)
 	}
-
-	signer := ocfg.OcrKeyBundle.OnchainSigningAddress
-	if len(signer) != 40 {
-		return nil, fmt.Errorf("invalid onchain signing address %s", ocfg.OcrKeyBundle.OnchainSigningAddress)
+	p, err := normalizePeerID(ocfg.P2PKeyBundle.PeerId)
+	if err != nil {
+		return nil, err
 	}
-	signerB, err := hex.DecodeString(signer)
+
+	// the registry signer is the evm address, in the first 20 bytes of Signer
+	signerB, err := parseOnchainSigner(chaintype.EVM, ocfg.OcrKeyBundle.OnchainSigningAddress)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert signer %s: %w", signer, err)
+		return nil, fmt.Errorf("invalid onchain signing address of node %s: %w", id, err)
 	}
 
 	var sigb [32]byte
 	copy(sigb[:], signerB)
 
-	n := &ocr2Node{
+	// signers need the config public key for the ocr3 config; bootstraps do not sign so it is not checked for them
+	isBootstrap := ocfg.IsBootstrap || o.IsBootstrap
+	if !isBootstrap {
+		if err := validateConfigPublicKey(ocfg.OcrKeyBundle.ConfigPublicKey); err != nil {
+			return nil, fmt.Errorf("invalid ocr2 config public key of node %s: %w", id, err)
+		}
+	}
+
+	n := &Ocr2Node{
 		ID:                  id,
 		Signer:              sigb,
 		P2PKey:              p,
-		EncryptionPublicKey: csaKeyb,
-		IsBoostrap:          ocfg.IsBootstrap,
-		p2pKeyBundle:        ocfg.P2PKeyBundle,
-		ethOcr2KeyBundle:    evmCC.Ocr2Config.OcrKeyBundle,
-		aptosOcr2KeyBundle:  nil,
-		accountAddress:      evmCC.AccountAddress,
+		EncryptionPublicKey: encryptionKey,
+		IsBoostrap:          isBootstrap,
+		// store the canonical peer id so that the keys of the node do not depend on the format of the input
+		p2pKeyBundle: &v1.OCR2Config_P2PKeyBundle{
+			PeerId:    p.String(),
+			PublicKey: ocfg.P2PKeyBundle.PublicKey,
+		},
+		keyBundles: map[chaintype.ChainType]*v1.OCR2Config_OCRKeyBundle{
+			chaintype.EVM: evmCC.Ocr2Config.OcrKeyBundle,
+		},
+		onchainSigners:      make(map[chaintype.ChainType][]byte),
+		accountAddresses:    make(map[chaintype.ChainType]string),
 		csaKey:              csaPubKey,
+		encryptionPublicKey: o.EncryptionPublicKey,
+		networkAddress:      ocfg.Multiaddr,
+	}
+	// aptos, solana and starknet chain configs are optional
+	for _, ct := range []chaintype.ChainType{chaintype.Aptos, chaintype.Solana, chaintype.StarkNet} {
+		if cc, exists := ccfgs[ct]; exists {
+			if cc.Ocr2Config == nil || cc.Ocr2Config.OcrKeyBundle == nil {
+				return nil, fmt.Errorf("%s chain config of node %s has no ocr key bundle", ct, id)
+			}
+			n.keyBundles[ct] = cc.Ocr2Config.OcrKeyBundle
+			// bootstraps do not sign, and starknet keys are checked by toNodeKeysChecked
+			if ct == chaintype.StarkNet || isBootstrap || cc.Ocr2Config.IsBootstrap {
+				continue
+			}
+			signer, err := parseOnchainSigner(ct, cc.Ocr2Config.OcrKeyBundle.OnchainSigningAddress)
+			if err != nil {
+				return nil, fmt.Errorf("invalid onchain signing address of node %s: %w", id, err)
+			}
+			n.onchainSigners[ct] = signer
+		}
 	}
-	// aptos chain config is optional
-	if aptosCC, exists := ccfgs[chaintype.Aptos]; exists {
-		n.aptosOcr2KeyBundle = aptosCC.Ocr2Config.OcrKeyBundle
+	for ct, cc := range ccfgs {
+		if cc != nil && cc.AccountAddress != "" {
+			n.accountAddresses[ct] = cc.AccountAddress
+		}
 	}
 
 	return n, nil
 }
 
-func makeNodeKeysSlice(nodes []*ocr2Node) []NodeKeys {
+// validateConfigPublicKey checks that the key is a hex encoded 32 byte key, as the ocr3 config requires
+func validateConfigPublicKey(key string) error {
+	if key == "" {
+		return errors.New("empty key")
+	}
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("failed to decode '%s': %w", key, err)
+	}
+	if len(b) != 32 {
+		return fmt.Errorf("'%s' has len %d, expected 32", key, len(b))
+	}
+	return nil
+}
+
+type makeNodeKeysSliceOpts struct {
+	validateForOCR3 bool
+}
+
+// withOCR3Validation validates the keys of each node with NodeKeys.ValidateForOCR3
+func withOCR3Validation() func(*makeNodeKeysSliceOpts) {
+	return func(o *makeNodeKeysSliceOpts) {
+		o.validateForOCR3 = true
+	}
+}
+
+func makeNodeKeysSlice(nodes []*Ocr2Node, opts ...func(*makeNodeKeysSliceOpts)) ([]NodeKeys, error) {
+	var o makeNodeKeysSliceOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
 	var out []NodeKeys
 	for _, n := range nodes {
-		out = append(out, n.toNodeKeys())
+		nk, err := n.toNodeKeysChecked()
+		if err != nil {
+			return nil, err
+		}
+		if o.validateForOCR3 {
+			if err := nk.ValidateForOCR3(); err != nil {
+				return nil, fmt.Errorf("invalid keys of node %s: %w", n.ID, err)
+			}
+		}
+		out = append(out, nk)
 	}
-	return out
+	return out, nil
 }
 
 // DonCapabilities is a set of capabilities hosted by a set of node operators
@@ -198,132 +777,1483 @@ type DonCapabilities struct {
 	Name         string
 	Nops         []*models.NodeOperator               // each nop is a node operator and may have multiple nodes
 	Capabilities []kcr.CapabilitiesRegistryCapability // every capability is hosted on each nop
+	// NodeCapabilities optionally overrides Capabilities for individual nodes, keyed by node id.
+	// the don is configured with Capabilities, so each override must include all of them
+	NodeCapabilities map[string][]kcr.CapabilitiesRegistryCapability
+	// CapabilityConfigs optionally sets the config of the don's capabilities in the registry, keyed by hashed capability id.
+	// capabilities without a config use the default config for their type
+	CapabilityConfigs map[[32]byte][]byte
+	// NopAdmins optionally sets the admin address of a nop, keyed by nop name. It is used for the nodes of the nop whose
+	// registry chain config has no admin address
+	NopAdmins map[string]string
+	// NodeLabels optionally sets the Labels of the converted nodes, keyed by node id. CLO nodes do not have labels
+	NodeLabels map[string]map[string]string
+	// F optionally sets the fault tolerance of the don, eg to run with a lower f than the maximum for its signers.
+	// It must satisfy 3f+1 <= signers. The fault tolerance is computed from the number of signers when it is not set
+	F *uint8
+	// HeterogeneousCapabilities marks NodeCapabilities overrides that host more than the don capabilities as
+	// intentional; see AssertHomogeneousCapabilities
+	HeterogeneousCapabilities bool
 }
 
-// map the node id to the NOP
-func (dc DonCapabilities) nodeIdToNop(cs uint64) (map[string]capabilities_registry.CapabilitiesRegistryNodeOperator, error) {
-	cid, err := chainsel.ChainIdFromSelector(cs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get chain id from selector %d: %w", cs, err)
+// DonIDFromName returns a stable id for the don name: the 32 bit FNV-1a hash of the name, with 0 mapped to 1 because the
+// registry does not use 0 as a don id
+func DonIDFromName(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	if id := h.Sum32(); id != 0 {
+		return id
 	}
-	cidStr := strconv.FormatUint(cid, 10)
-	out := make(map[string]capabilities_registry.CapabilitiesRegistryNodeOperator)
-	for _, nop := range dc.Nops {
-		for _, node := range nop.Nodes {
-			found := false
-			for _, chain := range node.ChainConfigs {
-				if chain.Network.ChainID == cidStr {
-					found = true
-					out[node.ID] = capabilities_registry.CapabilitiesRegistryNodeOperator{
-						Name:  nop.Name,
-						Admin: adminAddr(chain.AdminAddress),
-					}
-				}
-			}
-			if !found {
-				return nil, fmt.Errorf("node '%s' %s does not support chain %d", node.Name, node.ID, cid)
+	return 1
+}
+
+// CheckDonIDCollisions returns an error for each pair of distinct don names that have the same DonIDFromName id
+func CheckDonIDCollisions(dons []DonCapabilities) error {
+	return checkDonIDCollisions(dons, DonIDFromName)
+}
+
+func checkDonIDCollisions(dons []DonCapabilities, donID func(string) uint32) error {
+	var errs []error
+	names := make(map[uint32]string)
+	for _, don := range dons {
+		id := donID(don.Name)
+		if other, exists := names[id]; exists {
+			if other != don.Name {
+				errs = append(errs, fmt.Errorf("dons %s and %s have the same id %d", other, don.Name, id))
 			}
+			continue
 		}
+		names[id] = don.Name
 	}
-	return out, nil
+	return errors.Join(errs...)
 }
 
-// helpers to maintain compatibility with the existing registration functions
-// nodesToNops converts a list of DonCapabilities to a map of node id to NOP
-func nodesToNops(dons []DonCapabilities, chainSel uint64) (map[string]capabilities_registry.CapabilitiesRegistryNodeOperator, error) {
-	out := make(map[string]capabilities_registry.CapabilitiesRegistryNodeOperator)
+// CheckCapabilityConsistency returns an error for each capability, by labelled name and version, that the dons or their
+// node overrides define with a different capability type or response type than its first definition. The registry
+// identifies capabilities by labelled name and version only, so such capabilities collide when they are added
+func CheckCapabilityConsistency(dons []DonCapabilities) error {
+	type definition struct {
+		cap kcr.CapabilitiesRegistryCapability
+		don string
+	}
+	var errs []error
+	first := make(map[string]definition)
+	check := func(don string, c kcr.CapabilitiesRegistryCapability) {
+		id := CapabilityID(c)
+		d, exists := first[id]
+		if !exists {
+			first[id] = definition{cap: c, don: don}
+			return
+		}
+		if d.cap.CapabilityType != c.CapabilityType || d.cap.ResponseType != c.ResponseType {
+			errs = append(errs, fmt.Errorf("capability %s has type %d and response type %d in don %s but type %d and response type %d in don %s",
+				id, d.cap.CapabilityType, d.cap.ResponseType, d.don, c.CapabilityType, c.ResponseType, don))
+		}
+	}
 	for _, don := range dons {
-		nops, err := don.nodeIdToNop(chainSel)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get registry NOPs for don %s: %w", don.Name, err)
+		for _, c := range don.Capabilities {
+			check(don.Name, c)
 		}
-		for donName, nop := range nops {
-			_, exists := out[donName]
-			if exists {
-				continue
+		nodeIDs := make([]string, 0, len(don.NodeCapabilities))
+		for nodeID := range don.NodeCapabilities {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+		slices.Sort(nodeIDs)
+		for _, nodeID := range nodeIDs {
+			for _, c := range don.NodeCapabilities[nodeID] {
+				check(don.Name, c)
 			}
-			out[donName] = nop
 		}
 	}
-	return out, nil
+	return errors.Join(errs...)
 }
 
-// mapDonsToCaps converts a list of DonCapabilities to a map of don name to capabilities
-func mapDonsToCaps(dons []DonCapabilities) map[string][]kcr.CapabilitiesRegistryCapability {
-	out := make(map[string][]kcr.CapabilitiesRegistryCapability)
-	for _, don := range dons {
-		out[don.Name] = don.Capabilities
+// NewDonCapabilities returns a don that hosts the capabilities on all the nodes of the nops, or the error from Validate
+func NewDonCapabilities(name string, nops []*models.NodeOperator, caps []kcr.CapabilitiesRegistryCapability) (DonCapabilities, error) {
+	dc := DonCapabilities{
+		Name:         name,
+		Nops:         nops,
+		Capabilities: caps,
 	}
-	return out
+	if err := dc.Validate(); err != nil {
+		return DonCapabilities{}, fmt.Errorf("invalid don: %w", err)
+	}
+	return dc, nil
 }
 
-// mapDonsToNodes returns a map of don name to simplified representation of their nodes
-// all nodes must have evm config and ocr3 capability nodes are must also have an aptos chain config
-func mapDonsToNodes(dons []DonCapabilities, excludeBootstraps bool, registryChainSel uint64) (map[string][]*ocr2Node, error) {
-	donToOcr2Nodes := make(map[string][]*ocr2Node)
-	// get the nodes for each don from the offchain client, get ocr2 config from one of the chain configs for the node b/c
-	// they are equivalent, and transform to ocr2node representation
-
-	for _, don := range dons {
-		for _, nop := range don.Nops {
-			for _, node := range nop.Nodes {
-				ocr2n, err := newOcr2NodeFromClo(node, registryChainSel)
-				if err != nil {
-					return nil, fmt.Errorf("failed to create ocr2 node for node %s: %w", node.ID, err)
-				}
-				if excludeBootstraps && ocr2n.IsBoostrap {
-					continue
-				}
-				if _, ok := donToOcr2Nodes[don.Name]; !ok {
-					donToOcr2Nodes[don.Name] = make([]*ocr2Node, 0)
-				}
-				donToOcr2Nodes[don.Name] = append(donToOcr2Nodes[don.Name], ocr2n)
-
-			}
+// MergeDonCapabilities merges an overlay topology into a base topology. A don of the overlay replaces the don of the
+// base with the same name, in place, and the other dons of the overlay are appended in order. Names must be unique
+// within each list
+func MergeDonCapabilities(base, overlay []DonCapabilities) ([]DonCapabilities, error) {
+	index := make(map[string]int, len(base))
+	for i, don := range base {
+		if _, exists := index[don.Name]; exists {
+			return nil, fmt.Errorf("duplicate don %s in base", don.Name)
 		}
+		index[don.Name] = i
 	}
-
-	return donToOcr2Nodes, nil
-}
-
-func firstChainConfigByType(ccfgs []*models.NodeChainConfig, t chaintype.ChainType) (*v1.ChainConfig, bool) {
-	for _, c := range ccfgs {
-		//nolint:staticcheck //ignore EqualFold it broke ci for some reason (go version skew btw local and ci?)
-		if strings.ToLower(c.Network.ChainType.String()) == strings.ToLower(string(t)) {
-			return chainConfigFromClo(c), true
+	out := slices.Clone(base)
+	seen := make(map[string]struct{}, len(overlay))
+	for _, don := range overlay {
+		if _, exists := seen[don.Name]; exists {
+			return nil, fmt.Errorf("duplicate don %s in overlay", don.Name)
+		}
+		seen[don.Name] = struct{}{}
+		if i, exists := index[don.Name]; exists {
+			out[i] = don
+			continue
 		}
+		out = append(out, don)
 	}
-	return nil, false
+	return out, nil
 }
 
-func registryChainConfig(ccfgs []*models.NodeChainConfig, t chaintype.ChainType, sel uint64) (*v1.ChainConfig, error) {
-	chainId, err := chainsel.ChainIdFromSelector(sel)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get chain id from selector %d: %w", sel, err)
+// ShardDon splits a don with more than maxNodesPerDon nodes into dons of at most maxNodesPerDon nodes, named
+// <name>-<shard index>. The nops are assigned to the shards in order and the nodes of a nop are kept together, so it is
+// an error for a nop to have more than maxNodesPerDon nodes. Each shard has the capabilities, capability configs and fault
+// tolerance of the don, and the node overrides and labels of its nodes. A don that does not need to be split is returned
+// as is
+func ShardDon(dc DonCapabilities, maxNodesPerDon int) ([]DonCapabilities, error) {
+	if maxNodesPerDon < 1 {
+		return nil, fmt.Errorf("invalid max nodes per don %d, must be positive", maxNodesPerDon)
+	}
+	total := 0
+	for _, nop := range dc.Nops {
+		if nop == nil {
+			continue
+		}
+		if len(nop.Nodes) > maxNodesPerDon {
+			return nil, fmt.Errorf("nop %s of don %s has %d nodes, more than the max of %d nodes per don", nop.Name, dc.Name, len(nop.Nodes), maxNodesPerDon)
+		}
+		total += len(nop.Nodes)
+	}
+	if total <= maxNodesPerDon {
+		return []DonCapabilities{dc}, nil
+	}
+
+	var groups [][]*models.NodeOperator
+	var current []*models.NodeOperator
+	n := 0
+	for _, nop := range dc.Nops {
+		if nop == nil {
+			continue
+		}
+		if n+len(nop.Nodes) > maxNodesPerDon {
+			groups = append(groups, current)
+			current, n = nil, 0
+		}
+		current = append(current, nop)
+		n += len(nop.Nodes)
+	}
+	groups = append(groups, current)
+
+	out := make([]DonCapabilities, 0, len(groups))
+	for i, nops := range groups {
+		shard := DonCapabilities{
+			Name:                      fmt.Sprintf("%s-%d", dc.Name, i),
+			Nops:                      nops,
+			Capabilities:              dc.Capabilities,
+			CapabilityConfigs:         dc.CapabilityConfigs,
+			NopAdmins:                 dc.NopAdmins,
+			F:                         dc.F,
+			HeterogeneousCapabilities: dc.HeterogeneousCapabilities,
+		}
+		for _, nop := range nops {
+			for _, node := range nop.Nodes {
+				if node == nil {
+					continue
+				}
+				if caps, ok := dc.NodeCapabilities[node.ID]; ok {
+					if shard.NodeCapabilities == nil {
+						shard.NodeCapabilities = make(map[string][]kcr.CapabilitiesRegistryCapability)
+					}
+					shard.NodeCapabilities[node.ID] = caps
+				}
+				if labels, ok := dc.NodeLabels[node.ID]; ok {
+					if shard.NodeLabels == nil {
+						shard.NodeLabels = make(map[string]map[string]string)
+					}
+					shard.NodeLabels[node.ID] = labels
+				}
+			}
+		}
+		out = append(out, shard)
+	}
+	return out, nil
+}
+
+// DonName is the name of a don. It is the key that ties together the don's nodes, capabilities and registry info
+type DonName string
+
+// Validate checks that the name is not empty and does not contain whitespace
+func (n DonName) Validate() error {
+	if n == "" {
+		return errors.New("don name is empty")
+	}
+	if strings.IndexFunc(string(n), unicode.IsSpace) >= 0 {
+		return fmt.Errorf("don name '%s' contains whitespace", n)
+	}
+	return nil
+}
+
+// Validate checks the don for missing nops, nodes and capabilities. All problems are reported at once
+func (dc DonCapabilities) Validate() error {
+	var errs []error
+	if err := DonName(dc.Name).Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(dc.Nops) == 0 {
+		errs = append(errs, fmt.Errorf("don '%s' has no nops", dc.Name))
+	}
+	for i, nop := range dc.Nops {
+		if nop == nil {
+			errs = append(errs, fmt.Errorf("don '%s' nop %d is nil", dc.Name, i))
+			continue
+		}
+		if len(nop.Nodes) == 0 {
+			errs = append(errs, fmt.Errorf("don '%s' nop '%s' has no nodes", dc.Name, nop.Name))
+		}
+		for j, node := range nop.Nodes {
+			if node == nil {
+				errs = append(errs, fmt.Errorf("don '%s' nop '%s' node %d is nil", dc.Name, nop.Name, j))
+			}
+		}
+	}
+	if len(dc.Capabilities) == 0 {
+		errs = append(errs, fmt.Errorf("don '%s' has no capabilities", dc.Name))
+	}
+	for i, c := range dc.Capabilities {
+		if c.LabelledName == "" {
+			errs = append(errs, fmt.Errorf("don '%s' capability %d has an empty labelled name", dc.Name, i))
+		} else if err := ValidateCapabilityName(c.LabelledName); err != nil {
+			errs = append(errs, fmt.Errorf("don '%s' capability %d: %w", dc.Name, i, err))
+		}
+		if err := validateCapabilityType(c.CapabilityType); err != nil {
+			errs = append(errs, fmt.Errorf("don '%s' capability %s: %w", dc.Name, CapabilityID(c), err))
+		}
+	}
+	if len(dc.NodeCapabilities) > 0 {
+		nodeIDs := make(map[string]struct{})
+		for _, nop := range dc.Nops {
+			if nop == nil {
+				continue
+			}
+			for _, node := range nop.Nodes {
+				if node == nil {
+					continue
+				}
+				nodeIDs[node.ID] = struct{}{}
+			}
+		}
+		// iterate in a stable order so that the joined error is deterministic
+		ids := make([]string, 0, len(dc.NodeCapabilities))
+		for id := range dc.NodeCapabilities {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		for _, id := range ids {
+			if _, ok := nodeIDs[id]; !ok {
+				errs = append(errs, fmt.Errorf("don '%s' has capabilities for unknown node '%s'", dc.Name, id))
+				continue
+			}
+			caps := dc.NodeCapabilities[id]
+			for i, c := range caps {
+				if c.LabelledName == "" {
+					errs = append(errs, fmt.Errorf("don '%s' node '%s' capability %d has an empty labelled name", dc.Name, id, i))
+				} else if err := ValidateCapabilityName(c.LabelledName); err != nil {
+					errs = append(errs, fmt.Errorf("don '%s' node '%s' capability %d: %w", dc.Name, id, i, err))
+				}
+				if err := validateCapabilityType(c.CapabilityType); err != nil {
+					errs = append(errs, fmt.Errorf("don '%s' node '%s' capability %s: %w", dc.Name, id, CapabilityID(c), err))
+				}
+			}
+			for _, c := range dc.Capabilities {
+				if !slices.ContainsFunc(caps, func(nc kcr.CapabilitiesRegistryCapability) bool {
+					return nc.LabelledName == c.LabelledName && nc.Version == c.Version
+				}) {
+					errs = append(errs, fmt.Errorf("don '%s' node '%s' does not host don capability %s", dc.Name, id, CapabilityID(c)))
+				}
+			}
+		}
+	}
+	if len(dc.CapabilityConfigs) > 0 {
+		ids := make([][32]byte, 0, len(dc.CapabilityConfigs))
+		for id := range dc.CapabilityConfigs {
+			ids = append(ids, id)
+		}
+		slices.SortFunc(ids, func(a, b [32]byte) int { return bytes.Compare(a[:], b[:]) })
+		for _, id := range ids {
+			if !slices.ContainsFunc(dc.Capabilities, func(c kcr.CapabilitiesRegistryCapability) bool {
+				return HashedCapabilityIDOf(c) == id
+			}) {
+				errs = append(errs, fmt.Errorf("don '%s' has config for capability %x that it does not host", dc.Name, id))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateBootstrapCapabilities checks that no capability is assigned to a bootstrap node. The don capabilities are assigned to
+// every node of the don, so a bootstrap node is assigned a capability if the don has capabilities or the node has a capability
+// override. Bootstraps are detected from their CLO config. When strict is false the assignments are logged as warnings
+func (dc DonCapabilities) ValidateBootstrapCapabilities(lggr logger.Logger, strict bool) error {
+	var errs []error
+	for _, nop := range dc.Nops {
+		if nop == nil {
+			continue
+		}
+		for _, n := range nop.Nodes {
+			if n == nil || !isCloBootstrap(n) {
+				continue
+			}
+			caps := dc.Capabilities
+			if nodeCaps, ok := dc.NodeCapabilities[n.ID]; ok {
+				caps = nodeCaps
+			}
+			if len(caps) == 0 {
+				continue
+			}
+			ids := make([]string, 0, len(caps))
+			for _, c := range caps {
+				ids = append(ids, CapabilityID(c))
+			}
+			if !strict {
+				lggr.Warnw("bootstrap node is assigned capabilities", "don", dc.Name, "node", n.ID, "capabilities", ids)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("don '%s' bootstrap node '%s' is assigned capabilities %s", dc.Name, n.ID, strings.Join(ids, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AssertHomogeneousCapabilities checks that every node of the don hosts exactly the don capabilities, ie that each of
+// its NodeCapabilities overrides equals the don capabilities, neither adding nor removing any, unless the don is marked
+// HeterogeneousCapabilities. Each node whose override differs is reported
+func AssertHomogeneousCapabilities(dc DonCapabilities) error {
+	if dc.HeterogeneousCapabilities || len(dc.NodeCapabilities) == 0 {
+		return nil
+	}
+	want := capabilityIDSet(dc.Capabilities)
+	ids := make([]string, 0, len(dc.NodeCapabilities))
+	for id := range dc.NodeCapabilities {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	var errs []error
+	for _, id := range ids {
+		got := capabilityIDSet(dc.NodeCapabilities[id])
+		if !slices.Equal(got, want) {
+			errs = append(errs, fmt.Errorf("don '%s' node '%s' hosts capabilities [%s] but the don hosts [%s]",
+				dc.Name, id, strings.Join(got, ","), strings.Join(want, ",")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// capabilityIDSet returns the sorted, deduplicated CapabilityID of the capabilities
+func capabilityIDSet(caps []kcr.CapabilitiesRegistryCapability) []string {
+	out := make([]string, 0, len(caps))
+	for _, c := range caps {
+		out = append(out, CapabilityID(c))
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// NodeIDs returns the sorted, deduplicated ids of all the nodes of the don, including bootstraps
+func (dc DonCapabilities) NodeIDs() []string {
+	return dc.nodeIDs(func(*models.Node) bool { return true })
+}
+
+// BootstrapNodeIDs returns the sorted, deduplicated ids of the bootstrap nodes of the don
+func (dc DonCapabilities) BootstrapNodeIDs() []string {
+	return dc.nodeIDs(isCloBootstrap)
+}
+
+func (dc DonCapabilities) nodeIDs(include func(*models.Node) bool) []string {
+	var out []string
+	for _, nop := range dc.Nops {
+		if nop == nil {
+			continue
+		}
+		for _, n := range nop.Nodes {
+			if n == nil || !include(n) {
+				continue
+			}
+			out = append(out, n.ID)
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// isCloBootstrap returns true if any of the node's ocr2 configs is a bootstrap config
+func isCloBootstrap(n *models.Node) bool {
+	for _, cc := range n.ChainConfigs {
+		if cc != nil && cc.Ocr2Config != nil && cc.Ocr2Config.IsBootstrap {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterDonsByCapability returns the dons that host the capability with the given labelled name and version
+func FilterDonsByCapability(dons []DonCapabilities, labelledName string, version string) []DonCapabilities {
+	var out []DonCapabilities
+	for _, don := range dons {
+		if slices.ContainsFunc(don.Capabilities, func(c kcr.CapabilitiesRegistryCapability) bool {
+			return c.LabelledName == labelledName && c.Version == version
+		}) {
+			out = append(out, don)
+		}
+	}
+	return out
+}
+
+// CapabilitySummary returns the sorted names of the dons hosting each capability, keyed by CapabilityID.
+// only the don capabilities are included, not the per node overrides
+func CapabilitySummary(dons []DonCapabilities) map[string][]string {
+	out := make(map[string][]string)
+	for _, don := range dons {
+		for _, c := range don.Capabilities {
+			id := CapabilityID(c)
+			if !slices.Contains(out[id], don.Name) {
+				out[id] = append(out[id], don.Name)
+			}
+		}
+	}
+	for _, names := range out {
+		slices.Sort(names)
+	}
+	return out
+}
+
+// CapabilityDonIndex is CapabilitySummary keyed by HashedCapabilityIDOf, the id of the capability in the registry, eg to
+// find the dons affected by an update of a capability
+func CapabilityDonIndex(dons []DonCapabilities) map[[32]byte][]string {
+	out := make(map[[32]byte][]string)
+	for _, don := range dons {
+		for _, c := range don.Capabilities {
+			id := HashedCapabilityIDOf(c)
+			if !slices.Contains(out[id], don.Name) {
+				out[id] = append(out[id], don.Name)
+			}
+		}
+	}
+	for _, names := range out {
+		slices.Sort(names)
+	}
+	return out
+}
+
+// AllCapabilities returns the capabilities of all the dons, deduplicated and sorted by labelled name and then version,
+// as in CompareCapabilityVersion. the first of the capabilities with the same id is kept
+func AllCapabilities(dons []DonCapabilities) []kcr.CapabilitiesRegistryCapability {
+	seen := make(map[string]struct{})
+	var out []kcr.CapabilitiesRegistryCapability
+	for _, don := range dons {
+		for _, c := range don.Capabilities {
+			id := CapabilityID(c)
+			if _, exists := seen[id]; exists {
+				continue
+			}
+			seen[id] = struct{}{}
+			out = append(out, c)
+		}
+	}
+	slices.SortStableFunc(out, compareCapabilities)
+	return out
+}
+
+// PartitionDons splits the dons into bootstrap dons, whose nodes are all bootstraps, and worker dons. The bootstrap status of a
+// node is determined by converting it to an Ocr2Node on the registry chain. A don without nodes is a worker don
+func PartitionDons(ctx context.Context, dons []DonCapabilities, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (bootstrap, worker []DonCapabilities, err error) {
+	o := newMapDonsToNodesOpts(opts)
+	for _, don := range dons {
+		allBootstraps, hasNodes := true, false
+		for _, nop := range don.Nops {
+			if nop == nil {
+				continue
+			}
+			for _, n := range nop.Nodes {
+				if n == nil {
+					continue
+				}
+				ocr2n, err := o.newOcr2Node(ctx, n, registryChainSel)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to convert node %s of don %s: %w", n.ID, don.Name, err)
+				}
+				hasNodes = true
+				allBootstraps = allBootstraps && ocr2n.IsBoostrap
+			}
+		}
+		if hasNodes && allBootstraps {
+			bootstrap = append(bootstrap, don)
+		} else {
+			worker = append(worker, don)
+		}
+	}
+	return bootstrap, worker, nil
+}
+
+// DonCapabilitiesFromOnchain reconstructs a DonCapabilities from a don in the registry, for reporting. Nodes are looked up by
+// p2p id and grouped by their node operator, capabilities are looked up by hashed capability id.
+// the registry does not store don names, so the returned don has no name
+func DonCapabilitiesFromOnchain(info kcr.CapabilitiesRegistryDONInfo, nodeLookup func([32]byte) (*models.Node, error), capLookup func([32]byte) (kcr.CapabilitiesRegistryCapability, error)) (DonCapabilities, error) {
+	var out DonCapabilities
+	nops := make(map[string]*models.NodeOperator)
+	for _, p2pID := range info.NodeP2PIds {
+		n, err := nodeLookup(p2pID)
+		if err != nil {
+			return DonCapabilities{}, fmt.Errorf("failed to lookup node %s of don %d: %w", p2pkey.PeerID(p2pID), info.Id, err)
+		}
+		if n == nil {
+			return DonCapabilities{}, fmt.Errorf("node %s of don %d not found", p2pkey.PeerID(p2pID), info.Id)
+		}
+		if n.NodeOperator == nil {
+			return DonCapabilities{}, fmt.Errorf("node %s of don %d has no node operator", n.ID, info.Id)
+		}
+		nop, exists := nops[n.NodeOperator.ID]
+		if !exists {
+			nop = &models.NodeOperator{
+				ID:    n.NodeOperator.ID,
+				Name:  n.NodeOperator.Name,
+				Email: n.NodeOperator.Email,
+			}
+			nops[nop.ID] = nop
+			out.Nops = append(out.Nops, nop)
+		}
+		nop.Nodes = append(nop.Nodes, n)
+	}
+	for _, cfg := range info.CapabilityConfigurations {
+		c, err := capLookup(cfg.CapabilityId)
+		if err != nil {
+			return DonCapabilities{}, fmt.Errorf("failed to lookup capability %x of don %d: %w", cfg.CapabilityId, info.Id, err)
+		}
+		out.Capabilities = append(out.Capabilities, c)
+		if len(cfg.Config) > 0 {
+			if out.CapabilityConfigs == nil {
+				out.CapabilityConfigs = make(map[[32]byte][]byte)
+			}
+			out.CapabilityConfigs[cfg.CapabilityId] = cfg.Config
+		}
+	}
+	return out, nil
+}
+
+// capability type and response type names, in the order of the enums in the CapabilitiesRegistry contract
+var (
+	capabilityTypeNames     = []string{"trigger", "action", "consensus", "target"}
+	capabilityResponseNames = []string{"report", "observation_identical"}
+)
+
+type capabilityJSON struct {
+	ID                    string `json:"id"`
+	LabelledName          string `json:"labelledName"`
+	Version               string `json:"version"`
+	CapabilityType        string `json:"capabilityType"`
+	ResponseType          string `json:"responseType"`
+	ConfigurationContract string `json:"configurationContract,omitempty"`
+}
+
+type donCapabilitiesJSON struct {
+	Name             string                      `json:"name"`
+	Nops             []*models.NodeOperator      `json:"nops"`
+	Capabilities     []capabilityJSON            `json:"capabilities"`
+	NodeCapabilities map[string][]capabilityJSON `json:"nodeCapabilities,omitempty"`
+	// CapabilityConfigs is keyed by the hex capability id, the configs are base64 encoded
+	CapabilityConfigs         map[string][]byte            `json:"capabilityConfigs,omitempty"`
+	NopAdmins                 map[string]string            `json:"nopAdmins,omitempty"`
+	F                         *uint8                       `json:"f,omitempty"`
+	NodeLabels                map[string]map[string]string `json:"nodeLabels,omitempty"`
+	HeterogeneousCapabilities bool                         `json:"heterogeneousCapabilities,omitempty"`
+}
+
+// MarshalJSON encodes the capabilities with their hex capability id and named capability and response types
+func (dc DonCapabilities) MarshalJSON() ([]byte, error) {
+	caps, err := capabilitiesToJSON(dc.Capabilities)
+	if err != nil {
+		return nil, err
+	}
+	out := donCapabilitiesJSON{
+		Name:                      dc.Name,
+		Nops:                      dc.Nops,
+		Capabilities:              caps,
+		NopAdmins:                 dc.NopAdmins,
+		F:                         dc.F,
+		NodeLabels:                dc.NodeLabels,
+		HeterogeneousCapabilities: dc.HeterogeneousCapabilities,
+	}
+	if dc.NodeCapabilities != nil {
+		out.NodeCapabilities = make(map[string][]capabilityJSON, len(dc.NodeCapabilities))
+		for nodeID, nodeCaps := range dc.NodeCapabilities {
+			out.NodeCapabilities[nodeID], err = capabilitiesToJSON(nodeCaps)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode capabilities for node %s: %w", nodeID, err)
+			}
+		}
+	}
+	if dc.CapabilityConfigs != nil {
+		out.CapabilityConfigs = make(map[string][]byte, len(dc.CapabilityConfigs))
+		for id, cfg := range dc.CapabilityConfigs {
+			out.CapabilityConfigs["0x"+hex.EncodeToString(id[:])] = cfg
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes the form produced by MarshalJSON. The capability id, if present, must match the
+// id computed from the labelled name and version
+func (dc *DonCapabilities) UnmarshalJSON(b []byte) error {
+	var in donCapabilitiesJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	caps, err := capabilitiesFromJSON(in.Capabilities)
+	if err != nil {
+		return err
+	}
+	out := DonCapabilities{
+		Name:                      in.Name,
+		Nops:                      in.Nops,
+		Capabilities:              caps,
+		NopAdmins:                 in.NopAdmins,
+		F:                         in.F,
+		NodeLabels:                in.NodeLabels,
+		HeterogeneousCapabilities: in.HeterogeneousCapabilities,
+	}
+	if in.NodeCapabilities != nil {
+		out.NodeCapabilities = make(map[string][]kcr.CapabilitiesRegistryCapability, len(in.NodeCapabilities))
+		for nodeID, nodeCaps := range in.NodeCapabilities {
+			out.NodeCapabilities[nodeID], err = capabilitiesFromJSON(nodeCaps)
+			if err != nil {
+				return fmt.Errorf("failed to decode capabilities for node %s: %w", nodeID, err)
+			}
+		}
+	}
+	if in.CapabilityConfigs != nil {
+		out.CapabilityConfigs = make(map[[32]byte][]byte, len(in.CapabilityConfigs))
+		for idStr, cfg := range in.CapabilityConfigs {
+			b, err := hex.DecodeString(strings.TrimPrefix(idStr, "0x"))
+			if err != nil || len(b) != 32 {
+				return fmt.Errorf("invalid capability id '%s' for capability config", idStr)
+			}
+			out.CapabilityConfigs[[32]byte(b)] = cfg
+		}
+	}
+	*dc = out
+	return nil
+}
+
+func capabilitiesToJSON(caps []kcr.CapabilitiesRegistryCapability) ([]capabilityJSON, error) {
+	out := make([]capabilityJSON, len(caps))
+	for i, c := range caps {
+		if err := validateCapabilityType(c.CapabilityType); err != nil {
+			return nil, fmt.Errorf("capability %s: %w", CapabilityID(c), err)
+		}
+		if int(c.ResponseType) >= len(capabilityResponseNames) {
+			return nil, fmt.Errorf("unknown response type %d for capability %s", c.ResponseType, CapabilityID(c))
+		}
+		id := HashedCapabilityID(c.LabelledName, c.Version)
+		cj := capabilityJSON{
+			ID:             "0x" + hex.EncodeToString(id[:]),
+			LabelledName:   c.LabelledName,
+			Version:        c.Version,
+			CapabilityType: capabilityTypeNames[c.CapabilityType],
+			ResponseType:   capabilityResponseNames[c.ResponseType],
+		}
+		if c.ConfigurationContract != (common.Address{}) {
+			cj.ConfigurationContract = c.ConfigurationContract.Hex()
+		}
+		out[i] = cj
+	}
+	return out, nil
+}
+
+func capabilitiesFromJSON(in []capabilityJSON) ([]kcr.CapabilitiesRegistryCapability, error) {
+	var out []kcr.CapabilitiesRegistryCapability
+	for _, cj := range in {
+		c := kcr.CapabilitiesRegistryCapability{
+			LabelledName: cj.LabelledName,
+			Version:      cj.Version,
+		}
+		typ := slices.Index(capabilityTypeNames, cj.CapabilityType)
+		if typ < 0 {
+			return nil, fmt.Errorf("unknown capability type '%s' for capability %s", cj.CapabilityType, CapabilityID(c))
+		}
+		c.CapabilityType = uint8(typ)
+		resp := slices.Index(capabilityResponseNames, cj.ResponseType)
+		if resp < 0 {
+			return nil, fmt.Errorf("unknown response type '%s' for capability %s", cj.ResponseType, CapabilityID(c))
+		}
+		c.ResponseType = uint8(resp)
+		if cj.ConfigurationContract != "" {
+			if !common.IsHexAddress(cj.ConfigurationContract) {
+				return nil, fmt.Errorf("invalid configuration contract '%s' for capability %s", cj.ConfigurationContract, CapabilityID(c))
+			}
+			c.ConfigurationContract = common.HexToAddress(cj.ConfigurationContract)
+		}
+		if cj.ID != "" {
+			id := HashedCapabilityID(c.LabelledName, c.Version)
+			if strings.ToLower(strings.TrimPrefix(cj.ID, "0x")) != hex.EncodeToString(id[:]) {
+				return nil, fmt.Errorf("capability id %s does not match computed id %x for capability %s", cj.ID, id, CapabilityID(c))
+			}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// map the node id to the NOP. The NOP admin is the admin address of the node's registry chain config, the one of the
+// bundle role as in registryChainConfig, or the NopAdmins entry of the nop if the chain config has none
+func (dc DonCapabilities) nodeIdToNop(cs uint64, bundleRole string, resolver SelectorResolver) (map[string]capabilities_registry.CapabilitiesRegistryNodeOperator, error) {
+	cid, err := resolver.EVMChainID(cs)
+	if err != nil {
+		return nil, err
+	}
+	// the test net nops use the zero admin address, which must never be substituted on a mainnet
+	addrOpt := AdminAddrOption{AllowZeroSubstitution: !resolver.IsMainnet(cs)}
+	out := make(map[string]capabilities_registry.CapabilitiesRegistryNodeOperator)
+	for _, nop := range dc.Nops {
+		if nop == nil {
+			continue
+		}
+		for _, node := range nop.Nodes {
+			if node == nil {
+				continue
+			}
+			chain, err := registryCloChainConfig(node.ID, node.ChainConfigs, chaintype.EVM, cs, bundleRole, resolver)
+			if err != nil {
+				return nil, fmt.Errorf("node '%s' does not support chain %d: %w", node.Name, cid, err)
+			}
+			// the admin of the node's registry chain config takes precedence over the nop admin
+			adminStr := chain.AdminAddress
+			if adminStr == "" {
+				adminStr = dc.NopAdmins[nop.Name]
+			}
+			if err := ValidateAdminAddress(adminStr); err != nil {
+				return nil, fmt.Errorf("invalid admin address of node '%s': %w", node.Name, err)
+			}
+			admin, err := adminAddr(adminStr, addrOpt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid admin address of node '%s': %w", node.Name, err)
+			}
+			out[node.ID] = capabilities_registry.CapabilitiesRegistryNodeOperator{
+				Name:  nop.Name,
+				Admin: admin,
+			}
+		}
+	}
+	return out, nil
+}
+
+// helpers to maintain compatibility with the existing registration functions
+// nodesToNops converts a list of DonCapabilities to a map of node id to NOP.
+// A node may be in more than one don, but it is an error for the dons to disagree on the admin of its NOP
+func nodesToNops(dons []DonCapabilities, chainSel uint64, bundleRole string, resolver SelectorResolver) (map[string]capabilities_registry.CapabilitiesRegistryNodeOperator, error) {
+	out := make(map[string]capabilities_registry.CapabilitiesRegistryNodeOperator)
+	firstDon := make(map[string]string) // node id to the first don it is in, for error reporting
+	for _, don := range dons {
+		nops, err := don.nodeIdToNop(chainSel, bundleRole, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get registry NOPs for don %s: %w", don.Name, err)
+		}
+		for nodeID, nop := range nops {
+			existing, exists := out[nodeID]
+			if exists {
+				if existing.Admin != nop.Admin {
+					return nil, fmt.Errorf("node %s has conflicting NOP admins: %s in don %s and %s in don %s",
+						nodeID, existing.Admin.Hex(), firstDon[nodeID], nop.Admin.Hex(), don.Name)
+				}
+				continue
+			}
+			out[nodeID] = nop
+			firstDon[nodeID] = don.Name
+		}
+	}
+	return out, nil
+}
+
+// FilterNewNops returns the entries of desired whose node operator is not in existing, matched by admin and name.
+// It is used to avoid adding node operators that are already in the registry
+func FilterNewNops(desired map[string]kcr.CapabilitiesRegistryNodeOperator, existing []kcr.CapabilitiesRegistryNodeOperator) map[string]kcr.CapabilitiesRegistryNodeOperator {
+	type nopKey struct {
+		admin common.Address
+		name  string
+	}
+	registered := make(map[nopKey]struct{}, len(existing))
+	for _, nop := range existing {
+		registered[nopKey{admin: nop.Admin, name: nop.Name}] = struct{}{}
+	}
+	out := make(map[string]kcr.CapabilitiesRegistryNodeOperator)
+	for k, nop := range desired {
+		if _, exists := registered[nopKey{admin: nop.Admin, name: nop.Name}]; exists {
+			continue
+		}
+		out[k] = nop
+	}
+	return out
+}
+
+// SortedNops returns the node operators of m ordered by name and then admin, so that registry transactions built
+// from the map have the same calldata on every run. Like the map, the result has one entry per key
+func SortedNops(m map[string]kcr.CapabilitiesRegistryNodeOperator) []kcr.CapabilitiesRegistryNodeOperator {
+	out := make([]kcr.CapabilitiesRegistryNodeOperator, 0, len(m))
+	for _, nop := range m {
+		out = append(out, nop)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return bytes.Compare(out[i].Admin.Bytes(), out[j].Admin.Bytes()) < 0
+	})
+	return out
+}
+
+// DistinctNops returns the number of distinct node operators, by name and admin, of the nodes of the dons.
+// A node operator that is in more than one don is counted once
+func DistinctNops(dons []DonCapabilities, chainSel uint64, opts ...func(*mapDonsToNodesOpts)) (int, error) {
+	o := newMapDonsToNodesOpts(opts)
+	distinct := make(map[kcr.CapabilitiesRegistryNodeOperator]struct{})
+	for _, don := range dons {
+		nops, err := don.nodeIdToNop(chainSel, o.bundleRole, o.selectorResolver())
+		if err != nil {
+			return 0, fmt.Errorf("failed to get registry NOPs for don %s: %w", don.Name, err)
+		}
+		for _, nop := range nops {
+			distinct[nop] = struct{}{}
+		}
+	}
+	return len(distinct), nil
+}
+
+// VerifyNops checks that every desired node operator, eg of nodesToNops, is registered with its admin. Node operators
+// are joined by name; each missing node operator and admin mismatch is reported. Node operators that are only onchain
+// are not an error
+func VerifyNops(desired map[string]kcr.CapabilitiesRegistryNodeOperator, onchain []kcr.CapabilitiesRegistryNodeOperator) error {
+	onchainAdmins := make(map[string][]common.Address)
+	for _, nop := range onchain {
+		onchainAdmins[nop.Name] = append(onchainAdmins[nop.Name], nop.Admin)
+	}
+	var errs []error
+	sorted := SortedNops(desired)
+	for i, nop := range sorted {
+		// desired has an entry per node, so the same node operator is usually there more than once
+		if i > 0 && sorted[i-1] == nop {
+			continue
+		}
+		admins, ok := onchainAdmins[nop.Name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("nop %s is not registered", nop.Name))
+			continue
+		}
+		if !slices.Contains(admins, nop.Admin) {
+			errs = append(errs, fmt.Errorf("nop %s has admin %s, expected %s", nop.Name, admins[0].Hex(), nop.Admin.Hex()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OrphanedNodes returns the ids of the nodes in allNodes that are not in any of the dons, in the order of allNodes
+func OrphanedNodes(allNodes []*models.Node, dons []DonCapabilities) []string {
+	assigned := make(map[string]struct{})
+	for _, don := range dons {
+		for _, nop := range don.Nops {
+			if nop == nil {
+				continue
+			}
+			for _, n := range nop.Nodes {
+				if n != nil {
+					assigned[n.ID] = struct{}{}
+				}
+			}
+		}
+	}
+	var out []string
+	for _, n := range allNodes {
+		if n == nil {
+			continue
+		}
+		if _, ok := assigned[n.ID]; !ok {
+			assigned[n.ID] = struct{}{} // report duplicates in the inventory once
+			out = append(out, n.ID)
+		}
+	}
+	return out
+}
+
+// ChainTypesInUse returns the distinct chain types of the chain configs of the nodes of the dons, sorted. It is an
+// error for a node to have a chain config of a chain type that is not supported
+func ChainTypesInUse(dons []DonCapabilities) ([]chaintype.ChainType, error) {
+	seen := make(map[chaintype.ChainType]struct{})
+	for _, don := range dons {
+		for _, nop := range don.Nops {
+			if nop == nil {
+				continue
+			}
+			for _, n := range nop.Nodes {
+				if n == nil {
+					continue
+				}
+				for _, cc := range n.ChainConfigs {
+					if cc == nil || cc.Network == nil {
+						continue
+					}
+					ct, err := cloChainType(cc.Network.ChainType)
+					if err != nil {
+						return nil, fmt.Errorf("node '%s' of don %s: %w", n.ID, don.Name, err)
+					}
+					seen[ct] = struct{}{}
+				}
+			}
+		}
+	}
+	out := make([]chaintype.ChainType, 0, len(seen))
+	for ct := range seen {
+		out = append(out, ct)
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// mapDonsToCaps converts a list of DonCapabilities to a map of don name to capabilities
+// capabilities that are listed more than once for a don are dropped
+func mapDonsToCaps(lggr logger.Logger, dons []DonCapabilities) map[DonName][]kcr.CapabilitiesRegistryCapability {
+	type capKey struct {
+		labelledName   string
+		version        string
+		capabilityType uint8
+	}
+	out := make(map[DonName][]kcr.CapabilitiesRegistryCapability)
+	for _, don := range dons {
+		seen := make(map[capKey]struct{})
+		var caps []kcr.CapabilitiesRegistryCapability
+		for _, c := range don.Capabilities {
+			k := capKey{labelledName: c.LabelledName, version: c.Version, capabilityType: c.CapabilityType}
+			if _, exists := seen[k]; exists {
+				lggr.Warnw("dropping duplicate capability", "don", don.Name, "capability", CapabilityID(c), "type", c.CapabilityType)
+				continue
+			}
+			seen[k] = struct{}{}
+			caps = append(caps, c)
+		}
+		out[DonName(don.Name)] = caps
+	}
+	return out
+}
+
+// withNodeLabels returns the node with the labels the don sets for it. Converted nodes may be shared, eg by the
+// Ocr2NodeCache, so a labelled copy is returned rather than modifying the node
+func (dc DonCapabilities) withNodeLabels(n *Ocr2Node) *Ocr2Node {
+	labels, ok := dc.NodeLabels[n.ID]
+	if !ok {
+		return n
+	}
+	labelled := *n
+	labelled.Labels = maps.Clone(labels)
+	return &labelled
+}
+
+// mapDonsToF converts a list of DonCapabilities to a map of don name to the fault tolerance set for the don.
+// dons without a fault tolerance are not in the map
+func mapDonsToF(dons []DonCapabilities) map[DonName]uint8 {
+	out := make(map[DonName]uint8)
+	for _, don := range dons {
+		if don.F != nil {
+			out[DonName(don.Name)] = *don.F
+		}
+	}
+	return out
+}
+
+// validateF checks that a don with the given number of signers can tolerate f faulty nodes, ie 3f+1 <= signers
+func validateF(donName string, f uint8, signers int) error {
+	if 3*int(f)+1 > signers {
+		return fmt.Errorf("don %s has f=%d, which requires at least %d signers but it has %d", donName, f, 3*int(f)+1, signers)
+	}
+	return nil
+}
+
+// mapDonsToCapConfigs converts a list of DonCapabilities to a map of don name to capability configs.
+// dons without capability configs are not in the map
+func mapDonsToCapConfigs(dons []DonCapabilities) map[DonName]map[[32]byte][]byte {
+	out := make(map[DonName]map[[32]byte][]byte)
+	for _, don := range dons {
+		if len(don.CapabilityConfigs) > 0 {
+			out[DonName(don.Name)] = don.CapabilityConfigs
+		}
+	}
+	return out
+}
+
+// mapNodesToCaps converts the per node capability overrides of a list of DonCapabilities to a map of node id to capabilities
+// a node that has overrides in more than one don hosts the union of them. nodes without overrides are not in the map
+func mapNodesToCaps(dons []DonCapabilities) map[string][]kcr.CapabilitiesRegistryCapability {
+	out := make(map[string][]kcr.CapabilitiesRegistryCapability)
+	for _, don := range dons {
+		for nodeID, caps := range don.NodeCapabilities {
+			for _, c := range caps {
+				if !slices.Contains(out[nodeID], c) {
+					out[nodeID] = append(out[nodeID], c)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// mapDonsToNodes returns a map of don name to simplified representation of their nodes, ordered by p2p peer id
+// all nodes must have evm config and ocr3 capability nodes are must also have an aptos chain config
+// the nodes are converted concurrently; the output order and the reported error are the same as a serial conversion
+func mapDonsToNodes(ctx context.Context, dons []DonCapabilities, excludeBootstraps bool, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (map[DonName][]*Ocr2Node, error) {
+	o := newMapDonsToNodesOpts(opts)
+	// get the nodes for each don from the offchain client, get ocr2 config from one of the chain configs for the node b/c
+	// they are equivalent, and transform to ocr2node representation
+	var nodes []*models.Node
+	for _, don := range dons {
+		for _, nop := range don.Nops {
+			for _, node := range nop.Nodes {
+				if o.excludeNodeIDs[node.ID] {
+					continue
+				}
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	ocr2Nodes := make([]*Ocr2Node, len(nodes))
+	errs := make([]error, len(nodes))
+	var g errgroup.Group
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for i, node := range nodes {
+		if ctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			if o.cache != nil {
+				ocr2Nodes[i], errs[i] = o.cache.get(ctx, node, registryChainSel, o)
+				return nil
+			}
+			ocr2Nodes[i], errs[i] = o.newOcr2Node(ctx, node, registryChainSel)
+			return nil
+		})
+	}
+	_ = g.Wait() // errors are collected per node so that the first one in input order is returned
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	donToOcr2Nodes := make(map[DonName][]*Ocr2Node)
+	i := 0
+	for _, don := range dons {
+		donName := DonName(don.Name)
+		for _, nop := range don.Nops {
+			for _, node := range nop.Nodes {
+				if o.excludeNodeIDs[node.ID] {
+					continue
+				}
+				ocr2n, err := ocr2Nodes[i], errs[i]
+				i++
+				if err != nil && o.skipped != nil && errors.Is(err, ErrNoOcr2Config) {
+					if !slices.Contains(*o.skipped, node.ID) {
+						*o.skipped = append(*o.skipped, node.ID)
+					}
+					continue
+				}
+				if err != nil {
+					return nil, fmt.Errorf("failed to create ocr2 node for node %s: %w", node.ID, err)
+				}
+				if excludeBootstraps && ocr2n.IsBoostrap {
+					continue
+				}
+				ocr2n = don.withNodeLabels(ocr2n)
+				if _, ok := donToOcr2Nodes[donName]; !ok {
+					donToOcr2Nodes[donName] = make([]*Ocr2Node, 0)
+				}
+				donToOcr2Nodes[donName] = append(donToOcr2Nodes[donName], ocr2n)
+			}
+		}
+		if err := validateDon(donName, donToOcr2Nodes[donName]); err != nil {
+			return nil, err
+		}
+		// the nodes are ordered by p2p peer id, rather than by the order of the CLO data, so that the calldata derived
+		// from them is reproducible
+		nodes := donToOcr2Nodes[donName]
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].P2PKey.String() < nodes[j].P2PKey.String()
+		})
+	}
+
+	return donToOcr2Nodes, nil
+}
+
+// MapEnvironments converts the dons of each environment, eg the CLO exports of the dev, staging and prod deployments of
+// the same dons, to their registry nodes, excluding bootstraps as mapDonsToNodes does for registration. The result is
+// keyed by environment and then don name. The environments are converted in order of name, and the first error is returned
+func MapEnvironments(ctx context.Context, envs map[string][]DonCapabilities, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (map[string]map[string][]*Ocr2Node, error) {
+	names := make([]string, 0, len(envs))
+	for name := range envs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	out := make(map[string]map[string][]*Ocr2Node, len(envs))
+	for _, env := range names {
+		donToNodes, err := mapDonsToNodes(ctx, envs[env], true, registryChainSel, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map dons of environment %s: %w", env, err)
+		}
+		dons := make(map[string][]*Ocr2Node, len(donToNodes))
+		for don, nodes := range donToNodes {
+			dons[string(don)] = nodes
+		}
+		out[env] = dons
+	}
+	return out, nil
+}
+
+// DonNodeResult is a node of a don converted by StreamDonNodes. Err is set if the node could not be converted
+type DonNodeResult struct {
+	Don  string
+	Node *Ocr2Node
+	Err  error
+}
+
+// StreamDonNodes converts the nodes of the dons, including bootstraps, in input order and sends them on the returned channel
+// as they are converted, so that large topologies do not need to be held in memory. A node that fails to convert is sent
+// with its error and the stream continues. The channel is closed when all nodes are sent or ctx is done; callers that stop
+// reading must cancel ctx
+func StreamDonNodes(ctx context.Context, dons []DonCapabilities, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) (<-chan DonNodeResult, error) {
+	o := newMapDonsToNodesOpts(opts)
+	if _, err := o.selectorResolver().EVMChainID(registryChainSel); err != nil {
+		return nil, err
+	}
+	out := make(chan DonNodeResult)
+	go func() {
+		defer close(out)
+		for _, don := range dons {
+			for _, nop := range don.Nops {
+				for _, node := range nop.Nodes {
+					if ctx.Err() != nil {
+						return
+					}
+					res := DonNodeResult{Don: don.Name}
+					res.Node, res.Err = o.newOcr2Node(ctx, node, registryChainSel)
+					if res.Err != nil {
+						res.Node = nil
+						res.Err = fmt.Errorf("failed to create ocr2 node for node %s: %w", node.ID, res.Err)
+					} else {
+						res.Node = don.withNodeLabels(res.Node)
+					}
+					select {
+					case out <- res:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+type mapDonsToNodesOpts struct {
+	excludeNodeIDs map[string]bool
+	cache          *Ocr2NodeCache
+	skipped        *[]string
+	resolver       SelectorResolver
+	bundleRole     string
+	encryptionKeys map[string]string
+}
+
+func newMapDonsToNodesOpts(opts []func(*mapDonsToNodesOpts)) mapDonsToNodesOpts {
+	var o mapDonsToNodesOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// selectorResolver returns the resolver of the registry chain selector, DefaultSelectorResolver if none is set
+func (o mapDonsToNodesOpts) selectorResolver() SelectorResolver {
+	if o.resolver == nil {
+		return DefaultSelectorResolver
+	}
+	return o.resolver
+}
+
+// newOcr2Node converts the CLO node with the bundle role, resolver and encryption key of the options
+func (o mapDonsToNodesOpts) newOcr2Node(ctx context.Context, n *models.Node, registryChainSel uint64) (*Ocr2Node, error) {
+	var nodeOpts []func(*Ocr2NodeOpts)
+	if key, ok := o.encryptionKeys[n.ID]; ok {
+		nodeOpts = append(nodeOpts, WithEncryptionPublicKey(key))
+	}
+	return newOcr2NodeFromClo(ctx, n, registryChainSel, o.bundleRole, o.selectorResolver(), nodeOpts...)
+}
+
+// WithEncryptionPublicKeys sets the hex encoded encryption keys of CLO nodes, keyed by node id. CLO has no encryption
+// key, so nodes that are not in keys use their csa key
+func WithEncryptionPublicKeys(keys map[string]string) func(*mapDonsToNodesOpts) {
+	return func(o *mapDonsToNodesOpts) {
+		o.encryptionKeys = keys
+	}
+}
+
+// WithBundleRole converts the nodes with the registry chain config of the ocr key bundle with id bundleRole, for nodes
+// that have more than one key bundle for the registry chain. By default the first registry chain config is used
+func WithBundleRole(bundleRole string) func(*mapDonsToNodesOpts) {
+	return func(o *mapDonsToNodesOpts) {
+		o.bundleRole = bundleRole
+	}
+}
+
+// WithSelectorResolver resolves the registry chain selector with r, eg a CustomSelectorResolver for private networks,
+// instead of DefaultSelectorResolver. A nil r is DefaultSelectorResolver
+func WithSelectorResolver(r SelectorResolver) func(*mapDonsToNodesOpts) {
+	return func(o *mapDonsToNodesOpts) {
+		o.resolver = r
+	}
+}
+
+// withExcludedNodeIDs skips the nodes with the given ids, eg nodes that are being decommissioned.
+// excluded nodes are not converted so they do not need valid configs
+func withExcludedNodeIDs(ids map[string]bool) func(*mapDonsToNodesOpts) {
+	return func(o *mapDonsToNodesOpts) {
+		o.excludeNodeIDs = ids
+	}
+}
+
+// withSkippedNodesWithoutOcr2Config leaves out the nodes that have a chain config without an ocr2 config, instead of
+// failing, and appends their ids to skipped. A node in more than one don is appended once
+func withSkippedNodesWithoutOcr2Config(skipped *[]string) func(*mapDonsToNodesOpts) {
+	return func(o *mapDonsToNodesOpts) {
+		o.skipped = skipped
+	}
+}
+
+// withOcr2NodeCache converts the nodes through the cache so that nodes shared by dons are decoded once
+func withOcr2NodeCache(c *Ocr2NodeCache) func(*mapDonsToNodesOpts) {
+	return func(o *mapDonsToNodesOpts) {
+		o.cache = c
+	}
+}
+
+// validateDon checks that no two nodes in the don share a p2p peer id or a signer
+func validateDon(donName DonName, nodes []*Ocr2Node) error {
+	p2pToNode := make(map[p2pkey.PeerID]string)
+	signerToNode := make(map[[32]byte]string)
+	addrToNode := make(map[common.Address]string)
+	for _, n := range nodes {
+		if other, exists := p2pToNode[n.P2PKey]; exists {
+			return fmt.Errorf("duplicate p2p peer id %s in don %s: nodes %s and %s", n.P2PKey, donName, other, n.ID)
+		}
+		p2pToNode[n.P2PKey] = n.ID
+		if other, exists := signerToNode[n.Signer]; exists {
+			return fmt.Errorf("duplicate signer %x in don %s: nodes %s and %s", n.Signer, donName, other, n.ID)
+		}
+		signerToNode[n.Signer] = n.ID
+		// the forwarder only uses the evm address, ie the first 20 bytes, of the signer
+		if other, exists := addrToNode[n.signerAddress()]; exists {
+			return fmt.Errorf("duplicate signer address %s in don %s: nodes %s and %s", n.signerAddress().Hex(), donName, other, n.ID)
+		}
+		addrToNode[n.signerAddress()] = n.ID
+	}
+	return nil
+}
+
+func firstChainConfigByType(ccfgs []*models.NodeChainConfig, t chaintype.ChainType) (*v1.ChainConfig, bool, error) {
+	for _, c := range ccfgs {
+		//nolint:staticcheck //ignore EqualFold it broke ci for some reason (go version skew btw local and ci?)
+		if strings.ToLower(c.Network.ChainType.String()) == strings.ToLower(string(t)) {
+			cc, err := chainConfigFromClo(c)
+			if err != nil {
+				return nil, false, err
+			}
+			return cc, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// ErrNoOcr2Config is returned when a chain config of a node has no ocr2 config, eg a node that is not set up for ocr
+var ErrNoOcr2Config = errors.New("no ocr2 config")
+
+// ErrMissingChainConfig is returned when a node does not have a chain config for a required chain
+type ErrMissingChainConfig struct {
+	NodeID        string
+	ChainSelector uint64
+	ChainType     chaintype.ChainType
+}
+
+func (e *ErrMissingChainConfig) Error() string {
+	return fmt.Sprintf("node %s has no %s chain config for chain selector %d", e.NodeID, e.ChainType, e.ChainSelector)
+}
+
+// ValidateDonRegistryChain checks that every node of the don has an evm chain config for the registry chain. Unlike the
+// conversion of the nodes, which stops at the first such node, it returns an ErrMissingChainConfig for each of them
+func ValidateDonRegistryChain(dc DonCapabilities, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) error {
+	chainID, err := newMapDonsToNodesOpts(opts).selectorResolver().EVMChainID(registryChainSel)
+	if err != nil {
+		return err
+	}
+	chainIDStr := strconv.FormatUint(chainID, 10)
+	var errs []error
+	for _, nop := range dc.Nops {
+		for _, node := range nop.Nodes {
+			found := slices.ContainsFunc(node.ChainConfigs, func(c *models.NodeChainConfig) bool {
+				//nolint:staticcheck //ignore EqualFold it broke ci for some reason (go version skew btw local and ci?)
+				return c != nil && c.Network != nil && strings.ToLower(c.Network.ChainType.String()) == strings.ToLower(string(chaintype.EVM)) &&
+					chainIDMatches(c.Network.ChainID, chainIDStr)
+			})
+			if !found {
+				errs = append(errs, fmt.Errorf("node '%s' of nop '%s' in don %s: %w", node.Name, nop.Name, dc.Name, &ErrMissingChainConfig{
+					NodeID:        node.ID,
+					ChainSelector: registryChainSel,
+					ChainType:     chaintype.EVM,
+				}))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// normalizeChainIDString returns the decimal form of a CLO chain id, which may be decimal or 0x prefixed hex and
+// surrounded by whitespace
+func normalizeChainIDString(s string) (string, error) {
+	trimmed := strings.TrimSpace(s)
+	base, digits := 10, trimmed
+	if strings.HasPrefix(trimmed, "0x") || strings.HasPrefix(trimmed, "0X") {
+		base, digits = 16, trimmed[2:]
+	}
+	id, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid chain id '%s': %w", s, err)
+	}
+	return strconv.FormatUint(id, 10), nil
+}
+
+// chainIDMatches reports whether the CLO chain id is the decimal chain id want. Chain ids that do not parse, such as the
+// empty id of some non evm chain configs, do not match
+func chainIDMatches(s string, want string) bool {
+	id, err := normalizeChainIDString(s)
+	return err == nil && id == want
+}
+
+// registryChainConfig returns the node's chain config of type t for the chain of sel. bundleRole selects the chain config
+// by its ocr key bundle id, as in newOcr2NodeFromClo
+func registryChainConfig(nodeID string, ccfgs []*models.NodeChainConfig, t chaintype.ChainType, sel uint64, bundleRole string, resolver SelectorResolver) (*v1.ChainConfig, error) {
+	c, err := registryCloChainConfig(nodeID, ccfgs, t, sel, bundleRole, resolver)
+	if err != nil {
+		return nil, err
+	}
+	return chainConfigFromClo(c)
+}
+
+// registryCloChainConfig is registryChainConfig that returns the CLO chain config, eg for its admin address
+func registryCloChainConfig(nodeID string, ccfgs []*models.NodeChainConfig, t chaintype.ChainType, sel uint64, bundleRole string, resolver SelectorResolver) (*models.NodeChainConfig, error) {
+	chainId, err := resolver.EVMChainID(sel)
+	if err != nil {
+		return nil, err
 	}
 	chainIdStr := strconv.FormatUint(chainId, 10)
 	for _, c := range ccfgs {
 		//nolint:staticcheck //ignore EqualFold it broke ci for some reason (go version skew btw local and ci?)
-		if strings.ToLower(c.Network.ChainType.String()) == strings.ToLower(string(t)) && c.Network.ChainID == chainIdStr {
-			return chainConfigFromClo(c), nil
+		if strings.ToLower(c.Network.ChainType.String()) == strings.ToLower(string(t)) && chainIDMatches(c.Network.ChainID, chainIdStr) {
+			if bundleRole != defaultBundleRole && (c.Ocr2Config == nil || c.Ocr2Config.OcrKeyBundle == nil || c.Ocr2Config.OcrKeyBundle.BundleID != bundleRole) {
+				continue
+			}
+			return c, nil
 		}
 	}
-	return nil, fmt.Errorf("no chain config for chain %d", chainId)
+	if bundleRole != defaultBundleRole {
+		return nil, fmt.Errorf("node %s has no %s chain config for chain selector %d with bundle role %s", nodeID, t, sel, bundleRole)
+	}
+	return nil, &ErrMissingChainConfig{
+		NodeID:        nodeID,
+		ChainSelector: sel,
+		ChainType:     t,
+	}
+}
+
+// SelectorResolver resolves a registry chain selector to its evm chain id. EVMChainID returns an error if the selector
+// is not an evm chain. IsMainnet reports whether the chain is a mainnet, on which the zero admin address of test net
+// nops is never substituted
+type SelectorResolver interface {
+	EVMChainID(sel uint64) (uint64, error)
+	IsMainnet(sel uint64) bool
+}
+
+// DefaultSelectorResolver resolves the selectors known to chainsel
+var DefaultSelectorResolver SelectorResolver = chainselResolver{}
+
+type chainselResolver struct{}
+
+func (chainselResolver) EVMChainID(sel uint64) (uint64, error) {
+	if err := assertEVMSelector(sel); err != nil {
+		return 0, err
+	}
+	cid, err := chainsel.ChainIdFromSelector(sel)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chain id from selector %d: %w", sel, err)
+	}
+	return cid, nil
+}
+
+// IsMainnet treats chains unknown to chainsel as mainnets
+func (chainselResolver) IsMainnet(sel uint64) bool {
+	c, ok := chainsel.ChainBySelector(sel)
+	return !ok || strings.Contains(c.Name, "mainnet")
+}
+
+// CustomSelectorResolver maps the selectors of evm chains that are unknown to chainsel, eg private networks, to their
+// chain ids. The chains of the custom selectors are test chains. Other selectors are resolved by DefaultSelectorResolver
+type CustomSelectorResolver map[uint64]uint64
+
+func (r CustomSelectorResolver) EVMChainID(sel uint64) (uint64, error) {
+	if cid, ok := r[sel]; ok {
+		return cid, nil
+	}
+	return DefaultSelectorResolver.EVMChainID(sel)
+}
+
+func (r CustomSelectorResolver) IsMainnet(sel uint64) bool {
+	if _, ok := r[sel]; ok {
+		return false
+	}
+	return DefaultSelectorResolver.IsMainnet(sel)
+}
+
+// assertEVMSelector returns an error if the selector is not a known evm chain. The registry is only deployed on evm chains
+func assertEVMSelector(sel uint64) error {
+	family, err := chainsel.GetSelectorFamily(sel)
+	if err != nil {
+		return fmt.Errorf("selector %d is not an EVM chain: %w", sel, err)
+	}
+	if family != chainsel.FamilyEVM {
+		return fmt.Errorf("selector %d is not an EVM chain", sel)
+	}
+	return nil
 }
 
 // RegisteredDon is a representation of a don that exists in the in the capabilities registry all with the enriched node data
 type RegisteredDon struct {
 	Name  string
 	Info  capabilities_registry.CapabilitiesRegistryDONInfo
-	Nodes []*ocr2Node
+	Nodes []*Ocr2Node
+	// FaultTolerance is the fault tolerance set for the don in its DonCapabilities, if any
+	FaultTolerance *uint8
 }
 
-func (d RegisteredDon) signers() []common.Address {
-	sort.Slice(d.Nodes, func(i, j int) bool {
-		return d.Nodes[i].P2PKey.String() < d.Nodes[j].P2PKey.String()
+// Signers returns the evm signer addresses of the don's nodes ordered by p2p peer id, which is the order
+// in which the nodes are registered in the forwarder. Bootstrap nodes are excluded.
+// The don's Nodes are not modified
+func (d RegisteredDon) Signers() []common.Address {
+	nodes := slices.Clone(d.Nodes)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].P2PKey.String() < nodes[j].P2PKey.String()
 	})
 	var out []common.Address
-	for _, n := range d.Nodes {
+	for _, n := range nodes {
 		if n.IsBoostrap {
 			continue
 		}
@@ -332,66 +2262,448 @@ func (d RegisteredDon) signers() []common.Address {
 	return out
 }
 
-func joinInfoAndNodes(donInfos map[string]kcr.CapabilitiesRegistryDONInfo, dons []DonCapabilities, registryChainSel uint64) ([]RegisteredDon, error) {
+// Bootstraps returns the bootstrap nodes of the don ordered by p2p peer id. The don's Nodes are not modified
+func (d RegisteredDon) Bootstraps() []*Ocr2Node {
+	var out []*Ocr2Node
+	for _, n := range d.Nodes {
+		if n.IsBootstrap() {
+			out = append(out, n)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].P2PKey.String() < out[j].P2PKey.String()
+	})
+	return out
+}
+
+// BootstrapLocators returns the peerID@host:port locators of the bootstrap nodes of the don, in the order of Bootstraps,
+// for the bootstrappers of the ocr3 config. Every bootstrap must have a network address
+func (d RegisteredDon) BootstrapLocators() ([]string, error) {
+	var out []string
+	for _, n := range d.Bootstraps() {
+		if n.networkAddress == "" {
+			return nil, fmt.Errorf("bootstrap node %s of don %s has no network address", n.ID, d.Name)
+		}
+		hostPort, err := networkHostPort(n.networkAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network address of bootstrap node %s of don %s: %w", n.ID, d.Name, err)
+		}
+		out = append(out, fmt.Sprintf("%s@%s", n.P2PKey.Raw(), hostPort))
+	}
+	return out, nil
+}
+
+// networkHostPort returns the host:port of a network address that is either host:port or a multiaddr of the form
+// /ip4|ip6|dns|dns4|dns6/<host>/tcp/<port>
+func networkHostPort(addr string) (string, error) {
+	if !strings.HasPrefix(addr, "/") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", fmt.Errorf("invalid address '%s': %w", addr, err)
+		}
+		return addr, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(addr, "/"), "/")
+	if len(parts) < 4 || parts[2] != "tcp" {
+		return "", fmt.Errorf("unsupported multiaddr '%s': expected /<protocol>/<host>/tcp/<port>", addr)
+	}
+	switch parts[0] {
+	case "ip4", "ip6", "dns", "dns4", "dns6":
+	default:
+		return "", fmt.Errorf("unsupported multiaddr '%s': unknown protocol %s", addr, parts[0])
+	}
+	if _, err := strconv.ParseUint(parts[3], 10, 16); err != nil {
+		return "", fmt.Errorf("invalid port in multiaddr '%s': %w", addr, err)
+	}
+	return net.JoinHostPort(parts[1], parts[3]), nil
+}
+
+// NodeBySigner returns the non-bootstrap node of the don whose evm signer address is addr
+func (d RegisteredDon) NodeBySigner(addr common.Address) (*Ocr2Node, bool) {
+	for _, n := range d.Nodes {
+		if !n.IsBootstrap() && n.signerAddress() == addr {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// DiffNodes returns the nodes of other that are not in d and the nodes of d that are not in other, in the order of
+// their don's Nodes, eg for d the current don and other the desired one. Nodes are matched by p2p peer id. A node
+// that kept its peer id but changed its signer or encryption key is reported as both removed and added
+func (d RegisteredDon) DiffNodes(other RegisteredDon) (added, removed []*Ocr2Node) {
+	index := func(nodes []*Ocr2Node) map[p2pkey.PeerID]*Ocr2Node {
+		out := make(map[p2pkey.PeerID]*Ocr2Node, len(nodes))
+		for _, n := range nodes {
+			out[n.P2PKey] = n
+		}
+		return out
+	}
+	same := func(a, b *Ocr2Node) bool {
+		return a.Signer == b.Signer && a.EncryptionPublicKey == b.EncryptionPublicKey
+	}
+	current, desired := index(d.Nodes), index(other.Nodes)
+	for _, n := range other.Nodes {
+		if c, ok := current[n.P2PKey]; !ok || !same(c, n) {
+			added = append(added, n)
+		}
+	}
+	for _, n := range d.Nodes {
+		if o, ok := desired[n.P2PKey]; !ok || !same(o, n) {
+			removed = append(removed, n)
+		}
+	}
+	return added, removed
+}
+
+// ErrSharedSigner is returned when the same signer address belongs to more than one node
+type ErrSharedSigner struct {
+	Signer  common.Address
+	NodeIDs []string
+}
+
+func (e *ErrSharedSigner) Error() string {
+	return fmt.Sprintf("signer %s is shared by nodes %v", e.Signer.Hex(), e.NodeIDs)
+}
+
+// BuildSignerIndex maps the evm signer address of every non-bootstrap node of the dons to the node id.
+// Signers are unique in a well formed registry, so an *ErrSharedSigner is returned if two nodes have the same signer
+func BuildSignerIndex(dons []RegisteredDon) (map[common.Address]string, error) {
+	out := make(map[common.Address]string)
+	for _, d := range dons {
+		for _, n := range d.Nodes {
+			if n.IsBootstrap() {
+				continue
+			}
+			addr := n.signerAddress()
+			if id, exists := out[addr]; exists && id != n.ID {
+				return nil, &ErrSharedSigner{Signer: addr, NodeIDs: []string{id, n.ID}}
+			}
+			out[addr] = n.ID
+		}
+	}
+	return out, nil
+}
+
+// MaxFaultyNodes returns the largest f such that n >= 3f+1
+func MaxFaultyNodes(n int) uint8 {
+	if n < 1 {
+		return 0
+	}
+	f := (n - 1) / 3
+	if f > math.MaxUint8 {
+		return math.MaxUint8
+	}
+	return uint8(f)
+}
+
+// F returns the fault tolerance set for the don, or the one computed from its non-bootstrap nodes if none is set
+func (d RegisteredDon) F() uint8 {
+	if d.FaultTolerance != nil {
+		return *d.FaultTolerance
+	}
+	return MaxFaultyNodes(len(d.Signers()))
+}
+
+// ValidateQuorum checks that the don has enough signers to tolerate at least one faulty node, ie 3f+1 signers with f >= 1
+func (d RegisteredDon) ValidateQuorum() error {
+	n := len(d.Signers())
+	f := d.F()
+	if f == 0 {
+		return fmt.Errorf("don %s has %d signers, at least 4 are required to tolerate a faulty node", d.Name, n)
+	}
+	if n < 3*int(f)+1 {
+		return fmt.Errorf("don %s has %d signers, at least %d are required for f=%d", d.Name, n, 3*int(f)+1, f)
+	}
+	return nil
+}
+
+// maxForwarderSigners is the maximum number of signers of a don in the forwarder, MAX_ORACLES in KeystoneForwarder.sol
+const maxForwarderSigners = 31
+
+// ForwarderConfig returns the signers, f and don id to set in the forwarder for the don. f is the fault tolerance
+// set for the don, or the one registered for the don if none is set. The config is checked against the forwarder's
+// constraints: f must be positive, there must be more than 3f signers and at most maxForwarderSigners
+func (d RegisteredDon) ForwarderConfig() (signers []common.Address, f uint8, donID uint32, err error) {
+	signers = d.Signers()
+	f = d.Info.F
+	if d.FaultTolerance != nil {
+		f = *d.FaultTolerance
+	}
+	if f == 0 {
+		return nil, 0, 0, fmt.Errorf("don %s has f=0, the forwarder requires a positive f", d.Name)
+	}
+	if len(signers) <= 3*int(f) {
+		return nil, 0, 0, fmt.Errorf("don %s has %d signers, at least %d are required for f=%d", d.Name, len(signers), 3*int(f)+1, f)
+	}
+	if len(signers) > maxForwarderSigners {
+		return nil, 0, 0, fmt.Errorf("don %s has %d signers, the forwarder accepts at most %d", d.Name, len(signers), maxForwarderSigners)
+	}
+	return signers, f, d.Info.Id, nil
+}
+
+// ForwarderSetConfigCalldata returns the abi encoded KeystoneForwarder setConfig call for the don's ForwarderConfig.
+// As in configureForwarder, the config version is the config count of the don
+func ForwarderSetConfigCalldata(d RegisteredDon) ([]byte, error) {
+	signers, f, donID, err := d.ForwarderConfig()
+	if err != nil {
+		return nil, err
+	}
+	fwdrABI, err := kf.KeystoneForwarderMetaData.GetAbi()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse forwarder abi: %w", err)
+	}
+	calldata, err := fwdrABI.Pack("setConfig", donID, d.Info.ConfigCount, f, signers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack setConfig for don %s: %w", d.Name, err)
+	}
+	return calldata, nil
+}
+
+// validateDonMapsConsistent returns an error listing the dons that are only in one of the maps
+func validateDonMapsConsistent(caps map[DonName][]kcr.CapabilitiesRegistryCapability, nodes map[DonName][]*Ocr2Node) error {
+	var onlyCaps, onlyNodes []string
+	for donName := range caps {
+		if _, ok := nodes[donName]; !ok {
+			onlyCaps = append(onlyCaps, string(donName))
+		}
+	}
+	for donName := range nodes {
+		if _, ok := caps[donName]; !ok {
+			onlyNodes = append(onlyNodes, string(donName))
+		}
+	}
+	if len(onlyCaps) > 0 || len(onlyNodes) > 0 {
+		slices.Sort(onlyCaps)
+		slices.Sort(onlyNodes)
+		return fmt.Errorf("inconsistent don maps: dons with only capabilities %v, dons with only nodes %v", onlyCaps, onlyNodes)
+	}
+	return nil
+}
+
+func joinInfoAndNodes(ctx context.Context, donInfos map[string]kcr.CapabilitiesRegistryDONInfo, dons []DonCapabilities, registryChainSel uint64, opts ...func(*mapDonsToNodesOpts)) ([]RegisteredDon, error) {
+	if _, err := newMapDonsToNodesOpts(opts).selectorResolver().EVMChainID(registryChainSel); err != nil {
+		return nil, err
+	}
 	// all maps should have the same keys
-	nodes, err := mapDonsToNodes(dons, true, registryChainSel)
+	nodes, err := mapDonsToNodes(ctx, dons, true, registryChainSel, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to map dons to capabilities: %w", err)
 	}
-	if len(donInfos) != len(nodes) {
-		return nil, fmt.Errorf("mismatched lengths don infos %d,  nodes %d", len(donInfos), len(nodes))
+	// bootstraps are excluded, so a don made up of only bootstraps has no nodes and no signers. It is not registered,
+	// see PartitionDons, so it is skipped unless the registry has it
+	var registrable []DonCapabilities
+	for _, don := range dons {
+		if len(nodes[DonName(don.Name)]) == 0 {
+			if _, ok := donInfos[don.Name]; ok {
+				return nil, fmt.Errorf("don %s has no signers: all of its nodes are bootstraps", don.Name)
+			}
+			delete(nodes, DonName(don.Name))
+			continue
+		}
+		registrable = append(registrable, don)
+	}
+	dons = registrable
+	// duplicate capabilities are logged when they are registered, only the keys matter here
+	if err := validateDonMapsConsistent(mapDonsToCaps(logger.Nop(), dons), nodes); err != nil {
+		return nil, err
+	}
+	var onlyOnchain, onlyDesired []string
+	for donName := range donInfos {
+		if _, ok := nodes[DonName(donName)]; !ok {
+			onlyOnchain = append(onlyOnchain, donName)
+		}
+	}
+	for donName := range nodes {
+		if _, ok := donInfos[string(donName)]; !ok {
+			onlyDesired = append(onlyDesired, string(donName))
+		}
+	}
+	if len(onlyOnchain) > 0 || len(onlyDesired) > 0 {
+		slices.Sort(onlyOnchain)
+		slices.Sort(onlyDesired)
+		return nil, fmt.Errorf("mismatched dons: dons only in the registry %v, dons only in the config %v", onlyOnchain, onlyDesired)
 	}
+	donToF := mapDonsToF(dons)
 	var out []RegisteredDon
 	for donName, info := range donInfos {
 
-		ocr2nodes, ok := nodes[donName]
+		ocr2nodes, ok := nodes[DonName(donName)]
 		if !ok {
 			return nil, fmt.Errorf("nodes not found for don %s", donName)
 		}
-		out = append(out, RegisteredDon{
+		rd := RegisteredDon{
 			Name:  donName,
 			Info:  info,
 			Nodes: ocr2nodes,
-		})
+		}
+		if f, ok := donToF[DonName(donName)]; ok {
+			rd.FaultTolerance = &f
+		}
+		out = append(out, rd)
 	}
 
 	return out, nil
 }
 
-func chainConfigFromClo(chain *models.NodeChainConfig) *v1.ChainConfig {
+// jdChainType maps the CLO chain type to the job distributor chain type
+func jdChainType(ct models.ChainType) (v1.ChainType, error) {
+	switch ct {
+	case models.ChainTypeEvm:
+		return v1.ChainType_CHAIN_TYPE_EVM, nil
+	case models.ChainTypeAptos:
+		return v1.ChainType_CHAIN_TYPE_APTOS, nil
+	case models.ChainTypeSolana:
+		return v1.ChainType_CHAIN_TYPE_SOLANA, nil
+	case models.ChainTypeStarknet:
+		return v1.ChainType_CHAIN_TYPE_STARKNET, nil
+	default:
+		return v1.ChainType_CHAIN_TYPE_UNSPECIFIED, fmt.Errorf("unsupported chain type '%s'", ct)
+	}
+}
+
+// cloChainType maps the CLO chain type to the chain type of the node's key bundles
+func cloChainType(ct models.ChainType) (chaintype.ChainType, error) {
+	switch ct {
+	case models.ChainTypeEvm:
+		return chaintype.EVM, nil
+	case models.ChainTypeAptos:
+		return chaintype.Aptos, nil
+	case models.ChainTypeSolana:
+		return chaintype.Solana, nil
+	case models.ChainTypeStarknet:
+		return chaintype.StarkNet, nil
+	default:
+		return "", fmt.Errorf("unsupported chain type '%s'", ct)
+	}
+}
+
+func chainConfigFromClo(chain *models.NodeChainConfig) (*v1.ChainConfig, error) {
+	ct, err := jdChainType(chain.Network.ChainType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert chain config %s: %w", chain.ID, err)
+	}
+	if chain.Ocr2Config == nil {
+		return nil, fmt.Errorf("chain config %s has %w", chain.ID, ErrNoOcr2Config)
+	}
+	var multiaddr string
+	if chain.Ocr2Config.Multiaddr != nil {
+		multiaddr = *chain.Ocr2Config.Multiaddr
+	}
+	ocr2 := &v1.OCR2Config{
+		Enabled:     chain.Ocr2Config.Enabled,
+		IsBootstrap: chain.Ocr2Config.IsBootstrap,
+		Multiaddr:   multiaddr,
+	}
+	// the key bundles are validated by NewOcr2Node, which only requires the p2p key bundle on the registry chain config
+	if b := chain.Ocr2Config.P2pKeyBundle; b != nil {
+		ocr2.P2PKeyBundle = &v1.OCR2Config_P2PKeyBundle{
+			PeerId:    b.PeerID,
+			PublicKey: b.PublicKey,
+		}
+	}
+	if b := chain.Ocr2Config.OcrKeyBundle; b != nil {
+		ocr2.OcrKeyBundle = &v1.OCR2Config_OCRKeyBundle{
+			BundleId:              b.BundleID,
+			OnchainSigningAddress: b.OnchainSigningAddress,
+			OffchainPublicKey:     b.OffchainPublicKey,
+			ConfigPublicKey:       b.ConfigPublicKey,
+		}
+	}
 	return &v1.ChainConfig{
 		Chain: &v1.Chain{
 			Id:   chain.Network.ChainID,
-			Type: v1.ChainType_CHAIN_TYPE_EVM, // TODO: support other chain types
+			Type: ct,
 		},
 
 		AccountAddress: chain.AccountAddress,
 		AdminAddress:   chain.AdminAddress,
-		Ocr2Config: &v1.OCR2Config{
-			Enabled: chain.Ocr2Config.Enabled,
-			P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{
-				PeerId:    chain.Ocr2Config.P2pKeyBundle.PeerID,
-				PublicKey: chain.Ocr2Config.P2pKeyBundle.PublicKey,
-			},
-			OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
-				BundleId:              chain.Ocr2Config.OcrKeyBundle.BundleID,
-				OnchainSigningAddress: chain.Ocr2Config.OcrKeyBundle.OnchainSigningAddress,
-				OffchainPublicKey:     chain.Ocr2Config.OcrKeyBundle.OffchainPublicKey,
-				ConfigPublicKey:       chain.Ocr2Config.OcrKeyBundle.ConfigPublicKey,
-			},
-		},
-	}
+		Ocr2Config:     ocr2,
+	}, nil
 }
 
 var emptyAddr = "0x0000000000000000000000000000000000000000"
 
-// compute the admin address from the string. If the address is empty, replaces the 0s with fs
+// AdminAddrOption is the policy for the zero admin address. The zero value rejects it
+type AdminAddrOption struct {
+	// AllowZeroSubstitution replaces the zero address with the all f address. Only for test nets
+	AllowZeroSubstitution bool
+}
+
+// ErrInvalidAdminAddress is returned for admin addresses that are not hex encoded 20 byte addresses
+var ErrInvalidAdminAddress = errors.New("invalid admin address")
+
+// ValidateAdminAddress checks that addr is a hex encoded 20 byte evm address, with or without the 0x or 0X prefix.
+// An address in mixed case must have a valid EIP-55 checksum; all lower or all upper case addresses are not checksummed
+func ValidateAdminAddress(addr string) error {
+	h := strings.TrimPrefix(normalizeHexPrefix(addr), "0x")
+	if len(h) != 2*common.AddressLength {
+		return fmt.Errorf("%w '%s': expected %d hex characters got %d", ErrInvalidAdminAddress, addr, 2*common.AddressLength, len(h))
+	}
+	if _, err := hex.DecodeString(h); err != nil {
+		return fmt.Errorf("%w '%s': not hex: %w", ErrInvalidAdminAddress, addr, err)
+	}
+	if h != strings.ToLower(h) && h != strings.ToUpper(h) {
+		if want := common.HexToAddress(h).Hex(); want[2:] != h {
+			return fmt.Errorf("%w '%s': invalid checksum, expected %s", ErrInvalidAdminAddress, addr, want)
+		}
+	}
+	return nil
+}
+
+// ErrZeroAdminAddress is returned for the zero admin address when substitution is not allowed
+var ErrZeroAdminAddress = errors.New("zero admin address")
+
+// compute the admin address from the string. If the address is empty and the option allows it, replaces the 0s with fs
 // contract registry disallows 0x0 as an admin address, but our test net nops use it
-func adminAddr(addr string) common.Address {
+func adminAddr(addr string, opt AdminAddrOption) (common.Address, error) {
+	addr = normalizeHexPrefix(addr)
 	needsFixing := addr == emptyAddr
 	addr = strings.TrimPrefix(addr, "0x")
 	if needsFixing {
+		if !opt.AllowZeroSubstitution {
+			return common.Address{}, ErrZeroAdminAddress
+		}
 		addr = strings.ReplaceAll(addr, "0", "f")
 	}
-	return common.HexToAddress(strings.TrimPrefix(addr, "0x"))
+	return common.HexToAddress(strings.TrimPrefix(addr, "0x")), nil
+}
+
+// normalizeHexPrefix lower cases a 0X prefix, as chain ids accept both cases
+func normalizeHexPrefix(s string) string {
+	if strings.HasPrefix(s, "0X") {
+		return "0x" + s[2:]
+	}
+	return s
+}
+
+// aptosAddressLength is the length in bytes of an aptos account address
+const aptosAddressLength = 32
+
+// adminAddrForChain decodes the admin address for the given chain type and validates its length.
+// the zero evm address is handled the same way as in adminAddr
+func adminAddrForChain(addr string, ct chaintype.ChainType, opt AdminAddrOption) ([]byte, error) {
+	var wantLen int
+	switch ct {
+	case chaintype.EVM:
+		if addr == emptyAddr {
+			a, err := adminAddr(addr, opt)
+			if err != nil {
+				return nil, err
+			}
+			return a.Bytes(), nil
+		}
+		wantLen = common.AddressLength
+	case chaintype.Aptos:
+		wantLen = aptosAddressLength
+	default:
+		return nil, fmt.Errorf("unsupported chain type %s for admin address %s", ct, addr)
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(addr, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s admin address %s: %w", ct, addr, err)
+	}
+	if len(b) != wantLen {
+		return nil, fmt.Errorf("invalid %s admin address %s: expected %d bytes got %d", ct, addr, wantLen, len(b))
+	}
+	return b, nil
 }
@@ -1,23 +1,42 @@
 package keystone
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/stretchr/testify/assert"
 	"github.com/test-go/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/smartcontractkit/libocr/offchainreporting2plus/types"
 
 	chainsel "github.com/smartcontractkit/chain-selectors"
 
+	"github.com/smartcontractkit/chainlink-common/pkg/logger"
+	"github.com/smartcontractkit/chainlink-common/pkg/utils/tests"
 	v1 "github.com/smartcontractkit/chainlink-protos/job-distributor/v1/node"
+	"github.com/smartcontractkit/chainlink/deployment"
 	"github.com/smartcontractkit/chainlink/deployment/environment/clo/models"
 	kcr "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/capabilities_registry"
+	kf "github.com/smartcontractkit/chainlink/v2/core/gethwrappers/keystone/generated/forwarder"
 	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/chaintype"
+	"github.com/smartcontractkit/chainlink/v2/core/services/keystore/keys/p2pkey"
+	"github.com/smartcontractkit/chainlink/v2/core/services/ocrcommon"
 )
 
-func Test_newOcr2Node(t *testing.T) {
+func TestNewOcr2Node(t *testing.T) {
 	type args struct {
 		id        string
 		ccfgs     map[chaintype.ChainType]*v1.ChainConfig
@@ -84,7 +103,7 @@ func Test_newOcr2Node(t *testing.T) {
 								BundleId:              "bundleId2",
 								ConfigPublicKey:       "0000015fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
 								OffchainPublicKey:     "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
-								OnchainSigningAddress: "111409a8d4f9a18da55c5b2bb08a3f5f68d44777",
+								OnchainSigningAddress: "111409a8d4f9a18da55c5b2bb08a3f5f68d44777111409a8d4f9a18da55c5b2b",
 							},
 						},
 					},
@@ -121,284 +140,3884 @@ func Test_newOcr2Node(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := newOcr2Node(tt.args.id, tt.args.ccfgs, tt.args.csaPubKey)
+			got, err := NewOcr2Node(tt.args.id, tt.args.ccfgs, tt.args.csaPubKey)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("newOcr2Node() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("NewOcr2Node() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if tt.wantErr {
 				return
 			}
-			assert.NotNil(t, got.ethOcr2KeyBundle)
+			assert.NotNil(t, got.keyBundles[chaintype.EVM])
 			assert.NotNil(t, got.p2pKeyBundle)
 			assert.NotNil(t, got.Signer)
 			assert.NotNil(t, got.EncryptionPublicKey)
 			assert.NotEmpty(t, got.csaKey)
 			assert.NotEmpty(t, got.P2PKey)
-			assert.Equal(t, tt.wantAptos, got.aptosOcr2KeyBundle != nil)
+			assert.Equal(t, tt.wantAptos, got.keyBundles[chaintype.Aptos] != nil)
 		})
 	}
 }
 
-func Test_mapDonsToNodes(t *testing.T) {
-	var (
+func ExampleNewOcr2Node() {
+	n, err := NewOcr2Node("node-1", map[chaintype.ChainType]*v1.ChainConfig{
+		chaintype.EVM: {
+			AccountAddress: "0xA0D3D26B6a4D8d2d3E2B9C2e1b9A1f0E5c4B3A21",
+			Ocr2Config: &v1.OCR2Config{
+				P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{
+					PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv",
+				},
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					BundleId:              "bundleId",
+					ConfigPublicKey:       "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
+					OffchainPublicKey:     "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
+					OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+				},
+			},
+		},
+	}, "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(n.ID)
+	fmt.Println(n.P2PKey.String())
+	fmt.Println(n.signerAddress().Hex())
+	// Output:
+	// node-1
+	// p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv
+	// 0xB35409a8D4F9A18dA55C5B2bb08a3F5F68D44442
+}
+
+func TestBatchDeploy(t *testing.T) {
+	okSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	badSel := chainsel.ETHEREUM_TESTNET_SEPOLIA_ARBITRUM_1.Selector
+	okSel2 := chainsel.ETHEREUM_TESTNET_SEPOLIA_BASE_1.Selector
+	var calls []uint64
+	fakeDeploy := func(req DeployRequest) (*DeployResponse, error) {
+		calls = append(calls, req.Chain.Selector)
+		if req.Chain.Selector == badSel {
+			return nil, errors.New("out of gas")
+		}
+		return &DeployResponse{Address: common.BigToAddress(new(big.Int).SetUint64(req.Chain.Selector))}, nil
+	}
+
+	got := BatchDeploy(BatchDeployRequest{
+		Chains: []deployment.Chain{{Selector: okSel}, {Selector: badSel}, {Selector: okSel2}, {Selector: okSel}},
+	}, fakeDeploy)
+
+	// the failure does not stop the remaining chain and the duplicate is not deployed again
+	assert.Equal(t, []uint64{okSel, badSel, okSel2}, calls)
+	require.Len(t, got.Responses, 2)
+	assert.Equal(t, common.BigToAddress(new(big.Int).SetUint64(okSel)), got.Responses[okSel].Address)
+	assert.Equal(t, common.BigToAddress(new(big.Int).SetUint64(okSel2)), got.Responses[okSel2].Address)
+	require.Len(t, got.Errors, 1)
+	require.ErrorContains(t, got.Errors[badSel], "out of gas")
+	require.ErrorContains(t, got.Err(), fmt.Sprintf("chain %d: out of gas", badSel))
+
+	t.Run("all succeed", func(t *testing.T) {
+		got := BatchDeploy(BatchDeployRequest{Chains: []deployment.Chain{{Selector: okSel}}}, fakeDeploy)
+		assert.Len(t, got.Responses, 1)
+		assert.Empty(t, got.Errors)
+		require.NoError(t, got.Err())
+	})
+}
+
+func TestNop_String(t *testing.T) {
+	n := Nop{
+		CapabilitiesRegistryNodeOperator: kcr.CapabilitiesRegistryNodeOperator{
+			Name:  "nop 1",
+			Admin: common.HexToAddress("0x01"),
+		},
+		NodeIDs: []string{"node-2", "node-1"},
+	}
+	assert.Equal(t, "name=nop 1 admin=0x0000000000000000000000000000000000000001 nodes=[node-1,node-2]", n.String())
+	// the node ids of the operator are not reordered
+	assert.Equal(t, []string{"node-2", "node-1"}, n.NodeIDs)
+
+	assert.Equal(t, "don=don 1 node=node-1", DonNode{Don: "don 1", Node: "node-1"}.String())
+}
+
+func TestMergeCapabilityHosts(t *testing.T) {
+	hosts := []CapabilityHost{
+		{NodeID: "node-b", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap}},
+		{NodeID: "node-a", Capabilities: []kcr.CapabilitiesRegistryCapability{StreamTriggerCap}},
+		// overlaps on OCR3Cap and adds StreamTriggerCap
+		{NodeID: "node-b", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, StreamTriggerCap}},
+	}
+	got := MergeCapabilityHosts(hosts)
+	assert.Equal(t, []CapabilityHost{
+		{NodeID: "node-a", Capabilities: []kcr.CapabilitiesRegistryCapability{StreamTriggerCap}},
+		{NodeID: "node-b", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap, StreamTriggerCap}},
+	}, got)
+	// the input is not modified
+	assert.Equal(t, []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap}, hosts[0].Capabilities)
+	assert.Empty(t, MergeCapabilityHosts(nil))
+}
+
+func TestOcr2Node_toNodeKeys(t *testing.T) {
+	const (
 		pubKey   = "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
-		evmSig   = "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442"
-		aptosSig = "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442b35409a8d4f9a18da55c5b2bb08a3f5f68d44442"
-		peerID   = "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"
-		// todo: these should be defined in common
-		writerCap        = 3
-		ocr3Cap          = 2
-		registryChainSel = chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
-		registryChainID  = strconv.FormatUint(chainsel.ETHEREUM_TESTNET_SEPOLIA.EvmChainID, 10)
+		csaKey   = "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef"
+		aptosKey = "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088"
 	)
-	type args struct {
-		dons              []DonCapabilities
-		excludeBootstraps bool
+	evmCC := &v1.ChainConfig{
+		AccountAddress: "0x1234567890123456789012345678901234567890",
+		Ocr2Config: &v1.OCR2Config{
+			P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{
+				PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv",
+			},
+			OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+				BundleId:              "bundleId",
+				ConfigPublicKey:       pubKey,
+				OffchainPublicKey:     pubKey,
+				OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+			},
+		},
+	}
+	aptosCC := &v1.ChainConfig{
+		Ocr2Config: &v1.OCR2Config{
+			OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+				BundleId:              "aptosBundleId",
+				OnchainSigningAddress: aptosKey,
+			},
+		},
 	}
+	evmKeys := NodeKeys{
+		EthAddress:            "0x1234567890123456789012345678901234567890",
+		P2PPeerID:             "12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv",
+		OCR2BundleID:          "bundleId",
+		OCR2OnchainPublicKey:  "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+		OCR2OffchainPublicKey: pubKey,
+		OCR2ConfigPublicKey:   pubKey,
+		CSAPublicKey:          csaKey,
+		EncryptionPublicKey:   csaKey,
+	}
+	aptosKeys := evmKeys
+	aptosKeys.AptosBundleID = "aptosBundleId"
+	aptosKeys.AptosOnchainPublicKey = aptosKey
+
 	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
+		name  string
+		ccfgs map[chaintype.ChainType]*v1.ChainConfig
+		want  NodeKeys
 	}{
 		{
-			name: "writer evm only",
-			args: args{
-				dons: []DonCapabilities{
-					{
-						Name: "ok writer",
-						Nops: []*models.NodeOperator{
-							{
-								Nodes: []*models.Node{
-									{
-										PublicKey: &pubKey,
-										ChainConfigs: []*models.NodeChainConfig{
-											{
-												ID: "1",
-												Network: &models.Network{
-													ChainType: models.ChainTypeEvm,
-													ChainID:   registryChainID,
-												},
-												Ocr2Config: &models.NodeOCR2Config{
-													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
-														PeerID: peerID,
-													},
-													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
-														ConfigPublicKey:       pubKey,
-														OffchainPublicKey:     pubKey,
-														OnchainSigningAddress: evmSig,
-													},
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-						Capabilities: []kcr.CapabilitiesRegistryCapability{
-							{
-								LabelledName:   "writer",
-								Version:        "1",
-								CapabilityType: uint8(writerCap),
-							},
-						},
-					},
+			name:  "evm",
+			ccfgs: map[chaintype.ChainType]*v1.ChainConfig{chaintype.EVM: evmCC},
+			want:  evmKeys,
+		},
+		{
+			name:  "evm and aptos",
+			ccfgs: map[chaintype.ChainType]*v1.ChainConfig{chaintype.EVM: evmCC, chaintype.Aptos: aptosCC},
+			want:  aptosKeys,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := NewOcr2Node("node-1", tt.ccfgs, csaKey)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, n.toNodeKeys())
+		})
+	}
+}
+
+func TestOcr2Node_toNodeKeysWithOptions(t *testing.T) {
+	const (
+		pubKey = "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+		csaKey = "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef"
+		peerID = "12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"
+	)
+	n, err := NewOcr2Node("node-1", map[chaintype.ChainType]*v1.ChainConfig{
+		chaintype.EVM: {
+			Ocr2Config: &v1.OCR2Config{
+				P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{PeerId: "p2p_" + peerID},
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					ConfigPublicKey:       pubKey,
+					OffchainPublicKey:     pubKey,
+					OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
 				},
 			},
-			wantErr: false,
 		},
-		{
-			name: "err if no evm chain",
-			args: args{
-				dons: []DonCapabilities{
-					{
-						Name: "bad chain",
-						Nops: []*models.NodeOperator{
-							{
-								Nodes: []*models.Node{
-									{
-										PublicKey: &pubKey,
-										ChainConfigs: []*models.NodeChainConfig{
-											{
-												ID: "1",
-												Network: &models.Network{
-													ChainType: models.ChainTypeSolana,
-												},
-												Ocr2Config: &models.NodeOCR2Config{
-													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
-														PeerID: peerID,
-													},
-													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
-														ConfigPublicKey:       pubKey,
-														OffchainPublicKey:     pubKey,
-														OnchainSigningAddress: evmSig,
-													},
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-						Capabilities: []kcr.CapabilitiesRegistryCapability{
-							{
-								LabelledName:   "writer",
-								Version:        "1",
-								CapabilityType: uint8(writerCap),
-							},
+	}, csaKey)
+	require.NoError(t, err)
+	// csa keys of nodes are sometimes prefixed, eg as shown by the node
+	n.csaKey = "csa_" + csaKey
+
+	stripped := n.toNodeKeysWithOptions(NodeKeysOptions{StripPrefixes: true})
+	assert.Equal(t, peerID, stripped.P2PPeerID)
+	assert.Equal(t, csaKey, stripped.EncryptionPublicKey)
+	assert.Equal(t, "csa_"+csaKey, stripped.CSAPublicKey)
+	assert.Equal(t, n.toNodeKeys(), stripped, "toNodeKeys strips the prefixes")
+
+	kept := n.toNodeKeysWithOptions(NodeKeysOptions{})
+	assert.Equal(t, "p2p_"+peerID, kept.P2PPeerID)
+	assert.Equal(t, "csa_"+csaKey, kept.EncryptionPublicKey)
+	assert.Equal(t, "csa_"+csaKey, kept.CSAPublicKey)
+	kept.P2PPeerID, kept.EncryptionPublicKey = stripped.P2PPeerID, stripped.EncryptionPublicKey
+	assert.Equal(t, stripped, kept, "only the prefixes differ")
+}
+
+func TestOcr2Node_signerForChain(t *testing.T) {
+	aptosKey := "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088"
+	var signer [32]byte
+	copy(signer[:], common.HexToAddress("0xb35409a8d4f9a18da55c5b2bb08a3f5f68d44442").Bytes())
+	n := &Ocr2Node{
+		ID:     "node-1",
+		Signer: signer,
+		keyBundles: map[chaintype.ChainType]*v1.OCR2Config_OCRKeyBundle{
+			chaintype.EVM:   {OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442"},
+			chaintype.Aptos: {OnchainSigningAddress: aptosKey},
+		},
+	}
+
+	got, err := n.signerForChain(chaintype.EVM)
+	require.NoError(t, err)
+	assert.Equal(t, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", hex.EncodeToString(got))
+	assert.Equal(t, n.signerAddress().Bytes(), got)
+
+	got, err = n.signerForChain(chaintype.Aptos)
+	require.NoError(t, err)
+	assert.Equal(t, aptosKey, hex.EncodeToString(got))
+
+	_, err = n.signerForChain(chaintype.Solana)
+	require.ErrorContains(t, err, "node node-1 has no solana key bundle")
+
+	n.keyBundles[chaintype.Aptos] = &v1.OCR2Config_OCRKeyBundle{OnchainSigningAddress: "ac364cec9fe7d9ea"}
+	_, err = n.signerForChain(chaintype.Aptos)
+	require.ErrorContains(t, err, "expected 32 bytes got 8")
+
+	_, err = n.signerForChain(chaintype.Cosmos)
+	require.ErrorContains(t, err, "unsupported chain type")
+}
+
+func Test_parseOnchainSigner(t *testing.T) {
+	const (
+		evmSigner   = "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442"
+		aptosSigner = "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088"
+	)
+	tests := []struct {
+		name    string
+		ct      chaintype.ChainType
+		signer  string
+		wantLen int
+		wantErr string
+	}{
+		{name: "evm", ct: chaintype.EVM, signer: evmSigner, wantLen: 20},
+		{name: "aptos", ct: chaintype.Aptos, signer: aptosSigner, wantLen: 32},
+		{name: "solana", ct: chaintype.Solana, signer: aptosSigner, wantLen: 32},
+		{name: "evm too long", ct: chaintype.EVM, signer: aptosSigner, wantErr: "invalid evm signer '" + aptosSigner + "': expected 20 bytes got 32"},
+		{name: "aptos too short", ct: chaintype.Aptos, signer: evmSigner, wantErr: "expected 32 bytes got 20"},
+		{name: "not hex", ct: chaintype.EVM, signer: "0x" + evmSigner[2:], wantErr: "invalid evm signer"},
+		{name: "unsupported", ct: chaintype.Cosmos, signer: aptosSigner, wantErr: "unsupported chain type cosmos"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOnchainSigner(tt.ct, tt.signer)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, got, tt.wantLen)
+			assert.Equal(t, tt.signer, hex.EncodeToString(got))
+		})
+	}
+
+	t.Run("signers", func(t *testing.T) {
+		newCfgs := func(signer string) map[chaintype.ChainType]*v1.ChainConfig {
+			return map[chaintype.ChainType]*v1.ChainConfig{
+				chaintype.EVM: {
+					Ocr2Config: &v1.OCR2Config{
+						P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"},
+						OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+							OnchainSigningAddress: signer,
+							ConfigPublicKey:       aptosSigner,
 						},
 					},
 				},
+			}
+		}
+		n, err := NewOcr2Node("node-1", newCfgs(evmSigner), aptosSigner)
+		require.NoError(t, err)
+		assert.Equal(t, evmSigner+strings.Repeat("00", 12), hex.EncodeToString(n.Signer[:]))
+
+		_, err = NewOcr2Node("node-1", newCfgs(aptosSigner), aptosSigner)
+		require.ErrorContains(t, err, "invalid onchain signing address of node node-1: invalid evm signer")
+
+		for _, ct := range []chaintype.ChainType{chaintype.Aptos, chaintype.Solana} {
+			cfgs := newCfgs(evmSigner)
+			cfgs[ct] = &v1.ChainConfig{Ocr2Config: &v1.OCR2Config{OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{OnchainSigningAddress: aptosSigner}}}
+			n, err := NewOcr2Node("node-1", cfgs, aptosSigner)
+			require.NoError(t, err)
+			assert.Equal(t, aptosSigner, hex.EncodeToString(n.onchainSigners[ct]))
+
+			cfgs[ct].Ocr2Config.OcrKeyBundle.OnchainSigningAddress = evmSigner
+			_, err = NewOcr2Node("node-1", cfgs, aptosSigner)
+			require.ErrorContains(t, err, "invalid onchain signing address of node node-1: invalid "+string(ct)+" signer")
+
+			// bootstraps do not sign
+			cfgs[ct].Ocr2Config.IsBootstrap = true
+			_, err = NewOcr2Node("node-1", cfgs, aptosSigner)
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestOcr2Node_toNodeKeysChecked(t *testing.T) {
+	newNode := func(aptosKey string) *Ocr2Node {
+		return &Ocr2Node{
+			ID:           "node-1",
+			p2pKeyBundle: &v1.OCR2Config_P2PKeyBundle{PeerId: "p2p_peer"},
+			keyBundles: map[chaintype.ChainType]*v1.OCR2Config_OCRKeyBundle{
+				chaintype.EVM:   {BundleId: "eth-bundle"},
+				chaintype.Aptos: {BundleId: "aptos-bundle", OnchainSigningAddress: aptosKey},
+			},
+		}
+	}
+	tests := []struct {
+		name    string
+		node    *Ocr2Node
+		wantErr string
+	}{
+		{
+			name: "no aptos bundle",
+			node: &Ocr2Node{
+				ID:           "node-1",
+				p2pKeyBundle: &v1.OCR2Config_P2PKeyBundle{PeerId: "p2p_peer"},
+				keyBundles:   map[chaintype.ChainType]*v1.OCR2Config_OCRKeyBundle{chaintype.EVM: {BundleId: "eth-bundle"}},
 			},
-			wantErr: true,
 		},
 		{
-			name: "ocr3 cap evm only",
-			args: args{
-				dons: []DonCapabilities{
-					{
-						Name: "bad chain",
-						Nops: []*models.NodeOperator{
-							{
-								Nodes: []*models.Node{
-									{
-										PublicKey: &pubKey,
-										ChainConfigs: []*models.NodeChainConfig{
-											{
-												ID: "1",
-												Network: &models.Network{
-													ChainType: models.ChainTypeEvm,
-													ChainID:   registryChainID,
-												},
-												Ocr2Config: &models.NodeOCR2Config{
-													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
-														PeerID: peerID,
-													},
-													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
-														ConfigPublicKey:       pubKey,
-														OffchainPublicKey:     pubKey,
-														OnchainSigningAddress: evmSig,
-													},
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-						Capabilities: []kcr.CapabilitiesRegistryCapability{
-							{
-								LabelledName:   "ocr3",
-								Version:        "1",
-								CapabilityType: uint8(ocr3Cap),
-							},
-						},
-					},
+			name: "valid aptos key",
+			node: newNode("ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088"),
+		},
+		{
+			name:    "short aptos key",
+			node:    newNode("ac364cec9fe7d9ea1035fc511e5b2f30"),
+			wantErr: "expected 32 bytes got 16",
+		},
+		{
+			name:    "non hex aptos key",
+			node:    newNode("0xac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088"),
+			wantErr: "invalid aptos onchain public key",
+		},
+		{
+			name:    "empty aptos key",
+			node:    newNode(""),
+			wantErr: "expected 32 bytes got 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.node.toNodeKeysChecked()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				_, err = makeNodeKeysSlice([]*Ocr2Node{tt.node})
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.node.toNodeKeys(), got)
+			if aptos := tt.node.keyBundles[chaintype.Aptos]; aptos != nil {
+				assert.Equal(t, aptos.OnchainSigningAddress, got.AptosOnchainPublicKey)
+			}
+		})
+	}
+}
+
+func TestOcr2Node_AccountAddress(t *testing.T) {
+	pubKey := "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+	evmAddr := "0x1234567890123456789012345678901234567890"
+	aptosAddr := "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088"
+	n, err := NewOcr2Node("node-1", map[chaintype.ChainType]*v1.ChainConfig{
+		chaintype.EVM: {
+			AccountAddress: evmAddr,
+			Ocr2Config: &v1.OCR2Config{
+				P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{
+					PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv",
+				},
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					ConfigPublicKey:       pubKey,
+					OffchainPublicKey:     pubKey,
+					OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
 				},
 			},
-			wantErr: false,
 		},
-		{
-			name: "ocr3 cap evm & aptos",
-			args: args{
-				dons: []DonCapabilities{
-					{
-						Name: "ok chain",
-						Nops: []*models.NodeOperator{
-							{
-								Nodes: []*models.Node{
-									{
-										PublicKey: &pubKey,
-										ChainConfigs: []*models.NodeChainConfig{
-											{
-												ID: "1",
-												Network: &models.Network{
-													ChainType: models.ChainTypeEvm,
-													ChainID:   registryChainID,
-												},
-												Ocr2Config: &models.NodeOCR2Config{
-													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
-														PeerID: peerID,
-													},
-													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
-														ConfigPublicKey:       pubKey,
-														OffchainPublicKey:     pubKey,
-														OnchainSigningAddress: evmSig,
-													},
-												},
-											},
-											{
-												ID: "2",
-												Network: &models.Network{
-													ChainType: models.ChainTypeAptos,
-												},
-												Ocr2Config: &models.NodeOCR2Config{
-													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
-														PeerID: peerID,
-													},
-													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
-														ConfigPublicKey:       pubKey,
-														OffchainPublicKey:     pubKey,
-														OnchainSigningAddress: aptosSig,
-													},
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-						Capabilities: []kcr.CapabilitiesRegistryCapability{
-							{
-								LabelledName:   "ocr3",
-								Version:        "1",
-								CapabilityType: uint8(ocr3Cap),
-							},
+		chaintype.Aptos: {
+			AccountAddress: aptosAddr,
+			Ocr2Config: &v1.OCR2Config{
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					OnchainSigningAddress: aptosAddr,
+				},
+			},
+		},
+	}, "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	require.NoError(t, err)
+
+	got, ok := n.AccountAddress(chaintype.EVM)
+	assert.True(t, ok)
+	assert.Equal(t, evmAddr, got)
+	got, ok = n.AccountAddress(chaintype.Aptos)
+	assert.True(t, ok)
+	assert.Equal(t, aptosAddr, got)
+	_, ok = n.AccountAddress(chaintype.Solana)
+	assert.False(t, ok)
+
+	k := n.toNodeKeys()
+	assert.Equal(t, evmAddr, k.EthAddress)
+	assert.Equal(t, aptosAddr, k.AptosAccount)
+}
+
+func TestNodeKeys_ToOcr2Node(t *testing.T) {
+	pubKey := "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+	node, err := NewOcr2Node("node-1", map[chaintype.ChainType]*v1.ChainConfig{
+		chaintype.EVM: {
+			AccountAddress: "0x1234567890123456789012345678901234567890",
+			Ocr2Config: &v1.OCR2Config{
+				P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{
+					PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv",
+				},
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					BundleId:              "bundleId",
+					ConfigPublicKey:       pubKey,
+					OffchainPublicKey:     pubKey,
+					OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+				},
+			},
+		},
+		chaintype.Aptos: {
+			Ocr2Config: &v1.OCR2Config{
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					BundleId:              "aptosBundleId",
+					OnchainSigningAddress: "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088",
+				},
+			},
+		},
+	}, "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	require.NoError(t, err)
+
+	got, err := node.toNodeKeys().ToOcr2Node("node-1")
+	require.NoError(t, err)
+	assert.Equal(t, node, got)
+
+	t.Run("invalid", func(t *testing.T) {
+		valid := node.toNodeKeys()
+		tests := []struct {
+			name   string
+			mutate func(*NodeKeys)
+		}{
+			{name: "signer", mutate: func(k *NodeKeys) { k.OCR2OnchainPublicKey = "b35409" }},
+			{name: "peer id", mutate: func(k *NodeKeys) { k.P2PPeerID = "not a peer id" }},
+			{name: "csa key", mutate: func(k *NodeKeys) { k.CSAPublicKey = "1234" }},
+			{name: "aptos key", mutate: func(k *NodeKeys) { k.AptosOnchainPublicKey = "ac36" }},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				k := valid
+				tt.mutate(&k)
+				_, err := k.ToOcr2Node("node-1")
+				require.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestOcr2Node_EncryptionPublicKeyHex(t *testing.T) {
+	var n Ocr2Node
+	for i := range n.EncryptionPublicKey {
+		n.EncryptionPublicKey[i] = byte(0xa0 + i)
+	}
+	got := n.EncryptionPublicKeyHex()
+	assert.Equal(t, "a0a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf", got)
+	b, err := hex.DecodeString(got)
+	require.NoError(t, err)
+	assert.Equal(t, n.EncryptionPublicKey[:], b)
+
+	// the csa key is the default encryption key
+	csaKey := "03DACD15FC96C965C648E3623180DE002B71A97CF6EECA9AFFB91F461DCD6CE1"
+	node, err := NewOcr2Node("node-1", map[chaintype.ChainType]*v1.ChainConfig{
+		chaintype.EVM: {
+			Ocr2Config: &v1.OCR2Config{
+				P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"},
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+					ConfigPublicKey:       csaKey,
+				},
+			},
+		},
+	}, csaKey)
+	require.NoError(t, err)
+	assert.Equal(t, strings.ToLower(csaKey), node.EncryptionPublicKeyHex())
+}
+
+func TestOcr2Node_Equal(t *testing.T) {
+	newNode := func() *Ocr2Node {
+		return &Ocr2Node{
+			ID:           "node-1",
+			Signer:       [32]byte{1},
+			P2PKey:       p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID(),
+			IsBoostrap:   true,
+			p2pKeyBundle: &v1.OCR2Config_P2PKeyBundle{PeerId: "p2p_peer"},
+			keyBundles: map[chaintype.ChainType]*v1.OCR2Config_OCRKeyBundle{
+				chaintype.EVM:   {BundleId: "eth-bundle"},
+				chaintype.Aptos: {BundleId: "aptos-bundle"},
+			},
+			accountAddresses: map[chaintype.ChainType]string{chaintype.EVM: "0x01"},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(*Ocr2Node)
+		want   bool
+	}{
+		{name: "same", modify: func(*Ocr2Node) {}, want: true},
+		{
+			name: "same bundles in different pointers",
+			modify: func(n *Ocr2Node) {
+				n.p2pKeyBundle = &v1.OCR2Config_P2PKeyBundle{PeerId: "p2p_peer"}
+				n.keyBundles[chaintype.EVM] = &v1.OCR2Config_OCRKeyBundle{BundleId: "eth-bundle"}
+			},
+			want: true,
+		},
+		{name: "id", modify: func(n *Ocr2Node) { n.ID = "node-2" }},
+		{name: "signer", modify: func(n *Ocr2Node) { n.Signer = [32]byte{2} }},
+		{name: "p2p key", modify: func(n *Ocr2Node) { n.P2PKey = p2pkey.MustNewV2XXXTestingOnly(big.NewInt(2)).PeerID() }},
+		{name: "encryption key", modify: func(n *Ocr2Node) { n.EncryptionPublicKey = [32]byte{1} }},
+		{name: "bootstrap", modify: func(n *Ocr2Node) { n.IsBoostrap = false }},
+		{name: "bundle id", modify: func(n *Ocr2Node) { n.keyBundles[chaintype.Aptos] = &v1.OCR2Config_OCRKeyBundle{BundleId: "other"} }},
+		{name: "missing bundle", modify: func(n *Ocr2Node) { delete(n.keyBundles, chaintype.Aptos) }},
+		{name: "account address", modify: func(n *Ocr2Node) { n.accountAddresses[chaintype.EVM] = "0x02" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newNode()
+			tt.modify(n)
+			assert.Equal(t, tt.want, newNode().Equal(n))
+			assert.Equal(t, tt.want, n.Equal(newNode()))
+		})
+	}
+
+	var nilNode *Ocr2Node
+	assert.True(t, nilNode.Equal(nil))
+	assert.False(t, nilNode.Equal(newNode()))
+	assert.False(t, newNode().Equal(nil))
+}
+
+func TestNodeKeys_Equal(t *testing.T) {
+	k := NodeKeys{EthAddress: "0x01", P2PPeerID: "peer", OCR2BundleID: "bundle"}
+	other := k
+	assert.True(t, k.Equal(other))
+	other.OCR2BundleID = "other"
+	assert.False(t, k.Equal(other))
+	assert.False(t, k.Equal(NodeKeys{}))
+}
+
+func TestGenerateOCR3Config(t *testing.T) {
+	cfg := OracleConfigWithSecrets{
+		OracleConfig: OracleConfig{MaxFaultyOracles: 1},
+		OCRSecrets:   deployment.XXXGenerateTestOCRSecrets(),
+	}
+	newNodeKeys := func() []NodeKeys {
+		var out []NodeKeys
+		for i := 1; i <= 4; i++ {
+			out = append(out, NodeKeys{
+				EthAddress:            fmt.Sprintf("0x%040x", i),
+				P2PPeerID:             p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID().Raw(),
+				OCR2OnchainPublicKey:  fmt.Sprintf("%040x", i),
+				OCR2OffchainPublicKey: fmt.Sprintf("%064x", i),
+				OCR2ConfigPublicKey:   fmt.Sprintf("%064x", 100+i),
+			})
+		}
+		return out
+	}
+	signerKeys := func(t *testing.T, got Orc2drOracleConfig) []map[string]types.OnchainPublicKey {
+		var out []map[string]types.OnchainPublicKey
+		for _, s := range got.Signers {
+			keys, err := ocrcommon.UnmarshalMultichainPublicKey(s)
+			require.NoError(t, err)
+			out = append(out, keys)
+		}
+		return out
+	}
+
+	t.Run("starknet keys", func(t *testing.T) {
+		nks := newNodeKeys()
+		for i := range nks {
+			// starknet keys are felts, which are left padded to 32 bytes
+			nks[i].StarknetOnchainPublicKey = fmt.Sprintf("0x%x", 300+i)
+		}
+		got, err := GenerateOCR3Config(cfg, nks)
+		require.NoError(t, err)
+		require.Len(t, got.Signers, 4)
+		for i, keys := range signerKeys(t, got) {
+			require.Len(t, keys, 2)
+			assert.Equal(t, fmt.Sprintf("%040x", i+1), hex.EncodeToString(keys[string(chaintype.EVM)]))
+			assert.Equal(t, fmt.Sprintf("%064x", 300+i), hex.EncodeToString(keys[string(chaintype.StarkNet)]))
+		}
+
+		nks[1].StarknetOnchainPublicKey = nks[0].StarknetOnchainPublicKey
+		_, err = GenerateOCR3Config(cfg, nks)
+		require.ErrorContains(t, err, fmt.Sprintf("Duplicate onchain public key: '%064x'", 300))
+
+		nks[1].StarknetOnchainPublicKey = "not hex"
+		_, err = GenerateOCR3Config(cfg, nks)
+		require.ErrorContains(t, err, "failed to decode StarknetOnchainPublicKey")
+	})
+
+	t.Run("solana keys", func(t *testing.T) {
+		nks := newNodeKeys()
+		for i := range nks {
+			nks[i].SolanaOnchainPublicKey = fmt.Sprintf("%064x", 200+i)
+		}
+		got, err := GenerateOCR3Config(cfg, nks)
+		require.NoError(t, err)
+		require.Len(t, got.Signers, 4)
+		for i, keys := range signerKeys(t, got) {
+			require.Len(t, keys, 2)
+			assert.Equal(t, fmt.Sprintf("%040x", i+1), hex.EncodeToString(keys[string(chaintype.EVM)]))
+			assert.Equal(t, fmt.Sprintf("%064x", 200+i), hex.EncodeToString(keys[string(chaintype.Solana)]))
+		}
+
+		nks[3].SolanaOnchainPublicKey = nks[2].SolanaOnchainPublicKey
+		_, err = GenerateOCR3Config(cfg, nks)
+		require.ErrorContains(t, err, fmt.Sprintf("Duplicate onchain public key: '%064x'", 202))
+
+		// keys of different chains must be unique too
+		nks[0].StarknetOnchainPublicKey = fmt.Sprintf("%064x", 1)
+		nks[3].SolanaOnchainPublicKey = fmt.Sprintf("%064x", 1)
+		_, err = GenerateOCR3Config(cfg, nks)
+		require.ErrorContains(t, err, fmt.Sprintf("Duplicate onchain public key: '%064x'", 1))
+
+		nks[0].StarknetOnchainPublicKey = ""
+		nks[3].SolanaOnchainPublicKey = "not hex"
+		_, err = GenerateOCR3Config(cfg, nks)
+		require.ErrorContains(t, err, "failed to decode SolanaOnchainPublicKey")
+	})
+}
+
+func TestNodeKeys_ValidateForOCR3(t *testing.T) {
+	peerID := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+	n, err := newOcr2NodeFromClo(tests.Context(t), newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false), chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	complete := n.toNodeKeys()
+
+	t.Run("complete", func(t *testing.T) {
+		require.NoError(t, complete.ValidateForOCR3())
+		nks, err := makeNodeKeysSlice([]*Ocr2Node{n}, withOCR3Validation())
+		require.NoError(t, err)
+		assert.Equal(t, []NodeKeys{complete}, nks)
+	})
+
+	t.Run("missing offchain key", func(t *testing.T) {
+		k := complete
+		k.OCR2OffchainPublicKey = ""
+		err := k.ValidateForOCR3()
+		require.ErrorContains(t, err, "OCR2OffchainPublicKey is required")
+
+		// every invalid key is reported
+		k.P2PPeerID = "not-a-peer-id"
+		k.OCR2ConfigPublicKey = "abcd"
+		err = k.ValidateForOCR3()
+		require.ErrorContains(t, err, "OCR2OffchainPublicKey is required")
+		require.ErrorContains(t, err, "invalid OCR2ConfigPublicKey")
+		require.ErrorContains(t, err, "invalid P2PPeerID")
+	})
+
+	t.Run("makeNodeKeysSlice", func(t *testing.T) {
+		bad := *n
+		bad.keyBundles = map[chaintype.ChainType]*v1.OCR2Config_OCRKeyBundle{chaintype.EVM: {
+			OnchainSigningAddress: n.keyBundles[chaintype.EVM].OnchainSigningAddress,
+			ConfigPublicKey:       n.keyBundles[chaintype.EVM].ConfigPublicKey,
+		}}
+		_, err := makeNodeKeysSlice([]*Ocr2Node{&bad})
+		require.NoError(t, err, "the keys are only validated with withOCR3Validation")
+		_, err = makeNodeKeysSlice([]*Ocr2Node{&bad}, withOCR3Validation())
+		require.ErrorContains(t, err, "invalid keys of node node-1")
+		require.ErrorContains(t, err, "OCR2OffchainPublicKey is required")
+	})
+}
+
+func TestPartitionNodeKeys(t *testing.T) {
+	var nodes []*Ocr2Node
+	for i := 1; i <= 5; i++ {
+		peerID := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID().String()
+		n, err := newOcr2NodeFromClo(tests.Context(t), newTestCloNode(fmt.Sprintf("node-%d", i), peerID, fmt.Sprintf("%040x", i), i == 2 || i == 5), chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.NoError(t, err)
+		nodes = append(nodes, n)
+	}
+	nks, err := makeNodeKeysSlice(nodes)
+	require.NoError(t, err)
+	for i, k := range nks {
+		assert.Equal(t, nodes[i].IsBootstrap(), k.IsBootstrap, "node %d", i)
+	}
+
+	bootstraps, signers := PartitionNodeKeys(nks)
+	assert.Equal(t, []NodeKeys{nks[1], nks[4]}, bootstraps)
+	assert.Equal(t, []NodeKeys{nks[0], nks[2], nks[3]}, signers)
+
+	bootstraps, signers = PartitionNodeKeys(nil)
+	assert.Empty(t, bootstraps)
+	assert.Empty(t, signers)
+
+	// the flag survives the conversion back to a node
+	n, err := nks[1].ToOcr2Node("node-2")
+	require.NoError(t, err)
+	assert.True(t, n.IsBootstrap())
+}
+
+func TestNormalizePeerID(t *testing.T) {
+	want := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID()
+	for _, in := range []string{want.String(), want.Raw()} {
+		got, err := normalizePeerID(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := normalizePeerID("")
+	require.ErrorContains(t, err, "empty peer id")
+	_, err = normalizePeerID("p2p_")
+	require.ErrorContains(t, err, "empty peer id")
+	_, err = normalizePeerID("p2p_not-a-peer-id")
+	require.ErrorContains(t, err, "failed to unmarshal peer id")
+
+	t.Run("node keys are canonical", func(t *testing.T) {
+		newNode := func(peerID string) *Ocr2Node {
+			ccfgs := map[chaintype.ChainType]*v1.ChainConfig{
+				chaintype.EVM: {
+					Ocr2Config: &v1.OCR2Config{
+						P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{PeerId: peerID},
+						OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+							OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+							ConfigPublicKey:       "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
 						},
 					},
 				},
+			}
+			n, err := NewOcr2Node("node-1", ccfgs, "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1")
+			require.NoError(t, err)
+			return n
+		}
+		prefixed, bare := newNode(want.String()), newNode(want.Raw())
+		assert.Equal(t, want, prefixed.P2PKey)
+		assert.Equal(t, want, bare.P2PKey)
+		assert.Equal(t, want.Raw(), prefixed.toNodeKeys().P2PPeerID)
+		assert.Equal(t, prefixed.toNodeKeys(), bare.toNodeKeys())
+
+		// keys with a prefixed peer id convert back to the same node
+		keys := bare.toNodeKeys()
+		keys.P2PPeerID = want.String()
+		got, err := keys.ToOcr2Node("node-1")
+		require.NoError(t, err)
+		assert.Equal(t, want, got.P2PKey)
+	})
+}
+
+func TestNewOcr2Node_csaKeyErrors(t *testing.T) {
+	ccfgs := map[chaintype.ChainType]*v1.ChainConfig{
+		chaintype.EVM: {
+			Ocr2Config: &v1.OCR2Config{
+				P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{
+					PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv",
+				},
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+				},
 			},
-			wantErr: false,
 		},
 	}
+
+	_, err := NewOcr2Node("node-1", ccfgs, "")
+	require.ErrorIs(t, err, ErrEmptyCSAKey)
+
+	tests := []struct {
+		name       string
+		csaKey     string
+		wantReason string
+	}{
+		{name: "not hex", csaKey: "not hex", wantReason: "failed to decode"},
+		{name: "wrong length", csaKey: "1234", wantReason: "expected len 32 got 2"},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := mapDonsToNodes(tt.args.dons, tt.args.excludeBootstraps, registryChainSel)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("mapDonsToNodes() error = %v, wantErr %v", err, tt.wantErr)
+			_, err := NewOcr2Node("node-1", ccfgs, tt.csaKey)
+			var csaErr *ErrInvalidCSAKey
+			require.ErrorAs(t, err, &csaErr)
+			assert.Equal(t, tt.csaKey, csaErr.Value)
+			assert.Contains(t, csaErr.Reason, tt.wantReason)
+			assert.NotErrorIs(t, err, ErrEmptyCSAKey)
+		})
+	}
+}
+
+func TestNewOcr2Node_configPublicKey(t *testing.T) {
+	csaKey := "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+	newCfgs := func(configPublicKey string, isBootstrap bool) map[chaintype.ChainType]*v1.ChainConfig {
+		return map[chaintype.ChainType]*v1.ChainConfig{
+			chaintype.EVM: {
+				Ocr2Config: &v1.OCR2Config{
+					IsBootstrap:  isBootstrap,
+					P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"},
+					OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+						OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+						ConfigPublicKey:       configPublicKey,
+					},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name            string
+		configPublicKey string
+		isBootstrap     bool
+		wantErr         string
+	}{
+		{name: "valid", configPublicKey: csaKey},
+		{name: "missing", wantErr: "invalid ocr2 config public key of node node-1: empty key"},
+		{name: "not hex", configPublicKey: "not hex", wantErr: "failed to decode 'not hex'"},
+		{name: "wrong length", configPublicKey: "1234", wantErr: "'1234' has len 2, expected 32"},
+		{name: "bootstrap without key", isBootstrap: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := NewOcr2Node("node-1", newCfgs(tt.configPublicKey, tt.isBootstrap), csaKey)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
 				return
 			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.configPublicKey, n.toNodeKeys().OCR2ConfigPublicKey)
 		})
 	}
-	// make sure the clo test data is correct
-	wfNops := loadTestNops(t, "testdata/workflow_nodes.json")
-	cwNops := loadTestNops(t, "testdata/chain_writer_nodes.json")
-	assetNops := loadTestNops(t, "testdata/asset_nodes.json")
-	require.Len(t, wfNops, 10)
-	require.Len(t, cwNops, 10)
-	require.Len(t, assetNops, 16)
+}
 
-	wfDon := DonCapabilities{
-		Name:         WFDonName,
-		Nops:         wfNops,
-		Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+func TestNewOcr2Node_encryptionPublicKey(t *testing.T) {
+	pubKey := "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+	csaKey := "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef"
+	encKey := "fedcba0987654321fedcba0987654321fedcba0987654321fedcba0987654321"
+	ccfgs := map[chaintype.ChainType]*v1.ChainConfig{
+		chaintype.EVM: {
+			AccountAddress: "0x1234567890123456789012345678901234567890",
+			Ocr2Config: &v1.OCR2Config{
+				P2PKeyBundle: &v1.OCR2Config_P2PKeyBundle{
+					PeerId: "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv",
+				},
+				OcrKeyBundle: &v1.OCR2Config_OCRKeyBundle{
+					BundleId:              "bundleId",
+					ConfigPublicKey:       pubKey,
+					OffchainPublicKey:     pubKey,
+					OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+				},
+			},
+		},
 	}
-	cwDon := DonCapabilities{
-		Name:         TargetDonName,
-		Nops:         cwNops,
-		Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap},
+
+	t.Run("defaults to csa key", func(t *testing.T) {
+		n, err := NewOcr2Node("node-1", ccfgs, csaKey)
+		require.NoError(t, err)
+		assert.Equal(t, csaKey, hex.EncodeToString(n.EncryptionPublicKey[:]))
+		assert.Equal(t, csaKey, n.toNodeKeys().EncryptionPublicKey)
+	})
+
+	t.Run("explicit key", func(t *testing.T) {
+		n, err := NewOcr2Node("node-1", ccfgs, csaKey, WithEncryptionPublicKey(encKey))
+		require.NoError(t, err)
+		assert.Equal(t, encKey, hex.EncodeToString(n.EncryptionPublicKey[:]))
+		k := n.toNodeKeys()
+		assert.Equal(t, encKey, k.EncryptionPublicKey)
+		assert.Equal(t, csaKey, k.CSAPublicKey)
+
+		got, err := k.ToOcr2Node("node-1")
+		require.NoError(t, err)
+		assert.Equal(t, n, got)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := NewOcr2Node("node-1", ccfgs, csaKey, WithEncryptionPublicKey("not hex"))
+		require.Error(t, err)
+		_, err = NewOcr2Node("node-1", ccfgs, csaKey, WithEncryptionPublicKey("fedcba"))
+		require.ErrorContains(t, err, "expected len 32 got 3")
+	})
+}
+
+func Test_newOcr2NodeFromClo(t *testing.T) {
+	var (
+		pubKey           = "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+		evmSig           = "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442"
+		solanaSig        = "111409a8d4f9a18da55c5b2bb08a3f5f68d44777111409a8d4f9a18da55c5b2b"
+		peerID           = "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"
+		registryChainSel = chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+		registryChainID  = strconv.FormatUint(chainsel.ETHEREUM_TESTNET_SEPOLIA.EvmChainID, 10)
+	)
+	n := &models.Node{
+		ID:        "node-1",
+		PublicKey: &pubKey,
+		ChainConfigs: []*models.NodeChainConfig{
+			{
+				ID: "1",
+				Network: &models.Network{
+					ChainType: models.ChainTypeEvm,
+					ChainID:   registryChainID,
+				},
+				AccountAddress: "0x1234",
+				Ocr2Config: &models.NodeOCR2Config{
+					P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+						PeerID: peerID,
+					},
+					OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+						BundleID:              "evmBundle",
+						ConfigPublicKey:       pubKey,
+						OffchainPublicKey:     pubKey,
+						OnchainSigningAddress: evmSig,
+					},
+				},
+			},
+			{
+				ID: "2",
+				Network: &models.Network{
+					ChainType: models.ChainTypeSolana,
+				},
+				Ocr2Config: &models.NodeOCR2Config{
+					P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+						PeerID: peerID,
+					},
+					OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+						BundleID:              "solanaBundle",
+						ConfigPublicKey:       pubKey,
+						OffchainPublicKey:     pubKey,
+						OnchainSigningAddress: solanaSig,
+					},
+				},
+			},
+		},
 	}
-	assetDon := DonCapabilities{
-		Name:         StreamDonName,
-		Nops:         assetNops,
-		Capabilities: []kcr.CapabilitiesRegistryCapability{StreamTriggerCap},
+
+	got, err := newOcr2NodeFromClo(tests.Context(t), n, registryChainSel, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	require.NotNil(t, got.keyBundles[chaintype.Solana])
+	assert.Nil(t, got.keyBundles[chaintype.Aptos])
+
+	keys := got.toNodeKeys()
+	assert.Equal(t, "evmBundle", keys.OCR2BundleID)
+	assert.Equal(t, evmSig, keys.OCR2OnchainPublicKey)
+	assert.Equal(t, "solanaBundle", keys.SolanaBundleID)
+	assert.Equal(t, solanaSig, keys.SolanaOnchainPublicKey)
+	assert.Empty(t, keys.AptosBundleID)
+	assert.Empty(t, keys.AptosOnchainPublicKey)
+}
+
+func TestOcr2NodeFromModel(t *testing.T) {
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	nops := loadTestNops(t, "testdata/workflow_nodes.json")
+	require.NotEmpty(t, nops)
+	require.NotEmpty(t, nops[0].Nodes)
+	n := nops[0].Nodes[0]
+
+	got, err := Ocr2NodeFromModel(tests.Context(t), n, sel)
+	require.NoError(t, err)
+	want, err := newOcr2NodeFromClo(tests.Context(t), n, sel, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	assert.True(t, want.Equal(got))
+	assert.Equal(t, n.ID, got.ID)
+
+	_, err = Ocr2NodeFromModel(tests.Context(t), nil, sel)
+	require.ErrorContains(t, err, "nil node")
+	_, err = Ocr2NodeFromModel(tests.Context(t), n, chainsel.ETHEREUM_MAINNET.Selector)
+	require.ErrorContains(t, err, "failed to convert node "+n.ID)
+	var missing *ErrMissingChainConfig
+	require.ErrorAs(t, err, &missing)
+}
+
+func Test_newOcr2NodeFromClo_starknet(t *testing.T) {
+	var (
+		pubKey      = "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+		starknetSig = "0x4b5a9d1e3f2c9a8b7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4"
+		peerID      = "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"
+	)
+	n := newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+	n.ChainConfigs = append(n.ChainConfigs, &models.NodeChainConfig{
+		ID: "node-1-starknet",
+		Network: &models.Network{
+			ChainType: models.ChainTypeStarknet,
+		},
+		Ocr2Config: &models.NodeOCR2Config{
+			P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+				PeerID: peerID,
+			},
+			OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+				BundleID:              "starknetBundle",
+				ConfigPublicKey:       pubKey,
+				OffchainPublicKey:     pubKey,
+				OnchainSigningAddress: starknetSig,
+			},
+		},
+	})
+
+	got, err := newOcr2NodeFromClo(tests.Context(t), n, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	kb, ok := got.starknetOcr2KeyBundle()
+	require.True(t, ok)
+	assert.Equal(t, "starknetBundle", kb.BundleId)
+
+	keys, err := got.toNodeKeysChecked()
+	require.NoError(t, err)
+	assert.Equal(t, "starknetBundle", keys.StarknetBundleID)
+	assert.Equal(t, starknetSig, keys.StarknetOnchainPublicKey)
+	assert.Empty(t, keys.SolanaBundleID)
+
+	rt, err := keys.ToOcr2Node("node-1")
+	require.NoError(t, err)
+	assert.Equal(t, keys, rt.toNodeKeys())
+}
+
+func Test_newOcr2NodeFromClo_bundleRole(t *testing.T) {
+	peerID := "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"
+	n := newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+	n.ChainConfigs[0].Ocr2Config.OcrKeyBundle.BundleID = "workflow"
+	// a second bundle for the same chain, used by another don
+	second := newTestCloNode("node-1", peerID, "a35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false).ChainConfigs[0]
+	second.ID = "node-1-evm-writer"
+	second.Ocr2Config.OcrKeyBundle.BundleID = "writer"
+	n.ChainConfigs = append(n.ChainConfigs, second)
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+
+	got, err := newOcr2NodeFromClo(tests.Context(t), n, sel, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	assert.Equal(t, "workflow", got.toNodeKeys().OCR2BundleID)
+
+	got, err = newOcr2NodeFromClo(tests.Context(t), n, sel, "writer", DefaultSelectorResolver)
+	require.NoError(t, err)
+	keys := got.toNodeKeys()
+	assert.Equal(t, "writer", keys.OCR2BundleID)
+	assert.Equal(t, "a35409a8d4f9a18da55c5b2bb08a3f5f68d44442", keys.OCR2OnchainPublicKey)
+	assert.Equal(t, common.HexToAddress("0xa35409a8d4f9a18da55c5b2bb08a3f5f68d44442"), got.signerAddress())
+
+	_, err = newOcr2NodeFromClo(tests.Context(t), n, sel, "unknown", DefaultSelectorResolver)
+	require.ErrorContains(t, err, "with bundle role unknown")
+
+	t.Run("WithBundleRole", func(t *testing.T) {
+		got, err := Ocr2NodeFromModel(tests.Context(t), n, sel, WithBundleRole("writer"))
+		require.NoError(t, err)
+		assert.Equal(t, "writer", got.toNodeKeys().OCR2BundleID)
+		_, err = Ocr2NodeFromModel(tests.Context(t), n, sel, WithBundleRole("unknown"))
+		require.ErrorContains(t, err, "with bundle role unknown")
+
+		// the cache keeps a conversion per bundle role
+		dons := []DonCapabilities{{Name: "don", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{n}}}}}
+		cache := NewOcr2NodeCache()
+		for _, role := range []string{"workflow", "writer", defaultBundleRole} {
+			donToNodes, err := mapDonsToNodes(tests.Context(t), dons, true, sel, withOcr2NodeCache(cache), WithBundleRole(role))
+			require.NoError(t, err)
+			require.Len(t, donToNodes["don"], 1)
+			want := role
+			if role == defaultBundleRole {
+				want = "workflow"
+			}
+			assert.Equal(t, want, donToNodes["don"][0].toNodeKeys().OCR2BundleID)
+		}
+	})
+
+	t.Run("nop admin", func(t *testing.T) {
+		// the admin is the one of the chain config of the bundle role, not the last config of the chain
+		n.ChainConfigs[1].AdminAddress = "0x0000000000000000000000000000000000000002"
+		dons := []DonCapabilities{{Name: "don", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{n}}}, Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap}}}
+		for role, want := range map[string]string{defaultBundleRole: "0x01", "workflow": "0x01", "writer": "0x02"} {
+			got, err := nodesToNops(dons, sel, role, DefaultSelectorResolver)
+			require.NoError(t, err)
+			assert.Equal(t, common.HexToAddress(want), got["node-1"].Admin, role)
+		}
+		plan, err := PlanRegistration(tests.Context(t), dons, sel, WithBundleRole("writer"))
+		require.NoError(t, err)
+		assert.Equal(t, []kcr.CapabilitiesRegistryNodeOperator{{Name: "nop", Admin: common.HexToAddress("0x02")}}, plan.Actions[1].Nops)
+		_, err = nodesToNops(dons, sel, "unknown", DefaultSelectorResolver)
+		require.ErrorContains(t, err, "with bundle role unknown")
+	})
+}
+
+func Test_newOcr2NodeFromClo_encryptionKey(t *testing.T) {
+	const (
+		csaKey        = "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+		encryptionKey = "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088"
+	)
+	peerID := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+	n := newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+	other := newTestCloNode("node-2", p2pkey.MustNewV2XXXTestingOnly(big.NewInt(2)).PeerID().String(), "a35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+
+	t.Run("csa key", func(t *testing.T) {
+		got, err := Ocr2NodeFromModel(tests.Context(t), n, sel)
+		require.NoError(t, err)
+		assert.Equal(t, csaKey, got.EncryptionPublicKeyHex())
+		assert.Equal(t, csaKey, got.toNodeKeys().EncryptionPublicKey)
+	})
+
+	t.Run("explicit key", func(t *testing.T) {
+		keys := map[string]string{"node-1": encryptionKey}
+		got, err := Ocr2NodeFromModel(tests.Context(t), n, sel, WithEncryptionPublicKeys(keys))
+		require.NoError(t, err)
+		assert.Equal(t, encryptionKey, got.EncryptionPublicKeyHex())
+		assert.Equal(t, encryptionKey, got.toNodeKeys().EncryptionPublicKey)
+		assert.Equal(t, csaKey, got.toNodeKeys().CSAPublicKey)
+
+		// nodes without an explicit key fall back to their csa key
+		dons := []DonCapabilities{{Name: "don", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{n, other}}}}}
+		donToNodes, err := mapDonsToNodes(tests.Context(t), dons, true, sel, withOcr2NodeCache(NewOcr2NodeCache()), WithEncryptionPublicKeys(keys))
+		require.NoError(t, err)
+		require.Len(t, donToNodes["don"], 2)
+		encryptionKeys := make(map[string]string)
+		for _, node := range donToNodes["don"] {
+			encryptionKeys[node.ID] = node.EncryptionPublicKeyHex()
+		}
+		assert.Equal(t, map[string]string{"node-1": encryptionKey, "node-2": csaKey}, encryptionKeys)
+
+		_, err = Ocr2NodeFromModel(tests.Context(t), n, sel, WithEncryptionPublicKeys(map[string]string{"node-1": "not hex"}))
+		require.ErrorContains(t, err, "failed to decode encryption public key")
+	})
+}
+
+func Test_newOcr2NodeFromClo_aptosPrimary(t *testing.T) {
+	var (
+		aptosSig = "ac364cec9fe7d9ea1035fc511e5b2f30900caa6e65ac0501168005d05129e088"
+		peerID   = "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"
+	)
+	// the node works on aptos; its evm config only has what the registry and the ocr3 config need, the peer id, signer
+	// and config public key
+	newNode := func() *models.Node {
+		n := newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+		n.ChainConfigs[0].Ocr2Config.OcrKeyBundle = &models.NodeOCR2ConfigOCRKeyBundle{
+			OnchainSigningAddress: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+			ConfigPublicKey:       "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1",
+		}
+		// the p2p key is chain agnostic so the aptos config does not repeat it
+		n.ChainConfigs = append(n.ChainConfigs, &models.NodeChainConfig{
+			ID:             "node-1-aptos",
+			Network:        &models.Network{ChainType: models.ChainTypeAptos},
+			AccountAddress: aptosSig,
+			Ocr2Config: &models.NodeOCR2Config{
+				Enabled: true,
+				OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+					BundleID:              "aptosBundle",
+					OnchainSigningAddress: aptosSig,
+				},
+			},
+		})
+		return n
 	}
-	_, err := mapDonsToNodes([]DonCapabilities{wfDon}, false, registryChainSel)
-	require.NoError(t, err, "failed to map wf don")
-	_, err = mapDonsToNodes([]DonCapabilities{cwDon}, false, registryChainSel)
-	require.NoError(t, err, "failed to map cw don")
-	_, err = mapDonsToNodes([]DonCapabilities{assetDon}, false, registryChainSel)
-	require.NoError(t, err, "failed to map asset don")
+
+	got, err := newOcr2NodeFromClo(tests.Context(t), newNode(), chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	assert.Equal(t, "12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv", got.P2PKey.Raw())
+	keys, err := got.toNodeKeysChecked()
+	require.NoError(t, err)
+	assert.Equal(t, "aptosBundle", keys.AptosBundleID)
+	assert.Equal(t, aptosSig, keys.AptosOnchainPublicKey)
+	assert.Equal(t, aptosSig, keys.AptosAccount)
+
+	t.Run("registry config without p2p key bundle", func(t *testing.T) {
+		n := newNode()
+		n.ChainConfigs[0].Ocr2Config.P2pKeyBundle = nil
+		_, err := newOcr2NodeFromClo(tests.Context(t), n, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.ErrorContains(t, err, "evm chain config of node node-1 must have an ocr2 config with p2p and ocr key bundles")
+	})
+
+	t.Run("aptos config without ocr key bundle", func(t *testing.T) {
+		n := newNode()
+		n.ChainConfigs[1].Ocr2Config.OcrKeyBundle = nil
+		_, err := newOcr2NodeFromClo(tests.Context(t), n, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.ErrorContains(t, err, "aptos chain config of node node-1 has no ocr key bundle")
+	})
+
+	t.Run("aptos config without ocr2 config", func(t *testing.T) {
+		n := newNode()
+		n.ChainConfigs[1].Ocr2Config = nil
+		_, err := newOcr2NodeFromClo(tests.Context(t), n, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.ErrorContains(t, err, "chain config node-1-aptos has no ocr2 config")
+		require.ErrorIs(t, err, ErrNoOcr2Config)
+	})
+}
+
+func Test_newOcr2NodeFromClo_bootstrap(t *testing.T) {
+	peerID := "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"
+	n := newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+	got, err := newOcr2NodeFromClo(tests.Context(t), n, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	assert.False(t, got.IsBootstrap())
+
+	// only the aptos config marks the node as a bootstrap
+	n.ChainConfigs = append(n.ChainConfigs, &models.NodeChainConfig{
+		ID: "node-1-aptos",
+		Network: &models.Network{
+			ChainType: models.ChainTypeAptos,
+		},
+		Ocr2Config: &models.NodeOCR2Config{
+			IsBootstrap: true,
+			P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+				PeerID: peerID,
+			},
+			OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{},
+		},
+	})
+	got, err = newOcr2NodeFromClo(tests.Context(t), n, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	assert.True(t, got.IsBootstrap())
+
+	don := DonCapabilities{
+		Name:         "don",
+		Nops:         []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{n}}},
+		Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+	}
+	nodes, err := mapDonsToNodes(tests.Context(t), []DonCapabilities{don}, true, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+	require.NoError(t, err)
+	assert.Empty(t, nodes["don"])
+
+	t.Run("no config public key", func(t *testing.T) {
+		// the config public key is only required of signers, and the node is a bootstrap through its aptos config
+		n.ChainConfigs[0].Ocr2Config.OcrKeyBundle.ConfigPublicKey = ""
+		got, err := newOcr2NodeFromClo(tests.Context(t), n, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.NoError(t, err)
+		assert.True(t, got.IsBootstrap())
+
+		n.ChainConfigs = n.ChainConfigs[:1]
+		_, err = newOcr2NodeFromClo(tests.Context(t), n, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.ErrorContains(t, err, "invalid ocr2 config public key of node node-1")
+	})
+}
+
+func Test_decodeStarknetOnchainPublicKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "full length", key: "04b5a9d1e3f2c9a8b7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4", want: "04b5a9d1e3f2c9a8b7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4"},
+		{name: "prefixed without leading zeros", key: "0x4b5a9d1e3f2c9a8b7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4", want: "04b5a9d1e3f2c9a8b7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4"},
+		{name: "short", key: "0x1", want: "0000000000000000000000000000000000000000000000000000000000000001"},
+		{name: "not hex", key: "0xzz", wantErr: true},
+		{name: "too long", key: "0104b5a9d1e3f2c9a8b7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeStarknetOnchainPublicKey(tt.key)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, hex.EncodeToString(got))
+		})
+	}
+}
+
+func Test_mapDonsToNodes(t *testing.T) {
+	var (
+		pubKey   = "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+		evmSig   = "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442"
+		aptosSig = "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442b35409a8d4f9a18da55c5b2b"
+		peerID   = "p2p_12D3KooWMWUKdoAc2ruZf9f55p7NVFj7AFiPm67xjQ8BZBwkqyYv"
+		// todo: these should be defined in common
+		writerCap        = 3
+		ocr3Cap          = 2
+		registryChainSel = chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+		registryChainID  = strconv.FormatUint(chainsel.ETHEREUM_TESTNET_SEPOLIA.EvmChainID, 10)
+	)
+	type args struct {
+		dons              []DonCapabilities
+		excludeBootstraps bool
+	}
+	ctx := tests.Context(t)
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "writer evm only",
+			args: args{
+				dons: []DonCapabilities{
+					{
+						Name: "ok writer",
+						Nops: []*models.NodeOperator{
+							{
+								Nodes: []*models.Node{
+									{
+										PublicKey: &pubKey,
+										ChainConfigs: []*models.NodeChainConfig{
+											{
+												ID: "1",
+												Network: &models.Network{
+													ChainType: models.ChainTypeEvm,
+													ChainID:   registryChainID,
+												},
+												Ocr2Config: &models.NodeOCR2Config{
+													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+														PeerID: peerID,
+													},
+													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+														ConfigPublicKey:       pubKey,
+														OffchainPublicKey:     pubKey,
+														OnchainSigningAddress: evmSig,
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						Capabilities: []kcr.CapabilitiesRegistryCapability{
+							{
+								LabelledName:   "writer",
+								Version:        "1",
+								CapabilityType: uint8(writerCap),
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "err if no evm chain",
+			args: args{
+				dons: []DonCapabilities{
+					{
+						Name: "bad chain",
+						Nops: []*models.NodeOperator{
+							{
+								Nodes: []*models.Node{
+									{
+										PublicKey: &pubKey,
+										ChainConfigs: []*models.NodeChainConfig{
+											{
+												ID: "1",
+												Network: &models.Network{
+													ChainType: models.ChainTypeSolana,
+												},
+												Ocr2Config: &models.NodeOCR2Config{
+													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+														PeerID: peerID,
+													},
+													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+														ConfigPublicKey:       pubKey,
+														OffchainPublicKey:     pubKey,
+														OnchainSigningAddress: evmSig,
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						Capabilities: []kcr.CapabilitiesRegistryCapability{
+							{
+								LabelledName:   "writer",
+								Version:        "1",
+								CapabilityType: uint8(writerCap),
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ocr3 cap evm only",
+			args: args{
+				dons: []DonCapabilities{
+					{
+						Name: "bad chain",
+						Nops: []*models.NodeOperator{
+							{
+								Nodes: []*models.Node{
+									{
+										PublicKey: &pubKey,
+										ChainConfigs: []*models.NodeChainConfig{
+											{
+												ID: "1",
+												Network: &models.Network{
+													ChainType: models.ChainTypeEvm,
+													ChainID:   registryChainID,
+												},
+												Ocr2Config: &models.NodeOCR2Config{
+													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+														PeerID: peerID,
+													},
+													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+														ConfigPublicKey:       pubKey,
+														OffchainPublicKey:     pubKey,
+														OnchainSigningAddress: evmSig,
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						Capabilities: []kcr.CapabilitiesRegistryCapability{
+							{
+								LabelledName:   "ocr3",
+								Version:        "1",
+								CapabilityType: uint8(ocr3Cap),
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "ocr3 cap evm & aptos",
+			args: args{
+				dons: []DonCapabilities{
+					{
+						Name: "ok chain",
+						Nops: []*models.NodeOperator{
+							{
+								Nodes: []*models.Node{
+									{
+										PublicKey: &pubKey,
+										ChainConfigs: []*models.NodeChainConfig{
+											{
+												ID: "1",
+												Network: &models.Network{
+													ChainType: models.ChainTypeEvm,
+													ChainID:   registryChainID,
+												},
+												Ocr2Config: &models.NodeOCR2Config{
+													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+														PeerID: peerID,
+													},
+													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+														ConfigPublicKey:       pubKey,
+														OffchainPublicKey:     pubKey,
+														OnchainSigningAddress: evmSig,
+													},
+												},
+											},
+											{
+												ID: "2",
+												Network: &models.Network{
+													ChainType: models.ChainTypeAptos,
+												},
+												Ocr2Config: &models.NodeOCR2Config{
+													P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+														PeerID: peerID,
+													},
+													OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+														ConfigPublicKey:       pubKey,
+														OffchainPublicKey:     pubKey,
+														OnchainSigningAddress: aptosSig,
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						Capabilities: []kcr.CapabilitiesRegistryCapability{
+							{
+								LabelledName:   "ocr3",
+								Version:        "1",
+								CapabilityType: uint8(ocr3Cap),
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mapDonsToNodes(ctx, tt.args.dons, tt.args.excludeBootstraps, registryChainSel)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("mapDonsToNodes(ctx, ) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+		})
+	}
+	// make sure the clo test data is correct
+	wfNops := loadTestNops(t, "testdata/workflow_nodes.json")
+	cwNops := loadTestNops(t, "testdata/chain_writer_nodes.json")
+	assetNops := loadTestNops(t, "testdata/asset_nodes.json")
+	require.Len(t, wfNops, 10)
+	require.Len(t, cwNops, 10)
+	require.Len(t, assetNops, 16)
+
+	wfDon := DonCapabilities{
+		Name:         WFDonName,
+		Nops:         wfNops,
+		Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+	}
+	cwDon := DonCapabilities{
+		Name:         TargetDonName,
+		Nops:         cwNops,
+		Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap},
+	}
+	assetDon := DonCapabilities{
+		Name:         StreamDonName,
+		Nops:         assetNops,
+		Capabilities: []kcr.CapabilitiesRegistryCapability{StreamTriggerCap},
+	}
+	_, err := mapDonsToNodes(ctx, []DonCapabilities{wfDon}, false, registryChainSel)
+	require.NoError(t, err, "failed to map wf don")
+	_, err = mapDonsToNodes(ctx, []DonCapabilities{cwDon}, false, registryChainSel)
+	require.NoError(t, err, "failed to map cw don")
+	_, err = mapDonsToNodes(ctx, []DonCapabilities{assetDon}, false, registryChainSel)
+	require.NoError(t, err, "failed to map asset don")
+}
+
+func Test_mapDonsToNodes_duplicates(t *testing.T) {
+	var (
+		peerID1 = p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+		peerID2 = p2pkey.MustNewV2XXXTestingOnly(big.NewInt(2)).PeerID().String()
+		signer1 = "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442"
+		signer2 = "111409a8d4f9a18da55c5b2bb08a3f5f68d44777"
+	)
+	ctx := tests.Context(t)
+	tests := []struct {
+		name    string
+		nodes   []*models.Node
+		wantErr string
+	}{
+		{
+			name: "unique",
+			nodes: []*models.Node{
+				newTestCloNode("node-1", peerID1, signer1, false),
+				newTestCloNode("node-2", peerID2, signer2, false),
+			},
+		},
+		{
+			name: "duplicate peer id",
+			nodes: []*models.Node{
+				newTestCloNode("node-1", peerID1, signer1, false),
+				newTestCloNode("node-2", peerID1, signer2, false),
+			},
+			wantErr: "duplicate p2p peer id " + peerID1 + " in don test don: nodes node-1 and node-2",
+		},
+		{
+			name: "duplicate signer",
+			nodes: []*models.Node{
+				newTestCloNode("node-1", peerID1, signer1, false),
+				newTestCloNode("node-2", peerID2, signer1, false),
+			},
+			wantErr: "duplicate signer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			don := DonCapabilities{
+				Name:         "test don",
+				Nops:         []*models.NodeOperator{{Name: "nop", Nodes: tt.nodes}},
+				Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+			}
+			_, err := mapDonsToNodes(ctx, []DonCapabilities{don}, false, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Contains(t, err.Error(), "node-1")
+			assert.Contains(t, err.Error(), "node-2")
+		})
+	}
+}
+
+func Test_validateDon_signerAddress(t *testing.T) {
+	var nodes []*Ocr2Node
+	for i := 1; i <= 2; i++ {
+		n := &Ocr2Node{
+			ID:     fmt.Sprintf("node-%d", i),
+			P2PKey: p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID(),
+		}
+		copy(n.Signer[:], common.HexToAddress("0xb35409a8d4f9a18da55c5b2bb08a3f5f68d44442").Bytes())
+		nodes = append(nodes, n)
+	}
+	// the signers differ only in the bytes after the evm address
+	nodes[1].Signer[31] = 0xff
+
+	err := validateDon("test don", nodes)
+	require.ErrorContains(t, err, "duplicate signer address "+nodes[0].signerAddress().Hex()+" in don test don: nodes node-1 and node-2")
+
+	nodes[1].Signer[0] = 0x11
+	require.NoError(t, validateDon("test don", nodes))
+}
+
+func Test_mapDonsToNodes_concurrent(t *testing.T) {
+	dons := newTestTopology(10, 20)
+
+	t.Run("order", func(t *testing.T) {
+		got, err := mapDonsToNodes(tests.Context(t), dons, false, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+		require.NoError(t, err)
+		require.Len(t, got, len(dons))
+		for _, don := range dons {
+			var want []string
+			for _, nop := range don.Nops {
+				for _, n := range nop.Nodes {
+					want = append(want, n.ID)
+				}
+			}
+			var gotIDs, gotPeerIDs []string
+			for _, n := range got[DonName(don.Name)] {
+				gotIDs = append(gotIDs, n.ID)
+				gotPeerIDs = append(gotPeerIDs, n.P2PKey.String())
+			}
+			assert.ElementsMatch(t, want, gotIDs, "don %s", don.Name)
+			assert.True(t, slices.IsSorted(gotPeerIDs), "don %s nodes are not ordered by peer id", don.Name)
+		}
+	})
+
+	t.Run("stable", func(t *testing.T) {
+		want, err := mapDonsToNodes(tests.Context(t), dons, false, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+		require.NoError(t, err)
+		// the same nodes in a different nop and node order
+		shuffled := newTestTopology(10, 20)
+		for _, don := range shuffled {
+			slices.Reverse(don.Nops)
+			for _, nop := range don.Nops {
+				slices.Reverse(nop.Nodes)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			got, err := mapDonsToNodes(tests.Context(t), shuffled, false, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+			require.NoError(t, err)
+			require.Len(t, got, len(want))
+			for donName, nodes := range want {
+				require.Len(t, got[donName], len(nodes))
+				for j := range nodes {
+					assert.True(t, nodes[j].Equal(got[donName][j]), "don %s node %d", donName, j)
+				}
+			}
+		}
+	})
+
+	t.Run("first error", func(t *testing.T) {
+		// break a node late in the topology before an earlier one so that a bad ordering is likely to be caught
+		malformed := newTestTopology(10, 20)
+		malformed[8].Nops[0].Nodes[3].PublicKey = nil
+		malformed[2].Nops[1].Nodes[0].PublicKey = nil
+		wantID := malformed[2].Nops[1].Nodes[0].ID
+		for i := 0; i < 10; i++ {
+			_, err := mapDonsToNodes(tests.Context(t), malformed, false, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+			require.Error(t, err)
+			assert.Equal(t, "failed to create ocr2 node for node "+wantID+": no public key", err.Error())
+		}
+	})
+}
+
+func Test_mapDonsToNodes_cancel(t *testing.T) {
+	dons := newTestTopology(25, 20) // 500 nodes
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	ctx, cancel := context.WithCancel(tests.Context(t))
+	defer cancel()
+
+	// cancel part way through the conversion
+	var decodes atomic.Int32
+	cache := NewOcr2NodeCache()
+	cache.decode = func(ctx context.Context, n *models.Node, registryChainSel uint64, o mapDonsToNodesOpts) (*Ocr2Node, error) {
+		if decodes.Add(1) == 50 {
+			cancel()
+		}
+		return o.newOcr2Node(ctx, n, registryChainSel)
+	}
+	_, err := mapDonsToNodes(ctx, dons, true, sel, withOcr2NodeCache(cache))
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, decodes.Load(), int32(500))
+
+	// the cancellation is not cached
+	got, err := mapDonsToNodes(tests.Context(t), dons, true, sel, withOcr2NodeCache(cache))
+	require.NoError(t, err)
+	assert.Len(t, got, 25)
+
+	_, err = newOcr2NodeFromClo(ctx, dons[0].Nops[0].Nodes[0], sel, defaultBundleRole, DefaultSelectorResolver)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestStreamDonNodes(t *testing.T) {
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+
+	t.Run("all nodes once", func(t *testing.T) {
+		dons := newTestTopology(5, 10)
+		dons[3].Nops[1].Nodes[2].PublicKey = nil
+		badID := dons[3].Nops[1].Nodes[2].ID
+		results, err := StreamDonNodes(tests.Context(t), dons, sel)
+		require.NoError(t, err)
+
+		seen := make(map[DonNode]int)
+		for r := range results {
+			if r.Err != nil {
+				assert.Equal(t, "failed to create ocr2 node for node "+badID+": no public key", r.Err.Error())
+				assert.Nil(t, r.Node)
+				seen[DonNode{Don: r.Don, Node: badID}]++
+				continue
+			}
+			seen[DonNode{Don: r.Don, Node: r.Node.ID}]++
+		}
+		require.Len(t, seen, 50)
+		for _, don := range dons {
+			for _, nop := range don.Nops {
+				for _, n := range nop.Nodes {
+					assert.Equal(t, 1, seen[DonNode{Don: don.Name, Node: n.ID}], "don %s node %s", don.Name, n.ID)
+				}
+			}
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(tests.Context(t))
+		defer cancel()
+		results, err := StreamDonNodes(ctx, newTestTopology(5, 10), sel)
+		require.NoError(t, err)
+		<-results
+		cancel()
+		n := 0
+		for range results {
+			n++
+		}
+		// at most the result that was being sent when ctx was cancelled
+		assert.LessOrEqual(t, n, 1)
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		_, err := StreamDonNodes(tests.Context(t), nil, 1)
+		require.Error(t, err)
+	})
+}
+
+func Test_mapDonsToNodes_excludeNodeIDs(t *testing.T) {
+	dons := newTestTopology(2, 4)
+	excluded := dons[0].Nops[0].Nodes[1]
+	// a nop whose only node is excluded
+	lone := newTestCloNode("lone", p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1000)).PeerID().String(), fmt.Sprintf("%040x", 1000), false)
+	// excluded nodes are not converted, so a broken config does not matter
+	lone.PublicKey = nil
+	dons[1].Nops = append(dons[1].Nops, &models.NodeOperator{Name: "lone nop", Nodes: []*models.Node{lone}})
+
+	_, err := mapDonsToNodes(tests.Context(t), dons, false, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+	require.Error(t, err)
+
+	got, err := mapDonsToNodes(tests.Context(t), dons, false, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector,
+		withExcludedNodeIDs(map[string]bool{excluded.ID: true, lone.ID: true}))
+	require.NoError(t, err)
+	require.Len(t, got["don-0"], 3)
+	require.Len(t, got["don-1"], 4)
+	for _, nodes := range got {
+		for _, n := range nodes {
+			assert.NotEqual(t, excluded.ID, n.ID)
+			assert.NotEqual(t, lone.ID, n.ID)
+		}
+	}
+}
+
+func Test_mapDonsToNodes_skipNodesWithoutOcr2Config(t *testing.T) {
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	dons := newTestTopology(2, 4)
+	missing := dons[0].Nops[0].Nodes[1]
+	missing.ChainConfigs[0].Ocr2Config = nil
+
+	_, err := mapDonsToNodes(tests.Context(t), dons, false, sel)
+	require.ErrorIs(t, err, ErrNoOcr2Config)
+	require.ErrorContains(t, err, "failed to create ocr2 node for node "+missing.ID)
+
+	var skipped []string
+	got, err := mapDonsToNodes(tests.Context(t), dons, false, sel, withSkippedNodesWithoutOcr2Config(&skipped))
+	require.NoError(t, err)
+	assert.Equal(t, []string{missing.ID}, skipped)
+	require.Len(t, got["don-0"], 3)
+	require.Len(t, got["don-1"], 4)
+	for _, n := range got["don-0"] {
+		assert.NotEqual(t, missing.ID, n.ID)
+	}
+
+	t.Run("other errors are not skipped", func(t *testing.T) {
+		dons := newTestTopology(1, 4)
+		dons[0].Nops[0].Nodes[0].PublicKey = nil
+		var skipped []string
+		_, err := mapDonsToNodes(tests.Context(t), dons, false, sel, withSkippedNodesWithoutOcr2Config(&skipped))
+		require.ErrorContains(t, err, "no public key")
+		assert.Empty(t, skipped)
+	})
+}
+
+func TestMapEnvironments(t *testing.T) {
+	registryChainSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	newDon := func(env string, bootstrap int) DonCapabilities {
+		var nodes []*models.Node
+		for i := 1; i <= 4; i++ {
+			p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(len(env)*10 + i))).PeerID()
+			nodes = append(nodes, newTestCloNode(fmt.Sprintf("%s-node-%d", env, i), p.String(), fmt.Sprintf("%040x", len(env)*10+i), i == bootstrap))
+		}
+		return DonCapabilities{
+			Name:         "workflow",
+			Nops:         []*models.NodeOperator{{Name: "nop", Nodes: nodes}},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		}
+	}
+	envs := map[string][]DonCapabilities{
+		"dev":     {newDon("dev", 0)},
+		"staging": {newDon("staging", 4)},
+	}
+
+	got, err := MapEnvironments(tests.Context(t), envs, registryChainSel)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Len(t, got["dev"]["workflow"], 4)
+	require.Len(t, got["staging"]["workflow"], 3, "bootstraps are excluded")
+	for env, dons := range got {
+		for _, n := range dons["workflow"] {
+			assert.True(t, strings.HasPrefix(n.ID, env+"-node-"), "node %s of environment %s", n.ID, env)
+		}
+	}
+
+	envs["prod"] = []DonCapabilities{newDon("prod", 0)}
+	envs["prod"][0].Nops[0].Nodes[0].PublicKey = nil
+	_, err = MapEnvironments(tests.Context(t), envs, registryChainSel)
+	require.ErrorContains(t, err, "failed to map dons of environment prod")
+}
+
+func TestOcr2NodeCache(t *testing.T) {
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	node := newTestCloNode("node-1", p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String(), "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+
+	cache := NewOcr2NodeCache()
+	var decodes atomic.Int32
+	cache.decode = func(_ context.Context, n *models.Node, registryChainSel uint64, o mapDonsToNodesOpts) (*Ocr2Node, error) {
+		decodes.Add(1)
+		return o.newOcr2Node(tests.Context(t), n, registryChainSel)
+	}
+
+	first, err := cache.Get(tests.Context(t), node, sel)
+	require.NoError(t, err)
+	second, err := cache.Get(tests.Context(t), node, sel)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, int32(1), decodes.Load())
+
+	t.Run("shared across dons", func(t *testing.T) {
+		// the same node in two dons, converted concurrently
+		dons := []DonCapabilities{
+			{Name: "don-1", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{node}}}},
+			{Name: "don-2", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{node}}}},
+		}
+		got, err := mapDonsToNodes(tests.Context(t), dons, false, sel, withOcr2NodeCache(cache))
+		require.NoError(t, err)
+		assert.Same(t, first, got["don-1"][0])
+		assert.Same(t, first, got["don-2"][0])
+		assert.Equal(t, int32(1), decodes.Load())
+	})
+
+	t.Run("errors are cached", func(t *testing.T) {
+		bad := newTestCloNode("bad", "p2p_bad", "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+		_, err := cache.Get(tests.Context(t), bad, sel)
+		require.Error(t, err)
+		_, err2 := cache.Get(tests.Context(t), bad, sel)
+		assert.Equal(t, err, err2)
+		assert.Equal(t, int32(2), decodes.Load())
+	})
+}
+
+func Benchmark_mapDonsToNodes(b *testing.B) {
+	dons := newTestTopology(25, 20) // 500 nodes
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mapDonsToNodes(context.Background(), dons, true, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_chainConfigFromClo(t *testing.T) {
+	multiaddr := "/ip4/127.0.0.1/tcp/6690"
+	newChainConfig := func(ct models.ChainType) *models.NodeChainConfig {
+		return &models.NodeChainConfig{
+			ID:      "cc-1",
+			Network: &models.Network{ChainType: ct, ChainID: "1"},
+			Ocr2Config: &models.NodeOCR2Config{
+				IsBootstrap:  true,
+				Multiaddr:    &multiaddr,
+				P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{},
+				OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{},
+			},
+		}
+	}
+	tests := []struct {
+		name    string
+		ct      models.ChainType
+		want    v1.ChainType
+		wantErr bool
+	}{
+		{name: "evm", ct: models.ChainTypeEvm, want: v1.ChainType_CHAIN_TYPE_EVM},
+		{name: "aptos", ct: models.ChainTypeAptos, want: v1.ChainType_CHAIN_TYPE_APTOS},
+		{name: "solana", ct: models.ChainTypeSolana, want: v1.ChainType_CHAIN_TYPE_SOLANA},
+		{name: "starknet", ct: models.ChainTypeStarknet, want: v1.ChainType_CHAIN_TYPE_STARKNET},
+		{name: "unknown", ct: models.ChainType("COSMOS"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chainConfigFromClo(newChainConfig(tt.ct))
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "unsupported chain type 'COSMOS'")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Chain.Type)
+			assert.Equal(t, "1", got.Chain.Id)
+			assert.True(t, got.Ocr2Config.IsBootstrap)
+			assert.Equal(t, "/ip4/127.0.0.1/tcp/6690", got.Ocr2Config.Multiaddr)
+		})
+	}
+}
+
+func Test_assertEVMSelector(t *testing.T) {
+	require.NoError(t, assertEVMSelector(chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector))
+
+	const solanaMainnet = 124615329519749607
+	err := assertEVMSelector(solanaMainnet)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "selector 124615329519749607 is not an EVM chain")
+
+	// the callers fail fast with the same error
+	_, err = registryChainConfig("node-1", nil, chaintype.EVM, solanaMainnet, defaultBundleRole, DefaultSelectorResolver)
+	assert.ErrorContains(t, err, "is not an EVM chain")
+	_, err = DonCapabilities{Name: "don"}.nodeIdToNop(solanaMainnet, defaultBundleRole, DefaultSelectorResolver)
+	assert.ErrorContains(t, err, "is not an EVM chain")
+	_, err = joinInfoAndNodes(tests.Context(t), nil, nil, solanaMainnet)
+	assert.ErrorContains(t, err, "is not an EVM chain")
+}
+
+func TestErrMissingChainConfig(t *testing.T) {
+	var (
+		registryChainSel = chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+		otherChainSel    = chainsel.ETHEREUM_TESTNET_SEPOLIA_BASE_1.Selector
+		peerID           = p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+		n                = newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+	)
+
+	t.Run("registryChainConfig", func(t *testing.T) {
+		_, err := registryChainConfig(n.ID, n.ChainConfigs, chaintype.EVM, otherChainSel, defaultBundleRole, DefaultSelectorResolver)
+		var target *ErrMissingChainConfig
+		require.True(t, errors.As(err, &target))
+		assert.Equal(t, "node-1", target.NodeID)
+		assert.Equal(t, otherChainSel, target.ChainSelector)
+		assert.Equal(t, chaintype.EVM, target.ChainType)
+	})
+
+	t.Run("newOcr2NodeFromClo", func(t *testing.T) {
+		_, err := newOcr2NodeFromClo(tests.Context(t), n, otherChainSel, defaultBundleRole, DefaultSelectorResolver)
+		var target *ErrMissingChainConfig
+		require.True(t, errors.As(err, &target))
+		assert.Equal(t, "node-1", target.NodeID)
+		assert.Equal(t, otherChainSel, target.ChainSelector)
+	})
+
+	t.Run("nodeIdToNop", func(t *testing.T) {
+		don := DonCapabilities{
+			Name: "don",
+			Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{n}}},
+		}
+		_, err := don.nodeIdToNop(otherChainSel, defaultBundleRole, DefaultSelectorResolver)
+		var target *ErrMissingChainConfig
+		require.True(t, errors.As(err, &target))
+		assert.Equal(t, "node-1", target.NodeID)
+		assert.Equal(t, otherChainSel, target.ChainSelector)
+		assert.Equal(t, chaintype.EVM, target.ChainType)
+
+		_, err = don.nodeIdToNop(registryChainSel, defaultBundleRole, DefaultSelectorResolver)
+		require.NoError(t, err)
+	})
+}
+
+func TestCustomSelectorResolver(t *testing.T) {
+	// a private network selector that is unknown to chainsel, for the chain id of the test nodes
+	const privateSel = 1234567890
+	var (
+		peerID   = p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+		n        = newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+		don      = DonCapabilities{Name: "don", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{n}}}}
+		resolver = CustomSelectorResolver{privateSel: chainsel.ETHEREUM_TESTNET_SEPOLIA.EvmChainID}
+	)
+
+	_, err := registryChainConfig(n.ID, n.ChainConfigs, chaintype.EVM, privateSel, defaultBundleRole, DefaultSelectorResolver)
+	require.ErrorContains(t, err, "is not an EVM chain")
+	_, err = don.nodeIdToNop(privateSel, defaultBundleRole, DefaultSelectorResolver)
+	require.ErrorContains(t, err, "is not an EVM chain")
+
+	cc, err := registryChainConfig(n.ID, n.ChainConfigs, chaintype.EVM, privateSel, defaultBundleRole, resolver)
+	require.NoError(t, err)
+	assert.NotNil(t, cc)
+	nops, err := don.nodeIdToNop(privateSel, defaultBundleRole, resolver)
+	require.NoError(t, err)
+	assert.Equal(t, kcr.CapabilitiesRegistryNodeOperator{Name: "nop", Admin: common.HexToAddress("0x01")}, nops["node-1"])
+
+	// known selectors fall back to chainsel
+	cid, err := resolver.EVMChainID(chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+	require.NoError(t, err)
+	assert.Equal(t, chainsel.ETHEREUM_TESTNET_SEPOLIA.EvmChainID, cid)
+	_, err = resolver.EVMChainID(124615329519749607) // solana mainnet
+	require.ErrorContains(t, err, "is not an EVM chain")
+}
+
+func TestWithSelectorResolver(t *testing.T) {
+	// a private network selector that is unknown to chainsel, for the chain id of the test nodes
+	const privateSel = 1234567890
+	var (
+		peerID   = p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+		n        = newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+		resolver = CustomSelectorResolver{privateSel: chainsel.ETHEREUM_TESTNET_SEPOLIA.EvmChainID}
+	)
+	// the zero admin of a test net nop is substituted
+	n.ChainConfigs[0].AdminAddress = "0x0000000000000000000000000000000000000000"
+	dons := []DonCapabilities{{
+		Name:         "don",
+		Nops:         []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{n}}},
+		Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+	}}
+
+	_, err := PlanRegistration(tests.Context(t), dons, privateSel)
+	require.ErrorContains(t, err, "is not an EVM chain")
+	require.ErrorContains(t, ValidateDonRegistryChain(dons[0], privateSel), "is not an EVM chain")
+
+	plan, err := PlanRegistration(tests.Context(t), dons, privateSel, WithSelectorResolver(resolver))
+	require.NoError(t, err)
+	require.Len(t, plan.Actions, 4)
+	assert.Equal(t, []kcr.CapabilitiesRegistryNodeOperator{
+		{Name: "nop", Admin: common.HexToAddress("0xffffffffffffffffffffffffffffffffffffffff")},
+	}, plan.Actions[1].Nops)
+	require.NoError(t, ValidateDonRegistryChain(dons[0], privateSel, WithSelectorResolver(resolver)))
+
+	ch, err := StreamDonNodes(tests.Context(t), dons, privateSel, WithSelectorResolver(resolver))
+	require.NoError(t, err)
+	var results []DonNodeResult
+	for r := range ch {
+		results = append(results, r)
+	}
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, "node-1", results[0].Node.ID)
+
+	t.Run("mainnets", func(t *testing.T) {
+		assert.True(t, resolver.IsMainnet(chainsel.ETHEREUM_MAINNET.Selector))
+		assert.False(t, resolver.IsMainnet(privateSel))
+		assert.False(t, resolver.IsMainnet(chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector))
+	})
+}
+
+func TestValidateDonRegistryChain(t *testing.T) {
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	dons := newTestTopology(1, 4)
+	don := dons[0]
+	require.NoError(t, ValidateDonRegistryChain(don, sel))
+
+	// one node is on another chain and the other has an aptos config only
+	other := don.Nops[0].Nodes[1]
+	other.ChainConfigs[0].Network.ChainID = strconv.FormatUint(chainsel.ETHEREUM_TESTNET_SEPOLIA_ARBITRUM_1.EvmChainID, 10)
+	aptosOnly := don.Nops[1].Nodes[0]
+	aptosOnly.ChainConfigs[0].Network.ChainType = models.ChainTypeAptos
+
+	err := ValidateDonRegistryChain(don, sel)
+	require.Error(t, err)
+	var missing *ErrMissingChainConfig
+	require.ErrorAs(t, err, &missing)
+	assert.Contains(t, err.Error(), "node '"+other.Name+"' of nop '"+don.Nops[0].Name+"' in don "+don.Name)
+	assert.Contains(t, err.Error(), "node '"+aptosOnly.Name+"' of nop '"+don.Nops[1].Name+"' in don "+don.Name)
+	joined, ok := err.(interface{ Unwrap() []error })
+	require.True(t, ok)
+	assert.Len(t, joined.Unwrap(), 2)
+
+	// the conversion only reports the first node
+	_, err = mapDonsToNodes(tests.Context(t), dons, false, sel)
+	require.ErrorContains(t, err, other.ID)
+	assert.NotContains(t, err.Error(), aptosOnly.ID)
+
+	const solanaMainnet = 124615329519749607
+	require.Error(t, ValidateDonRegistryChain(don, solanaMainnet), "the registry chain must be an evm chain")
+}
+
+func Test_normalizeChainIDString(t *testing.T) {
+	for _, in := range []string{"1", "0x1", "0X01", " 1 ", "\t0x1\n"} {
+		got, err := normalizeChainIDString(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, "1", got, in)
+		assert.True(t, chainIDMatches(in, "1"), in)
+	}
+	for _, in := range []string{"", "0x", "one", "-1", "0xzz"} {
+		_, err := normalizeChainIDString(in)
+		require.Error(t, err, in)
+		assert.False(t, chainIDMatches(in, "1"), in)
+	}
+
+	t.Run("nops", func(t *testing.T) {
+		sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID()
+		node := newTestCloNode("node-1", p.String(), fmt.Sprintf("%040x", 1), false)
+		node.ChainConfigs[0].Network.ChainID = fmt.Sprintf(" 0x%x ", chainsel.ETHEREUM_TESTNET_SEPOLIA.EvmChainID)
+		don := DonCapabilities{Name: "don", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{node}}}}
+		nops, err := don.nodeIdToNop(sel, defaultBundleRole, DefaultSelectorResolver)
+		require.NoError(t, err)
+		assert.Equal(t, "nop", nops["node-1"].Name)
+
+		_, err = newOcr2NodeFromClo(tests.Context(t), node, sel, defaultBundleRole, DefaultSelectorResolver)
+		require.NoError(t, err)
+	})
+}
+
+func Test_adminAddr(t *testing.T) {
+	_, err := adminAddr(emptyAddr, AdminAddrOption{})
+	require.ErrorIs(t, err, ErrZeroAdminAddress)
+
+	got, err := adminAddr(emptyAddr, AdminAddrOption{AllowZeroSubstitution: true})
+	require.NoError(t, err)
+	assert.Equal(t, common.HexToAddress("0xffffffffffffffffffffffffffffffffffffffff"), got)
+
+	got, err = adminAddr("0x0000000000000000000000000000000000000001", AdminAddrOption{})
+	require.NoError(t, err)
+	assert.Equal(t, common.HexToAddress("0x01"), got)
+
+	// the zero address with an upper case prefix is the zero address too
+	_, err = adminAddr("0X0000000000000000000000000000000000000000", AdminAddrOption{})
+	require.ErrorIs(t, err, ErrZeroAdminAddress)
+	got, err = adminAddr("0X0000000000000000000000000000000000000001", AdminAddrOption{})
+	require.NoError(t, err)
+	assert.Equal(t, common.HexToAddress("0x01"), got)
+
+	t.Run("nops", func(t *testing.T) {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID()
+		node := newTestCloNode("node-1", p.String(), fmt.Sprintf("%040x", 1), false)
+		node.ChainConfigs[0].AdminAddress = emptyAddr
+		don := DonCapabilities{Name: "don", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{node}}}}
+
+		nops, err := don.nodeIdToNop(chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.NoError(t, err)
+		assert.Equal(t, common.HexToAddress("0xffffffffffffffffffffffffffffffffffffffff"), nops["node-1"].Admin)
+
+		node.ChainConfigs[0].Network.ChainID = strconv.FormatUint(chainsel.ETHEREUM_MAINNET.EvmChainID, 10)
+		_, err = don.nodeIdToNop(chainsel.ETHEREUM_MAINNET.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.ErrorIs(t, err, ErrZeroAdminAddress)
+	})
+}
+
+func TestValidateAdminAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr string
+	}{
+		{name: "lower case", addr: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"},
+		{name: "upper case", addr: "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED"},
+		{name: "checksummed", addr: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{name: "no prefix", addr: "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{name: "upper case prefix", addr: "0X5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{name: "zero address", addr: emptyAddr},
+		{name: "empty", addr: "", wantErr: "expected 40 hex characters got 0"},
+		{name: "too short", addr: "0x1234", wantErr: "expected 40 hex characters got 4"},
+		{name: "too long", addr: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed00", wantErr: "expected 40 hex characters got 42"},
+		{name: "not hex", addr: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beazz", wantErr: "not hex"},
+		{name: "bad checksum", addr: "0x5aaeb6053F3E94C9b9A09f33669435E7Ef1BeAed", wantErr: "invalid checksum, expected 0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAdminAddress(tt.addr)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidAdminAddress)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("nops", func(t *testing.T) {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID()
+		node := newTestCloNode("node-1", p.String(), fmt.Sprintf("%040x", 1), false)
+		node.ChainConfigs[0].AdminAddress = "0x1234"
+		don := DonCapabilities{Name: "don", Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{node}}}}
+		_, err := don.nodeIdToNop(chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.ErrorIs(t, err, ErrInvalidAdminAddress)
+		require.ErrorContains(t, err, "invalid admin address of node 'node-1'")
+	})
+}
+
+func TestDonCapabilities_nodeIdToNop_nopAdmins(t *testing.T) {
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	newNode := func(i int, admin string) *models.Node {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		n := newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), false)
+		n.ChainConfigs[0].AdminAddress = admin
+		return n
+	}
+	don := DonCapabilities{
+		Name: "don",
+		Nops: []*models.NodeOperator{
+			{Name: "nop 1", Nodes: []*models.Node{newNode(1, ""), newNode(2, "0x0000000000000000000000000000000000000003")}},
+		},
+		NopAdmins: map[string]string{"nop 1": "0x0000000000000000000000000000000000000002"},
+	}
+
+	nops, err := don.nodeIdToNop(sel, defaultBundleRole, DefaultSelectorResolver)
+	require.NoError(t, err)
+	assert.Equal(t, common.HexToAddress("0x02"), nops["node-1"].Admin, "the nop admin is used when the chain admin is empty")
+	assert.Equal(t, common.HexToAddress("0x03"), nops["node-2"].Admin, "the chain admin takes precedence")
+
+	b, err := json.Marshal(don)
+	require.NoError(t, err)
+	var got DonCapabilities
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, don.NopAdmins, got.NopAdmins)
+}
+
+func Test_adminAddrForChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		ct      chaintype.ChainType
+		opt     AdminAddrOption
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "evm",
+			addr: "0xb35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+			ct:   chaintype.EVM,
+			want: "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+		},
+		{
+			name: "evm zero address is substituted",
+			addr: "0x0000000000000000000000000000000000000000",
+			ct:   chaintype.EVM,
+			opt:  AdminAddrOption{AllowZeroSubstitution: true},
+			want: "ffffffffffffffffffffffffffffffffffffffff",
+		},
+		{
+			name:    "evm zero address is rejected by default",
+			addr:    "0x0000000000000000000000000000000000000000",
+			ct:      chaintype.EVM,
+			wantErr: true,
+		},
+		{
+			name:    "evm wrong length",
+			addr:    "0xb35409a8d4f9a18da55c5b2bb08a3f5f68d4444200",
+			ct:      chaintype.EVM,
+			wantErr: true,
+		},
+		{
+			name: "aptos",
+			addr: "0x111409a8d4f9a18da55c5b2bb08a3f5f68d44777111409a8d4f9a18da55c5b2b",
+			ct:   chaintype.Aptos,
+			want: "111409a8d4f9a18da55c5b2bb08a3f5f68d44777111409a8d4f9a18da55c5b2b",
+		},
+		{
+			name:    "aptos truncated",
+			addr:    "0xb35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+			ct:      chaintype.Aptos,
+			wantErr: true,
+		},
+		{
+			name:    "not hex",
+			addr:    "0xnothex",
+			ct:      chaintype.Aptos,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported chain type",
+			addr:    "0xb35409a8d4f9a18da55c5b2bb08a3f5f68d44442",
+			ct:      chaintype.Cosmos,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := adminAddrForChain(tt.addr, tt.ct, tt.opt)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, hex.EncodeToString(got))
+		})
+	}
+}
+
+func TestDonCapabilities_JSON(t *testing.T) {
+	var (
+		peerID1 = p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+		peerID2 = p2pkey.MustNewV2XXXTestingOnly(big.NewInt(2)).PeerID().String()
+	)
+	don := DonCapabilities{
+		Name: "test don",
+		Nops: []*models.NodeOperator{
+			{
+				ID:    "nop-1",
+				Name:  "nop 1",
+				Nodes: []*models.Node{newTestCloNode("node-1", peerID1, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)},
+			},
+			{
+				ID:    "nop-2",
+				Name:  "nop 2",
+				Nodes: []*models.Node{newTestCloNode("node-2", peerID2, "111409a8d4f9a18da55c5b2bb08a3f5f68d44777", true)},
+			},
+		},
+		Capabilities: []kcr.CapabilitiesRegistryCapability{
+			OCR3Cap,
+			{
+				LabelledName:          "write_ethereum-testnet-sepolia",
+				Version:               "1.0.0",
+				CapabilityType:        3,
+				ResponseType:          1,
+				ConfigurationContract: common.HexToAddress("0xb35409a8d4f9a18da55c5b2bb08a3f5f68d44442"),
+			},
+		},
+		NodeCapabilities: map[string][]kcr.CapabilitiesRegistryCapability{
+			"node-1": {OCR3Cap, StreamTriggerCap},
+		},
+		CapabilityConfigs: map[[32]byte][]byte{
+			HashedCapabilityIDOf(OCR3Cap): []byte("config"),
+		},
+		HeterogeneousCapabilities: true,
+	}
+
+	b, err := json.Marshal(don)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"capabilityType":"consensus"`)
+	assert.Contains(t, string(b), `"capabilityType":"target"`)
+	assert.Contains(t, string(b), `"responseType":"observation_identical"`)
+	ocr3ID := HashedCapabilityID(OCR3Cap.LabelledName, OCR3Cap.Version)
+	assert.Contains(t, string(b), `"id":"0x`+hex.EncodeToString(ocr3ID[:])+`"`)
+	assert.Contains(t, string(b), `"nodeCapabilities":{"node-1":[`)
+	assert.Contains(t, string(b), `"heterogeneousCapabilities":true`)
+
+	var got DonCapabilities
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, don, got)
+
+	t.Run("unknown capability type", func(t *testing.T) {
+		bad := DonCapabilities{
+			Name: "bad",
+			Capabilities: []kcr.CapabilitiesRegistryCapability{
+				{LabelledName: "bad", Version: "1.0.0", CapabilityType: 7},
+			},
+		}
+		_, err := json.Marshal(bad)
+		require.Error(t, err)
+	})
+
+	t.Run("mismatched id", func(t *testing.T) {
+		in := `{"name":"bad","capabilities":[{"id":"0x01","labelledName":"bad","version":"1.0.0","capabilityType":"trigger","responseType":"report"}]}`
+		var got DonCapabilities
+		require.Error(t, json.Unmarshal([]byte(in), &got))
+	})
+}
+
+func TestDonName_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		don     DonName
+		wantErr string
+	}{
+		{name: "valid", don: "workflow-don_1"},
+		{name: "empty", don: "", wantErr: "don name is empty"},
+		{name: "whitespace only", don: "  ", wantErr: "don name '  ' contains whitespace"},
+		{name: "inner space", don: "test don", wantErr: "contains whitespace"},
+		{name: "tab", don: "don\t", wantErr: "contains whitespace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.don.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestDonCapabilities_Validate(t *testing.T) {
+	peerID := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+	validDon := func() DonCapabilities {
+		return DonCapabilities{
+			Name: "don",
+			Nops: []*models.NodeOperator{
+				{
+					Name:  "nop",
+					Nodes: []*models.Node{newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)},
+				},
+			},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		}
+	}
+	tests := []struct {
+		name     string
+		mutate   func(*DonCapabilities)
+		wantErrs []string
+	}{
+		{
+			name:   "valid",
+			mutate: func(*DonCapabilities) {},
+		},
+		{
+			name:     "empty name",
+			mutate:   func(dc *DonCapabilities) { dc.Name = "" },
+			wantErrs: []string{"don name is empty"},
+		},
+		{
+			name:     "whitespace name",
+			mutate:   func(dc *DonCapabilities) { dc.Name = " " },
+			wantErrs: []string{"don name ' ' contains whitespace"},
+		},
+		{
+			name:     "no nops",
+			mutate:   func(dc *DonCapabilities) { dc.Nops = nil },
+			wantErrs: []string{"don 'don' has no nops"},
+		},
+		{
+			name:     "nop without nodes",
+			mutate:   func(dc *DonCapabilities) { dc.Nops = append(dc.Nops, &models.NodeOperator{Name: "empty nop"}) },
+			wantErrs: []string{"don 'don' nop 'empty nop' has no nodes"},
+		},
+		{
+			name: "nops without nodes",
+			mutate: func(dc *DonCapabilities) {
+				dc.Nops = append(dc.Nops, &models.NodeOperator{Name: "empty nop 1"}, &models.NodeOperator{Name: "empty nop 2", Nodes: []*models.Node{}})
+			},
+			wantErrs: []string{"don 'don' nop 'empty nop 1' has no nodes", "don 'don' nop 'empty nop 2' has no nodes"},
+		},
+		{
+			name:     "no capabilities",
+			mutate:   func(dc *DonCapabilities) { dc.Capabilities = nil },
+			wantErrs: []string{"don 'don' has no capabilities"},
+		},
+		{
+			name: "capability without name",
+			mutate: func(dc *DonCapabilities) {
+				dc.Capabilities = append(dc.Capabilities, kcr.CapabilitiesRegistryCapability{Version: "1.0.0"})
+			},
+			wantErrs: []string{"don 'don' capability 1 has an empty labelled name"},
+		},
+		{
+			name: "invalid capability name",
+			mutate: func(dc *DonCapabilities) {
+				dc.Capabilities = append(dc.Capabilities, kcr.CapabilitiesRegistryCapability{LabelledName: "Bad Name", Version: "1.0.0"})
+			},
+			wantErrs: []string{"don 'don' capability 1: capability name 'Bad Name' has invalid character 'B'"},
+		},
+		{
+			name: "unknown capability type",
+			mutate: func(dc *DonCapabilities) {
+				dc.Capabilities = append(dc.Capabilities, kcr.CapabilitiesRegistryCapability{LabelledName: "bad", Version: "1.0.0", CapabilityType: 4})
+			},
+			wantErrs: []string{"don 'don' capability bad@1.0.0: unknown capability type 4"},
+		},
+		{
+			name: "unknown node capability type",
+			mutate: func(dc *DonCapabilities) {
+				dc.NodeCapabilities = map[string][]kcr.CapabilitiesRegistryCapability{
+					"node-1": {OCR3Cap, {LabelledName: "bad", Version: "1.0.0", CapabilityType: 255}},
+				}
+			},
+			wantErrs: []string{"don 'don' node 'node-1' capability bad@1.0.0: unknown capability type 255"},
+		},
+		{
+			name: "capability config",
+			mutate: func(dc *DonCapabilities) {
+				dc.CapabilityConfigs = map[[32]byte][]byte{HashedCapabilityIDOf(OCR3Cap): []byte("config")}
+			},
+		},
+		{
+			name: "config for capability not in don",
+			mutate: func(dc *DonCapabilities) {
+				dc.CapabilityConfigs = map[[32]byte][]byte{HashedCapabilityIDOf(WriteChainCap): []byte("config")}
+			},
+			wantErrs: []string{fmt.Sprintf("don 'don' has config for capability %x that it does not host", HashedCapabilityIDOf(WriteChainCap))},
+		},
+		{
+			name: "node capabilities",
+			mutate: func(dc *DonCapabilities) {
+				dc.NodeCapabilities = map[string][]kcr.CapabilitiesRegistryCapability{"node-1": {OCR3Cap, WriteChainCap}}
+			},
+		},
+		{
+			name: "node capabilities for unknown node",
+			mutate: func(dc *DonCapabilities) {
+				dc.NodeCapabilities = map[string][]kcr.CapabilitiesRegistryCapability{"node-2": {OCR3Cap}}
+			},
+			wantErrs: []string{"don 'don' has capabilities for unknown node 'node-2'"},
+		},
+		{
+			name: "nil node",
+			mutate: func(dc *DonCapabilities) {
+				dc.Nops[0].Nodes = append(dc.Nops[0].Nodes, nil)
+				dc.NodeCapabilities = map[string][]kcr.CapabilitiesRegistryCapability{"node-1": {OCR3Cap}}
+			},
+			wantErrs: []string{"don 'don' nop 'nop' node 1 is nil"},
+		},
+		{
+			name: "node capabilities missing don capability",
+			mutate: func(dc *DonCapabilities) {
+				dc.NodeCapabilities = map[string][]kcr.CapabilitiesRegistryCapability{"node-1": {WriteChainCap}}
+			},
+			wantErrs: []string{"don 'don' node 'node-1' does not host don capability " + CapabilityID(OCR3Cap)},
+		},
+		{
+			name: "all at once",
+			mutate: func(dc *DonCapabilities) {
+				dc.Name = ""
+				dc.Nops = []*models.NodeOperator{{Name: "empty nop"}}
+				dc.Capabilities = []kcr.CapabilitiesRegistryCapability{{Version: "1.0.0"}}
+			},
+			wantErrs: []string{
+				"don name is empty",
+				"nop 'empty nop' has no nodes",
+				"capability 0 has an empty labelled name",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := validDon()
+			tt.mutate(&dc)
+			err := dc.Validate()
+			if len(tt.wantErrs) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			for _, want := range tt.wantErrs {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestValidateCapabilityName(t *testing.T) {
+	for _, name := range []string{
+		StreamTriggerCap.LabelledName,
+		WriteChainCap.LabelledName,
+		OCR3Cap.LabelledName,
+		"data-streams-reports:chain:ethereum",
+		"cron-trigger",
+		strings.Repeat("a", maxCapabilityNameLength),
+	} {
+		assert.NoError(t, ValidateCapabilityName(name), name)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		wantErr string
+	}{
+		{name: "", wantErr: "empty capability name"},
+		{name: "Streams-Trigger", wantErr: "invalid character 'S'"},
+		{name: "streams trigger", wantErr: "invalid character ' '"},
+		{name: "streams.trigger", wantErr: "invalid character '.'"},
+		{name: "streams-trigger:", wantErr: "has an empty name or label"},
+		{name: ":chain:ethereum", wantErr: "has an empty name or label"},
+		{name: strings.Repeat("a", maxCapabilityNameLength+1), wantErr: "is 129 characters, the maximum is 128"},
+	} {
+		assert.ErrorContains(t, ValidateCapabilityName(tt.name), tt.wantErr, tt.name)
+	}
+}
+
+func Test_validateCapabilityType(t *testing.T) {
+	for typ, name := range capabilityTypeNames {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, validateCapabilityType(uint8(typ)))
+		})
+	}
+	for _, c := range []kcr.CapabilitiesRegistryCapability{StreamTriggerCap, WriteChainCap, OCR3Cap} {
+		require.NoError(t, validateCapabilityType(c.CapabilityType))
+	}
+	require.ErrorContains(t, validateCapabilityType(4), "unknown capability type 4")
+}
+
+func TestDonCapabilities_NodeIDs(t *testing.T) {
+	newNode := func(id string, isBootstrap bool) *models.Node {
+		return newTestCloNode(id, "p2p_"+id, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", isBootstrap)
+	}
+	don := DonCapabilities{
+		Name: "don",
+		Nops: []*models.NodeOperator{
+			{Name: "nop 1", Nodes: []*models.Node{newNode("node-3", false), newNode("node-1", false)}},
+			{Name: "nop 2", Nodes: []*models.Node{newNode("node-2", false)}},
+			{Name: "nop 3", Nodes: []*models.Node{newNode("node-5", true), newNode("node-4", false)}},
+		},
+	}
+	assert.Equal(t, []string{"node-1", "node-2", "node-3", "node-4", "node-5"}, don.NodeIDs())
+	assert.Equal(t, []string{"node-5"}, don.BootstrapNodeIDs())
+
+	// a node listed under two nops is reported once
+	don.Nops = append(don.Nops, &models.NodeOperator{Name: "nop 4", Nodes: []*models.Node{newNode("node-5", true)}}, nil)
+	assert.Equal(t, []string{"node-1", "node-2", "node-3", "node-4", "node-5"}, don.NodeIDs())
+	assert.Equal(t, []string{"node-5"}, don.BootstrapNodeIDs())
+
+	empty := DonCapabilities{Name: "empty"}
+	assert.Empty(t, empty.NodeIDs())
+	assert.Empty(t, empty.BootstrapNodeIDs())
+}
+
+func TestDonIDFromName(t *testing.T) {
+	// the id is the fnv-1a hash of the name so it must not change between releases
+	assert.Equal(t, uint32(0xe40c292c), DonIDFromName("a"))
+	id := DonIDFromName("workflow")
+	assert.Equal(t, id, DonIDFromName("workflow"))
+	assert.NotEqual(t, id, DonIDFromName("writer"))
+
+	dons := []DonCapabilities{{Name: "workflow"}, {Name: "writer"}, {Name: "workflow"}}
+	require.NoError(t, CheckDonIDCollisions(dons))
+
+	t.Run("collision", func(t *testing.T) {
+		// only a and b collide
+		crafted := func(name string) uint32 {
+			if name == "b" {
+				return 1
+			}
+			return uint32(len(name))
+		}
+		err := checkDonIDCollisions([]DonCapabilities{{Name: "a"}, {Name: "cc"}, {Name: "b"}}, crafted)
+		require.Error(t, err)
+		assert.Equal(t, "dons a and b have the same id 1", err.Error())
+	})
+}
+
+func TestNewDonCapabilities(t *testing.T) {
+	p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID()
+	nops := []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{newTestCloNode("node-1", p.String(), fmt.Sprintf("%040x", 1), false)}}}
+	caps := []kcr.CapabilitiesRegistryCapability{OCR3Cap}
+
+	got, err := NewDonCapabilities("don", nops, caps)
+	require.NoError(t, err)
+	assert.Equal(t, DonCapabilities{Name: "don", Nops: nops, Capabilities: caps}, got)
+
+	_, err = NewDonCapabilities("", nops, caps)
+	require.ErrorContains(t, err, "don name is empty")
+}
+
+func TestMergeDonCapabilities(t *testing.T) {
+	base := []DonCapabilities{
+		{Name: "workflow", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap}},
+		{Name: "writer", Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap}},
+	}
+	overlay := []DonCapabilities{
+		{Name: "asset", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap}},
+		{Name: "workflow", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap}},
+	}
+	got, err := MergeDonCapabilities(base, overlay)
+	require.NoError(t, err)
+	assert.Equal(t, []DonCapabilities{overlay[1], base[1], overlay[0]}, got)
+	assert.Equal(t, []kcr.CapabilitiesRegistryCapability{OCR3Cap}, base[0].Capabilities, "the base is not modified")
+
+	_, err = MergeDonCapabilities(append(base, base[0]), overlay)
+	require.ErrorContains(t, err, "duplicate don workflow in base")
+	_, err = MergeDonCapabilities(base, append(overlay, overlay[0]))
+	require.ErrorContains(t, err, "duplicate don asset in overlay")
+}
+
+func TestShardDon(t *testing.T) {
+	var nodes []*models.Node
+	for i := 1; i <= 10; i++ {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		nodes = append(nodes, newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), false))
+	}
+	nops := []*models.NodeOperator{
+		{Name: "nop 1", Nodes: nodes[0:3]},
+		{Name: "nop 2", Nodes: nodes[3:4]},
+		{Name: "nop 3", Nodes: nodes[4:6]},
+		{Name: "nop 4", Nodes: nodes[6:9]},
+		{Name: "nop 5", Nodes: nodes[9:10]},
+	}
+	don := DonCapabilities{
+		Name:             "workflow",
+		Nops:             nops,
+		Capabilities:     []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		NodeCapabilities: map[string][]kcr.CapabilitiesRegistryCapability{"node-5": {OCR3Cap, WriteChainCap}},
+		NodeLabels:       map[string]map[string]string{"node-10": {"region": "eu"}},
+	}
+
+	shards, err := ShardDon(don, 4)
+	require.NoError(t, err)
+	require.Len(t, shards, 3)
+	assert.Equal(t, "workflow-0", shards[0].Name)
+	assert.Equal(t, nops[0:2], shards[0].Nops)
+	assert.Equal(t, "workflow-1", shards[1].Name)
+	assert.Equal(t, nops[2:3], shards[1].Nops)
+	assert.Equal(t, "workflow-2", shards[2].Name)
+	assert.Equal(t, nops[3:5], shards[2].Nops)
+	total := 0
+	for _, shard := range shards {
+		n := 0
+		for _, nop := range shard.Nops {
+			n += len(nop.Nodes)
+		}
+		assert.LessOrEqual(t, n, 4, "shard %s", shard.Name)
+		total += n
+		assert.Equal(t, don.Capabilities, shard.Capabilities)
+		require.NoError(t, shard.Validate())
+	}
+	assert.Equal(t, 10, total)
+	assert.Nil(t, shards[0].NodeCapabilities)
+	assert.Equal(t, don.NodeCapabilities, shards[1].NodeCapabilities)
+	assert.Equal(t, don.NodeLabels, shards[2].NodeLabels)
+
+	got, err := ShardDon(don, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []DonCapabilities{don}, got, "a don within the cap is not split")
+
+	_, err = ShardDon(don, 2)
+	require.ErrorContains(t, err, "nop nop 1 of don workflow has 3 nodes, more than the max of 2 nodes per don")
+	_, err = ShardDon(don, 0)
+	require.ErrorContains(t, err, "invalid max nodes per don 0")
+}
+
+func TestDonCapabilities_ValidateBootstrapCapabilities(t *testing.T) {
+	newNode := func(i int, isBootstrap bool) *models.Node {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		return newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), isBootstrap)
+	}
+	tests := []struct {
+		name    string
+		don     DonCapabilities
+		wantErr string
+	}{
+		{
+			name: "only bootstrap node is assigned a capability",
+			don: DonCapabilities{
+				Name:         "don",
+				Nops:         []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{newNode(1, true)}}},
+				Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+			},
+			wantErr: "don 'don' bootstrap node 'node-1' is assigned capabilities " + CapabilityID(OCR3Cap),
+		},
+		{
+			name: "bootstrap don without capabilities",
+			don: DonCapabilities{
+				Name: "don",
+				Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{newNode(1, true)}}},
+			},
+		},
+		{
+			name: "workers",
+			don: DonCapabilities{
+				Name:         "don",
+				Nops:         []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{newNode(1, false), newNode(2, false)}}},
+				Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+			},
+		},
+		{
+			name: "bootstrap with capability override",
+			don: DonCapabilities{
+				Name: "don",
+				Nops: []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{newNode(1, true), newNode(2, false)}}},
+				NodeCapabilities: map[string][]kcr.CapabilitiesRegistryCapability{
+					"node-1": {WriteChainCap},
+				},
+			},
+			wantErr: "don 'don' bootstrap node 'node-1' is assigned capabilities " + CapabilityID(WriteChainCap),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the assignments are only warnings when not strict
+			require.NoError(t, tt.don.ValidateBootstrapCapabilities(logger.Test(t), false))
+			err := tt.don.ValidateBootstrapCapabilities(logger.Test(t), true)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAssertHomogeneousCapabilities(t *testing.T) {
+	dc := DonCapabilities{
+		Name:         "don",
+		Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap},
+	}
+	require.NoError(t, AssertHomogeneousCapabilities(dc), "no overrides")
+
+	dc.NodeCapabilities = map[string][]kcr.CapabilitiesRegistryCapability{
+		"node-1": {WriteChainCap, OCR3Cap},
+		"node-2": {OCR3Cap, WriteChainCap, OCR3Cap},
+	}
+	require.NoError(t, AssertHomogeneousCapabilities(dc), "overrides with the don capabilities in any order")
+
+	dc.NodeCapabilities["node-3"] = []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap, StreamTriggerCap}
+	// overrides that remove capabilities are reported as well as those that add them
+	dc.NodeCapabilities["node-0"] = []kcr.CapabilitiesRegistryCapability{OCR3Cap}
+	err := AssertHomogeneousCapabilities(dc)
+	require.Error(t, err)
+	assert.Equal(t, fmt.Sprintf("don 'don' node 'node-0' hosts capabilities [%[1]s] but the don hosts [%[1]s,%[2]s]\n"+
+		"don 'don' node 'node-3' hosts capabilities [%[1]s,%[3]s,%[2]s] but the don hosts [%[1]s,%[2]s]",
+		CapabilityID(OCR3Cap), CapabilityID(WriteChainCap), CapabilityID(StreamTriggerCap)), err.Error())
+
+	dc.HeterogeneousCapabilities = true
+	require.NoError(t, AssertHomogeneousCapabilities(dc))
+}
+
+func TestCapabilitySummary(t *testing.T) {
+	cron := kcr.CapabilitiesRegistryCapability{LabelledName: "cron-trigger", Version: "1.0.0", CapabilityType: 0}
+	dons := []DonCapabilities{
+		{Name: "workflow", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, cron}},
+		{Name: "writer", Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap}},
+		// listed twice and named before workflow to check the names are deduplicated and sorted
+		{Name: "cron", Capabilities: []kcr.CapabilitiesRegistryCapability{cron, cron, WriteChainCap}},
+	}
+	assert.Equal(t, map[string][]string{
+		CapabilityID(OCR3Cap):       {"workflow"},
+		"cron-trigger@1.0.0":        {"cron", "workflow"},
+		CapabilityID(WriteChainCap): {"cron", "writer"},
+	}, CapabilitySummary(dons))
+	assert.Empty(t, CapabilitySummary(nil))
+}
+
+func TestCapabilityDonIndex(t *testing.T) {
+	cron := kcr.CapabilitiesRegistryCapability{LabelledName: "cron-trigger", Version: "1.0.0", CapabilityType: 0}
+	dons := []DonCapabilities{
+		{Name: "workflow", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, cron}},
+		{Name: "writer", Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap}},
+		{Name: "cron", Capabilities: []kcr.CapabilitiesRegistryCapability{cron, cron, WriteChainCap}},
+	}
+	got := CapabilityDonIndex(dons)
+	assert.Equal(t, map[[32]byte][]string{
+		HashedCapabilityIDOf(OCR3Cap):               {"workflow"},
+		HashedCapabilityID("cron-trigger", "1.0.0"): {"cron", "workflow"},
+		HashedCapabilityIDOf(WriteChainCap):         {"cron", "writer"},
+	}, got)
+	// the index has the same entries as the summary, by registry id
+	for id, names := range CapabilitySummary(dons) {
+		name, version, _ := strings.Cut(id, "@")
+		assert.Equal(t, names, got[HashedCapabilityID(name, version)], id)
+	}
+	assert.Empty(t, CapabilityDonIndex(nil))
+}
+
+func TestCheckCapabilityConsistency(t *testing.T) {
+	cron := kcr.CapabilitiesRegistryCapability{LabelledName: "cron", Version: "1.0.0", CapabilityType: 0}
+	cronTarget := cron
+	cronTarget.CapabilityType = 3
+	cronObservation := cron
+	cronObservation.ResponseType = 1
+	dons := []DonCapabilities{
+		{Name: "workflow", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, cron}},
+		{Name: "writer", Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap, cron}},
+	}
+	require.NoError(t, CheckCapabilityConsistency(dons))
+
+	dons[1].Capabilities[1] = cronTarget
+	err := CheckCapabilityConsistency(dons)
+	require.Error(t, err)
+	assert.Equal(t, "capability cron@1.0.0 has type 0 and response type 0 in don workflow but type 3 and response type 0 in don writer", err.Error())
+
+	dons[1].Capabilities[1] = cron
+	dons[1].NodeCapabilities = map[string][]kcr.CapabilitiesRegistryCapability{"node-1": {WriteChainCap, cronObservation}}
+	require.ErrorContains(t, CheckCapabilityConsistency(dons), "response type 1 in don writer")
+}
+
+func TestAllCapabilities(t *testing.T) {
+	cron := kcr.CapabilitiesRegistryCapability{LabelledName: "cron-trigger", Version: "1.0.0", CapabilityType: 0}
+	dons := []DonCapabilities{
+		{Name: "workflow", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, cron}},
+		{Name: "writer", Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap, OCR3Cap}},
+		{Name: "cron", Capabilities: []kcr.CapabilitiesRegistryCapability{cron}},
+	}
+	got := AllCapabilities(dons)
+	require.Len(t, got, 3)
+	var ids []string
+	for _, c := range got {
+		ids = append(ids, CapabilityID(c))
+	}
+	assert.True(t, slices.IsSorted(ids), "ids %v are not sorted", ids)
+	assert.ElementsMatch(t, []kcr.CapabilitiesRegistryCapability{OCR3Cap, cron, WriteChainCap}, got)
+	assert.Empty(t, AllCapabilities(nil))
+
+	cron10 := kcr.CapabilitiesRegistryCapability{LabelledName: "cron-trigger", Version: "1.10.0"}
+	cron9 := kcr.CapabilitiesRegistryCapability{LabelledName: "cron-trigger", Version: "1.9.0"}
+	got = AllCapabilities([]DonCapabilities{{Name: "cron", Capabilities: []kcr.CapabilitiesRegistryCapability{cron10, cron9}}})
+	assert.Equal(t, []kcr.CapabilitiesRegistryCapability{cron9, cron10}, got)
+}
+
+func TestPartitionDons(t *testing.T) {
+	registryChainSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	newNode := func(i int, isBootstrap bool) *models.Node {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		return newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), isBootstrap)
+	}
+	bootstrapDon := DonCapabilities{
+		Name: "bootstrap",
+		Nops: []*models.NodeOperator{{Name: "nop 1", Nodes: []*models.Node{newNode(1, true), newNode(2, true)}}},
+	}
+	workerDon := DonCapabilities{
+		Name:         "worker",
+		Nops:         []*models.NodeOperator{{Name: "nop 1", Nodes: []*models.Node{newNode(3, false), newNode(4, false)}}},
+		Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+	}
+	// a don with a bootstrap and workers hosts capabilities so it is not a bootstrap don
+	mixedDon := DonCapabilities{
+		Name: "mixed",
+		Nops: []*models.NodeOperator{
+			{Name: "nop 1", Nodes: []*models.Node{newNode(5, true)}},
+			{Name: "nop 2", Nodes: []*models.Node{newNode(6, false)}},
+		},
+		Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+	}
+
+	bootstrap, worker, err := PartitionDons(tests.Context(t), []DonCapabilities{workerDon, bootstrapDon, mixedDon}, registryChainSel)
+	require.NoError(t, err)
+	assert.Equal(t, []DonCapabilities{bootstrapDon}, bootstrap)
+	assert.Equal(t, []DonCapabilities{workerDon, mixedDon}, worker)
+
+	t.Run("invalid node", func(t *testing.T) {
+		bad := newNode(7, false)
+		bad.PublicKey = nil
+		_, _, err := PartitionDons(tests.Context(t), []DonCapabilities{{Name: "bad", Nops: []*models.NodeOperator{{Nodes: []*models.Node{bad}}}}}, registryChainSel)
+		require.ErrorContains(t, err, "failed to convert node node-7 of don bad")
+	})
+
+	t.Run("nil nodes", func(t *testing.T) {
+		// nil nodes and nops are reported by Validate and skipped here
+		withNil := DonCapabilities{
+			Name: "bootstrap",
+			Nops: []*models.NodeOperator{nil, {Name: "nop 1", Nodes: []*models.Node{newNode(1, true), nil}}},
+		}
+		bootstrap, worker, err := PartitionDons(tests.Context(t), []DonCapabilities{withNil}, registryChainSel)
+		require.NoError(t, err)
+		assert.Equal(t, []DonCapabilities{withNil}, bootstrap)
+		assert.Empty(t, worker)
+	})
+}
+
+func TestDonCapabilitiesFromOnchain(t *testing.T) {
+	nop1 := &models.NodeOperator{ID: "nop-1", Name: "nop 1"}
+	nop2 := &models.NodeOperator{ID: "nop-2", Name: "nop 2"}
+	nodes := make(map[[32]byte]*models.Node)
+	var p2pIDs [][32]byte
+	for i, nop := range []*models.NodeOperator{nop1, nop1, nop2} {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i + 1))).PeerID()
+		n := newTestCloNode(fmt.Sprintf("node-%d", i+1), p.String(), fmt.Sprintf("%040x", i+1), false)
+		n.NodeOperator = nop
+		nodes[p] = n
+		p2pIDs = append(p2pIDs, p)
+	}
+	nodeLookup := func(id [32]byte) (*models.Node, error) {
+		n, ok := nodes[id]
+		if !ok {
+			return nil, errors.New("unknown node")
+		}
+		return n, nil
+	}
+	ocr3ID := HashedCapabilityID(OCR3Cap.LabelledName, OCR3Cap.Version)
+	writeID := HashedCapabilityID(WriteChainCap.LabelledName, WriteChainCap.Version)
+	capLookup := func(id [32]byte) (kcr.CapabilitiesRegistryCapability, error) {
+		switch id {
+		case ocr3ID:
+			return OCR3Cap, nil
+		case writeID:
+			return WriteChainCap, nil
+		}
+		return kcr.CapabilitiesRegistryCapability{}, errors.New("unknown capability")
+	}
+	info := kcr.CapabilitiesRegistryDONInfo{
+		Id:         1,
+		NodeP2PIds: p2pIDs,
+		CapabilityConfigurations: []kcr.CapabilitiesRegistryCapabilityConfiguration{
+			{CapabilityId: ocr3ID},
+			{CapabilityId: writeID, Config: []byte("config")},
+		},
+	}
+
+	t.Run("reconstructs the don", func(t *testing.T) {
+		got, err := DonCapabilitiesFromOnchain(info, nodeLookup, capLookup)
+		require.NoError(t, err)
+		require.Len(t, got.Nops, 2)
+		assert.Equal(t, "nop 1", got.Nops[0].Name)
+		assert.Equal(t, []*models.Node{nodes[p2pIDs[0]], nodes[p2pIDs[1]]}, got.Nops[0].Nodes)
+		assert.Equal(t, "nop 2", got.Nops[1].Name)
+		assert.Equal(t, []*models.Node{nodes[p2pIDs[2]]}, got.Nops[1].Nodes)
+		assert.Equal(t, []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap}, got.Capabilities)
+		assert.Equal(t, map[[32]byte][]byte{writeID: []byte("config")}, got.CapabilityConfigs)
+		// the lookups must not be modified
+		assert.Empty(t, nop1.Nodes)
+	})
+
+	t.Run("missing node", func(t *testing.T) {
+		missing := info
+		missing.NodeP2PIds = append(slices.Clone(p2pIDs), p2pkey.MustNewV2XXXTestingOnly(big.NewInt(4)).PeerID())
+		_, err := DonCapabilitiesFromOnchain(missing, nodeLookup, capLookup)
+		require.ErrorContains(t, err, "failed to lookup node")
+		require.ErrorContains(t, err, "unknown node")
+	})
+
+	t.Run("missing capability", func(t *testing.T) {
+		missing := info
+		missing.CapabilityConfigurations = []kcr.CapabilitiesRegistryCapabilityConfiguration{{CapabilityId: [32]byte{1}}}
+		_, err := DonCapabilitiesFromOnchain(missing, nodeLookup, capLookup)
+		require.ErrorContains(t, err, "failed to lookup capability")
+		require.ErrorContains(t, err, "unknown capability")
+	})
+
+	t.Run("node without node operator", func(t *testing.T) {
+		orphan := newTestCloNode("orphan", p2pkey.MustNewV2XXXTestingOnly(big.NewInt(5)).PeerID().String(), fmt.Sprintf("%040x", 5), false)
+		_, err := DonCapabilitiesFromOnchain(info, func([32]byte) (*models.Node, error) { return orphan, nil }, capLookup)
+		require.ErrorContains(t, err, "has no node operator")
+	})
+}
+
+func TestFilterDonsByCapability(t *testing.T) {
+	nops := []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{{ID: "node-1"}}}}
+	dons := []DonCapabilities{
+		{Name: "ocr3", Nops: nops, Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap}},
+		{Name: "write", Nops: nops, Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap}},
+		{Name: "ocr3 and write", Nops: nops, Capabilities: []kcr.CapabilitiesRegistryCapability{WriteChainCap, OCR3Cap}},
+	}
+	got := FilterDonsByCapability(dons, OCR3Cap.LabelledName, OCR3Cap.Version)
+	require.Len(t, got, 2)
+	assert.Equal(t, []DonCapabilities{dons[0], dons[2]}, got)
+	assert.Equal(t, nops, got[1].Nops)
+
+	assert.Empty(t, FilterDonsByCapability(dons, OCR3Cap.LabelledName, "2.0.0"))
+}
+
+func Test_nodesToNops(t *testing.T) {
+	registryChainSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	peerID := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID().String()
+	newDon := func(name string, admin string) DonCapabilities {
+		n := newTestCloNode("node-1", peerID, "b35409a8d4f9a18da55c5b2bb08a3f5f68d44442", false)
+		n.ChainConfigs[0].AdminAddress = admin
+		return DonCapabilities{
+			Name:         name,
+			Nops:         []*models.NodeOperator{{Name: "nop", Nodes: []*models.Node{n}}},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		}
+	}
+
+	t.Run("same node in two dons", func(t *testing.T) {
+		got, err := nodesToNops([]DonCapabilities{
+			newDon("don 1", "0x0000000000000000000000000000000000000001"),
+			newDon("don 2", "0x0000000000000000000000000000000000000001"),
+		}, registryChainSel, defaultBundleRole, DefaultSelectorResolver)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]kcr.CapabilitiesRegistryNodeOperator{
+			"node-1": {Name: "nop", Admin: common.HexToAddress("0x01")},
+		}, got)
+	})
+
+	t.Run("conflicting admins", func(t *testing.T) {
+		_, err := nodesToNops([]DonCapabilities{
+			newDon("don 1", "0x0000000000000000000000000000000000000001"),
+			newDon("don 2", "0x0000000000000000000000000000000000000002"),
+		}, registryChainSel, defaultBundleRole, DefaultSelectorResolver)
+		require.ErrorContains(t, err, "node node-1 has conflicting NOP admins")
+		require.ErrorContains(t, err, "in don don 1")
+		require.ErrorContains(t, err, "in don don 2")
+	})
+}
+
+func TestFilterNewNops(t *testing.T) {
+	existingNop := kcr.CapabilitiesRegistryNodeOperator{Name: "nop 1", Admin: common.HexToAddress("0x01")}
+	newNop := kcr.CapabilitiesRegistryNodeOperator{Name: "nop 2", Admin: common.HexToAddress("0x02")}
+	// same name as an existing nop but a different admin
+	newAdminNop := kcr.CapabilitiesRegistryNodeOperator{Name: "nop 1", Admin: common.HexToAddress("0x03")}
+	desired := map[string]kcr.CapabilitiesRegistryNodeOperator{
+		"node-1": existingNop,
+		"node-2": existingNop,
+		"node-3": newNop,
+		"node-4": newAdminNop,
+	}
+
+	got := FilterNewNops(desired, []kcr.CapabilitiesRegistryNodeOperator{existingNop})
+	assert.Equal(t, map[string]kcr.CapabilitiesRegistryNodeOperator{
+		"node-3": newNop,
+		"node-4": newAdminNop,
+	}, got)
+
+	assert.Equal(t, desired, FilterNewNops(desired, nil))
+	assert.Empty(t, FilterNewNops(desired, []kcr.CapabilitiesRegistryNodeOperator{existingNop, newNop, newAdminNop}))
+}
+
+func TestDistinctNops(t *testing.T) {
+	registryChainSel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	newNode := func(i int, admin string) *models.Node {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		n := newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), false)
+		n.ChainConfigs[0].AdminAddress = admin
+		return n
+	}
+	const (
+		admin1 = "0x0000000000000000000000000000000000000001"
+		admin2 = "0x0000000000000000000000000000000000000002"
+	)
+	dons := []DonCapabilities{
+		{
+			Name: "don 1",
+			Nops: []*models.NodeOperator{
+				{Name: "nop 1", Nodes: []*models.Node{newNode(1, admin1), newNode(2, admin1)}},
+				{Name: "nop 2", Nodes: []*models.Node{newNode(3, admin2)}},
+			},
+		},
+		{
+			Name: "don 2",
+			Nops: []*models.NodeOperator{
+				// same nop as in don 1 with a different node
+				{Name: "nop 1", Nodes: []*models.Node{newNode(4, admin1)}},
+				// same name as nop 2 but a different admin is a different nop
+				{Name: "nop 2", Nodes: []*models.Node{newNode(5, admin1)}},
+			},
+		},
+	}
+
+	got, err := DistinctNops(dons, registryChainSel)
+	require.NoError(t, err)
+	assert.Equal(t, 3, got)
+
+	got, err = DistinctNops(dons[:1], registryChainSel)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got)
+
+	_, err = DistinctNops(dons, chainsel.ETHEREUM_MAINNET.Selector)
+	require.Error(t, err)
+}
+
+func TestOrphanedNodes(t *testing.T) {
+	var nodes []*models.Node
+	for i := 1; i <= 4; i++ {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		nodes = append(nodes, newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), false))
+	}
+	dons := []DonCapabilities{
+		{Name: "don 1", Nops: []*models.NodeOperator{{Name: "nop 1", Nodes: []*models.Node{nodes[0], nodes[1]}}}},
+		{Name: "don 2", Nops: []*models.NodeOperator{{Name: "nop 2", Nodes: []*models.Node{nodes[3]}}}},
+	}
+	assert.Equal(t, []string{"node-3"}, OrphanedNodes(nodes, dons))
+	assert.Empty(t, OrphanedNodes(nodes[:2], dons))
+	assert.Equal(t, []string{"node-1", "node-2", "node-3", "node-4"}, OrphanedNodes(nodes, nil))
+}
+
+func TestChainTypesInUse(t *testing.T) {
+	var nodes []*models.Node
+	for i := 1; i <= 3; i++ {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		nodes = append(nodes, newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), false))
+	}
+	nodes[2].ChainConfigs = append(nodes[2].ChainConfigs, &models.NodeChainConfig{
+		Network: &models.Network{ChainType: models.ChainTypeAptos},
+	})
+	dons := []DonCapabilities{
+		{Name: "evm", Nops: []*models.NodeOperator{{Name: "nop 1", Nodes: nodes[:2]}}},
+		{Name: "aptos", Nops: []*models.NodeOperator{{Name: "nop 2", Nodes: nodes[2:]}}},
+	}
+
+	got, err := ChainTypesInUse(dons)
+	require.NoError(t, err)
+	assert.Equal(t, []chaintype.ChainType{chaintype.Aptos, chaintype.EVM}, got)
+
+	got, err = ChainTypesInUse(dons[:1])
+	require.NoError(t, err)
+	assert.Equal(t, []chaintype.ChainType{chaintype.EVM}, got)
+
+	got, err = ChainTypesInUse(nil)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	nodes[0].ChainConfigs = append(nodes[0].ChainConfigs, &models.NodeChainConfig{
+		Network: &models.Network{ChainType: models.ChainType("COSMOS")},
+	})
+	_, err = ChainTypesInUse(dons)
+	require.ErrorContains(t, err, "node 'node-1' of don evm: unsupported chain type 'COSMOS'")
+}
+
+func TestSortedNops(t *testing.T) {
+	nopB := kcr.CapabilitiesRegistryNodeOperator{Name: "b", Admin: common.HexToAddress("0x01")}
+	nopA2 := kcr.CapabilitiesRegistryNodeOperator{Name: "a", Admin: common.HexToAddress("0x02")}
+	nopA1 := kcr.CapabilitiesRegistryNodeOperator{Name: "a", Admin: common.HexToAddress("0x01")}
+	m := map[string]kcr.CapabilitiesRegistryNodeOperator{
+		"node-1": nopB,
+		"node-2": nopA2,
+		"node-3": nopA1,
+		"node-4": nopB,
+	}
+	want := []kcr.CapabilitiesRegistryNodeOperator{nopA1, nopA2, nopB, nopB}
+	// map iteration order is random, so repeat to make an unstable order observable
+	for i := 0; i < 20; i++ {
+		require.Equal(t, want, SortedNops(m))
+	}
+	assert.Empty(t, SortedNops(nil))
+}
+
+func TestVerifyNops(t *testing.T) {
+	var (
+		admin1 = common.HexToAddress("0x01")
+		admin2 = common.HexToAddress("0x02")
+	)
+	desired := map[string]kcr.CapabilitiesRegistryNodeOperator{
+		"node-1": {Name: "nop 1", Admin: admin1},
+		"node-2": {Name: "nop 1", Admin: admin1},
+		"node-3": {Name: "nop 2", Admin: admin2},
+	}
+
+	t.Run("match", func(t *testing.T) {
+		onchain := []kcr.CapabilitiesRegistryNodeOperator{
+			{Name: "nop 2", Admin: admin2},
+			{Name: "nop 1", Admin: admin1},
+			{Name: "other", Admin: admin1},
+		}
+		require.NoError(t, VerifyNops(desired, onchain))
+	})
+
+	t.Run("mismatched admin", func(t *testing.T) {
+		onchain := []kcr.CapabilitiesRegistryNodeOperator{
+			{Name: "nop 1", Admin: admin1},
+			{Name: "nop 2", Admin: admin1},
+		}
+		err := VerifyNops(desired, onchain)
+		require.Error(t, err)
+		assert.Equal(t, fmt.Sprintf("nop nop 2 has admin %s, expected %s", admin1.Hex(), admin2.Hex()), err.Error())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		err := VerifyNops(desired, nil)
+		require.Error(t, err)
+		assert.Equal(t, "nop nop 1 is not registered\nnop nop 2 is not registered", err.Error())
+	})
+}
+
+func TestRegistryTypeAliases(t *testing.T) {
+	// the aliases are the registry types, so values are interchangeable without conversion
+	var (
+		c    Capability                           = OCR3Cap
+		nop  kcr.CapabilitiesRegistryNodeOperator = NodeOperator{Name: "nop"}
+		info kcr.CapabilitiesRegistryDONInfo      = DONInfo{Id: 1}
+	)
+	assert.Equal(t, CapabilityID(OCR3Cap), CapabilityID(c))
+	assert.Equal(t, "nop", nop.Name)
+	assert.Equal(t, uint32(1), info.Id)
+}
+
+func Test_mapDonsToCaps(t *testing.T) {
+	dons := []DonCapabilities{
+		{
+			Name:         "dup",
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap, OCR3Cap},
+		},
+		{
+			Name:         "uniq",
+			Capabilities: []kcr.CapabilitiesRegistryCapability{StreamTriggerCap},
+		},
+	}
+	got := mapDonsToCaps(logger.Test(t), dons)
+	require.Len(t, got, 2)
+	assert.Equal(t, []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap}, got["dup"])
+	assert.Equal(t, []kcr.CapabilitiesRegistryCapability{StreamTriggerCap}, got["uniq"])
+}
+
+func Test_mapNodesToCaps(t *testing.T) {
+	t.Run("no overrides", func(t *testing.T) {
+		got := mapNodesToCaps([]DonCapabilities{{Name: "don", Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap}}})
+		assert.Empty(t, got)
+	})
+	t.Run("union across dons", func(t *testing.T) {
+		dons := []DonCapabilities{
+			{
+				Name:             "don 1",
+				NodeCapabilities: map[string][]kcr.CapabilitiesRegistryCapability{"node-b": {OCR3Cap, WriteChainCap}},
+			},
+			{
+				Name:             "don 2",
+				NodeCapabilities: map[string][]kcr.CapabilitiesRegistryCapability{"node-b": {WriteChainCap, StreamTriggerCap}},
+			},
+		}
+		got := mapNodesToCaps(dons)
+		require.Len(t, got, 1)
+		assert.Equal(t, []kcr.CapabilitiesRegistryCapability{OCR3Cap, WriteChainCap, StreamTriggerCap}, got["node-b"])
+	})
+}
+
+func Test_capabilityConfig(t *testing.T) {
+	ocr3 := RegisteredCapability{CapabilitiesRegistryCapability: OCR3Cap, ID: HashedCapabilityIDOf(OCR3Cap)}
+	trigger := RegisteredCapability{CapabilitiesRegistryCapability: StreamTriggerCap, ID: HashedCapabilityIDOf(StreamTriggerCap)}
+	// a don with a config blob for only one of its capabilities
+	don := DonCapabilities{
+		Name:              "don",
+		Capabilities:      []kcr.CapabilitiesRegistryCapability{OCR3Cap, StreamTriggerCap},
+		CapabilityConfigs: map[[32]byte][]byte{ocr3.ID: []byte("ocr3 config")},
+	}
+	configs := mapDonsToCapConfigs([]DonCapabilities{don, {Name: "no configs"}})
+	require.Len(t, configs, 1)
+
+	got, err := capabilityConfig(ocr3, 4, configs["don"])
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ocr3 config"), got)
+
+	got, err = capabilityConfig(trigger, 4, configs["don"])
+	require.NoError(t, err)
+	want, err := proto.Marshal(defaultCapConfig(trigger.CapabilityType, 4))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	got, err = capabilityConfig(ocr3, 4, configs["no configs"])
+	require.NoError(t, err)
+	want, err = proto.Marshal(defaultCapConfig(ocr3.CapabilityType, 4))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func Test_makeNodeParams(t *testing.T) {
+	var (
+		cap1 = RegisteredCapability{CapabilitiesRegistryCapability: OCR3Cap, ID: HashedCapabilityID(OCR3Cap.LabelledName, OCR3Cap.Version)}
+		cap2 = RegisteredCapability{CapabilitiesRegistryCapability: WriteChainCap, ID: HashedCapabilityID(WriteChainCap.LabelledName, WriteChainCap.Version)}
+	)
+	nodes := []*Ocr2Node{
+		{ID: "node-a", P2PKey: p2pkey.MustNewV2XXXTestingOnly(big.NewInt(1)).PeerID()},
+		{ID: "node-b", P2PKey: p2pkey.MustNewV2XXXTestingOnly(big.NewInt(2)).PeerID()},
+	}
+	nops := map[string]*kcr.CapabilitiesRegistryNodeOperatorAdded{
+		"node-a": {NodeOperatorId: 1},
+		"node-b": {NodeOperatorId: 2},
+	}
+	tests := []struct {
+		name               string
+		nodeToCapabilities map[string][]RegisteredCapability
+		want               map[string][][32]byte
+	}{
+		{
+			name: "no overrides",
+			want: map[string][][32]byte{
+				"node-a": {cap1.ID},
+				"node-b": {cap1.ID},
+			},
+		},
+		{
+			name:               "mixed don",
+			nodeToCapabilities: map[string][]RegisteredCapability{"node-b": {cap1, cap2}},
+			want: map[string][][32]byte{
+				"node-a": {cap1.ID},
+				"node-b": {cap1.ID, cap2.ID},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := makeNodeParams(logger.Test(t), &registerNodesRequest{
+				donToOcr2Nodes:     map[DonName][]*Ocr2Node{"don": nodes},
+				donToCapabilities:  map[DonName][]RegisteredCapability{"don": {cap1}},
+				nodeToCapabilities: tt.nodeToCapabilities,
+			}, nops)
+			require.NoError(t, err)
+			require.Len(t, got, len(tt.want))
+			for id, want := range tt.want {
+				assert.Equal(t, want, got[id].HashedCapabilityIds, "node %s", id)
+				assert.Equal(t, nops[id].NodeOperatorId, got[id].NodeOperatorId)
+			}
+		})
+	}
+}
+
+func TestRegisteredDon_Signers(t *testing.T) {
+	var nodes []*Ocr2Node
+	for i := 1; i <= 4; i++ {
+		n := &Ocr2Node{
+			ID:         fmt.Sprintf("node-%d", i),
+			P2PKey:     p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID(),
+			IsBoostrap: i == 3,
+		}
+		n.Signer[0] = byte(i)
+		nodes = append(nodes, n)
+	}
+	// start from reverse peer id order so that an in place sort would be observable
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].P2PKey.String() > nodes[j].P2PKey.String() })
+	don := RegisteredDon{Name: "don", Nodes: slices.Clone(nodes)}
+
+	got := don.Signers()
+	require.Len(t, got, 3)
+	assert.Equal(t, nodes, don.Nodes, "Signers must not reorder the don nodes")
+	assert.Equal(t, got, don.Signers(), "Signers must be stable across calls")
+
+	// signers follow p2p peer id order and exclude the bootstrap
+	var want []common.Address
+	sorted := slices.Clone(nodes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].P2PKey.String() < sorted[j].P2PKey.String() })
+	for _, n := range sorted {
+		if !n.IsBoostrap {
+			want = append(want, n.signerAddress())
+		}
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestBuildSignerIndex(t *testing.T) {
+	newNode := func(id string, signer string, isBootstrap bool) *Ocr2Node {
+		n := &Ocr2Node{ID: id, IsBoostrap: isBootstrap}
+		copy(n.Signer[:], common.HexToAddress(signer).Bytes())
+		return n
+	}
+	signer := common.HexToAddress("0xb35409a8d4f9a18da55c5b2bb08a3f5f68d44442")
+	dons := []RegisteredDon{
+		{Name: "don 1", Nodes: []*Ocr2Node{newNode("node-1", signer.Hex(), false), newNode("node-2", "0x02", false)}},
+		// the bootstrap has the same signer as node-1 but is not indexed
+		{Name: "don 2", Nodes: []*Ocr2Node{newNode("node-3", "0x03", false), newNode("bootstrap", signer.Hex(), true)}},
+	}
+
+	n, ok := dons[0].NodeBySigner(signer)
+	require.True(t, ok)
+	assert.Equal(t, "node-1", n.ID)
+	_, ok = dons[1].NodeBySigner(signer)
+	assert.False(t, ok)
+
+	got, err := BuildSignerIndex(dons)
+	require.NoError(t, err)
+	assert.Equal(t, map[common.Address]string{
+		signer:                      "node-1",
+		common.HexToAddress("0x02"): "node-2",
+		common.HexToAddress("0x03"): "node-3",
+	}, got)
+
+	t.Run("shared signer", func(t *testing.T) {
+		// the same node in two dons is not a conflict
+		shared := append(slices.Clone(dons), RegisteredDon{Name: "don 3", Nodes: []*Ocr2Node{dons[0].Nodes[0]}})
+		_, err := BuildSignerIndex(shared)
+		require.NoError(t, err)
+
+		shared = append(shared, RegisteredDon{Name: "don 4", Nodes: []*Ocr2Node{newNode("node-4", "0x02", false)}})
+		_, err = BuildSignerIndex(shared)
+		var sharedErr *ErrSharedSigner
+		require.ErrorAs(t, err, &sharedErr)
+		assert.Equal(t, common.HexToAddress("0x02"), sharedErr.Signer)
+		assert.Equal(t, []string{"node-2", "node-4"}, sharedErr.NodeIDs)
+	})
+}
+
+func TestRegisteredDon_Bootstraps(t *testing.T) {
+	var nodes []*Ocr2Node
+	for i := 1; i <= 5; i++ {
+		nodes = append(nodes, &Ocr2Node{
+			ID:         fmt.Sprintf("node-%d", i),
+			P2PKey:     p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID(),
+			IsBoostrap: i == 2 || i == 5,
+		})
+	}
+	don := RegisteredDon{Name: "don", Nodes: slices.Clone(nodes)}
+
+	got := don.Bootstraps()
+	require.Len(t, got, 2)
+	assert.Equal(t, nodes, don.Nodes, "Bootstraps must not reorder the don nodes")
+	assert.ElementsMatch(t, []*Ocr2Node{nodes[1], nodes[4]}, got)
+	assert.Less(t, got[0].P2PKey.String(), got[1].P2PKey.String())
+	for _, n := range got {
+		assert.True(t, n.IsBootstrap())
+	}
+	assert.Len(t, don.Signers(), 3)
+}
+
+func TestRegisteredDon_BootstrapLocators(t *testing.T) {
+	addrs := map[int]string{2: "/ip4/127.0.0.1/tcp/6690", 4: "bootstrap-4.example.com:6691"}
+	var (
+		peerIDs []p2pkey.PeerID
+		nodes   []*Ocr2Node
+	)
+	for i := 1; i <= 4; i++ {
+		p := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID()
+		peerIDs = append(peerIDs, p)
+		cn := newTestCloNode(fmt.Sprintf("node-%d", i), p.String(), fmt.Sprintf("%040x", i), i%2 == 0)
+		if addr, ok := addrs[i]; ok {
+			cn.ChainConfigs[0].Ocr2Config.Multiaddr = &addr
+		}
+		n, err := newOcr2NodeFromClo(tests.Context(t), cn, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector, defaultBundleRole, DefaultSelectorResolver)
+		require.NoError(t, err)
+		nodes = append(nodes, n)
+	}
+	don := RegisteredDon{Name: "don", Nodes: nodes}
+
+	got, err := don.BootstrapLocators()
+	require.NoError(t, err)
+	want := []string{peerIDs[1].Raw() + "@127.0.0.1:6690", peerIDs[3].Raw() + "@bootstrap-4.example.com:6691"}
+	if peerIDs[3].String() < peerIDs[1].String() {
+		want[0], want[1] = want[1], want[0]
+	}
+	assert.Equal(t, want, got)
+
+	t.Run("missing network address", func(t *testing.T) {
+		bootstrap := *nodes[3]
+		bootstrap.networkAddress = ""
+		don := RegisteredDon{Name: "don", Nodes: []*Ocr2Node{nodes[0], nodes[1], &bootstrap}}
+		_, err := don.BootstrapLocators()
+		require.ErrorContains(t, err, "bootstrap node node-4 of don don has no network address")
+	})
+
+	t.Run("network address", func(t *testing.T) {
+		for _, tc := range []struct {
+			addr    string
+			want    string
+			wantErr string
+		}{
+			{addr: "/ip4/10.0.0.1/tcp/5001", want: "10.0.0.1:5001"},
+			{addr: "/ip6/::1/tcp/5001", want: "[::1]:5001"},
+			{addr: "/dns4/bootstrap.example.com/tcp/5001", want: "bootstrap.example.com:5001"},
+			{addr: "10.0.0.1:5001", want: "10.0.0.1:5001"},
+			{addr: "10.0.0.1", wantErr: "invalid address"},
+			{addr: "/ip4/10.0.0.1/udp/5001", wantErr: "unsupported multiaddr"},
+			{addr: "/unix/tmp/tcp/5001", wantErr: "unknown protocol unix"},
+			{addr: "/ip4/10.0.0.1/tcp/port", wantErr: "invalid port"},
+		} {
+			got, err := networkHostPort(tc.addr)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr, tc.addr)
+				continue
+			}
+			require.NoError(t, err, tc.addr)
+			assert.Equal(t, tc.want, got)
+		}
+	})
+}
+
+func TestRegisteredDon_DiffNodes(t *testing.T) {
+	var nodes []*Ocr2Node
+	for i := 1; i <= 5; i++ {
+		nodes = append(nodes, &Ocr2Node{
+			ID:     fmt.Sprintf("node-%d", i),
+			P2PKey: p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID(),
+			Signer: [32]byte{0: byte(i)},
+		})
+	}
+	// node-3 rotated its signer but kept its peer id
+	rotated := *nodes[2]
+	rotated.Signer = [32]byte{0: 0xff}
+	current := RegisteredDon{Name: "don", Nodes: []*Ocr2Node{nodes[0], nodes[1], nodes[2], nodes[3]}}
+	desired := RegisteredDon{Name: "don", Nodes: []*Ocr2Node{nodes[1], &rotated, nodes[3], nodes[4]}}
+
+	added, removed := current.DiffNodes(desired)
+	assert.Equal(t, []*Ocr2Node{&rotated, nodes[4]}, added)
+	assert.Equal(t, []*Ocr2Node{nodes[0], nodes[2]}, removed)
+
+	added, removed = current.DiffNodes(current)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+
+	added, removed = RegisteredDon{}.DiffNodes(current)
+	assert.Equal(t, current.Nodes, added)
+	assert.Empty(t, removed)
+}
+
+func TestRegisteredDon_ForwarderConfig(t *testing.T) {
+	newDon := func(nNodes int, f uint8) RegisteredDon {
+		var nodes []*Ocr2Node
+		for i := 1; i <= nNodes; i++ {
+			n := &Ocr2Node{
+				ID:     fmt.Sprintf("node-%d", i),
+				P2PKey: p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID(),
+			}
+			n.Signer[0] = byte(i)
+			nodes = append(nodes, n)
+		}
+		return RegisteredDon{
+			Name:  "don",
+			Info:  kcr.CapabilitiesRegistryDONInfo{Id: 7, F: f},
+			Nodes: nodes,
+		}
+	}
+
+	don := newDon(4, 1)
+	signers, f, donID, err := don.ForwarderConfig()
+	require.NoError(t, err)
+	assert.Equal(t, don.Signers(), signers)
+	assert.Len(t, signers, 4)
+	assert.Equal(t, uint8(1), f)
+	assert.Equal(t, uint32(7), donID)
+
+	_, _, _, err = newDon(4, 0).ForwarderConfig()
+	require.ErrorContains(t, err, "f=0")
+	_, _, _, err = newDon(3, 1).ForwarderConfig()
+	require.ErrorContains(t, err, "don don has 3 signers, at least 4 are required for f=1")
+	_, _, _, err = newDon(32, 10).ForwarderConfig()
+	require.ErrorContains(t, err, "at most 31")
+
+	t.Run("calldata", func(t *testing.T) {
+		don := newDon(4, 1)
+		don.Info.ConfigCount = 3
+		calldata, err := ForwarderSetConfigCalldata(don)
+		require.NoError(t, err)
+		fwdrABI, err := kf.KeystoneForwarderMetaData.GetAbi()
+		require.NoError(t, err)
+		method := fwdrABI.Methods["setConfig"]
+		require.Equal(t, method.ID, calldata[:4])
+		args, err := method.Inputs.Unpack(calldata[4:])
+		require.NoError(t, err)
+		require.Len(t, args, 4)
+		assert.Equal(t, uint32(7), args[0])
+		assert.Equal(t, uint32(3), args[1], "the config version is the don config count")
+		assert.Equal(t, uint8(1), args[2])
+		assert.Equal(t, don.Signers(), args[3])
+
+		_, err = ForwarderSetConfigCalldata(newDon(3, 1))
+		require.ErrorContains(t, err, "at least 4 are required")
+	})
+
+	t.Run("explicit f", func(t *testing.T) {
+		// the registered f is used when the don does not set one
+		don := newDon(7, 2)
+		_, f, _, err := don.ForwarderConfig()
+		require.NoError(t, err)
+		assert.Equal(t, uint8(2), f)
+		assert.Equal(t, uint8(2), don.F())
+
+		one := uint8(1)
+		don.FaultTolerance = &one
+		_, f, _, err = don.ForwarderConfig()
+		require.NoError(t, err)
+		assert.Equal(t, uint8(1), f)
+		assert.Equal(t, uint8(1), don.F())
+
+		three := uint8(3)
+		don.FaultTolerance = &three
+		_, _, _, err = don.ForwarderConfig()
+		require.ErrorContains(t, err, "don don has 7 signers, at least 10 are required for f=3")
+	})
+}
+
+func TestMaxFaultyNodes(t *testing.T) {
+	tests := []struct {
+		n    int
+		want uint8
+	}{
+		{n: 0, want: 0},
+		{n: 1, want: 0},
+		{n: 3, want: 0},
+		{n: 4, want: 1},
+		{n: 6, want: 1},
+		{n: 7, want: 2},
+		{n: 31, want: 10},
+		{n: 1000, want: 255},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			assert.Equal(t, tt.want, MaxFaultyNodes(tt.n))
+		})
+	}
+}
+
+func TestRegisteredDon_ValidateQuorum(t *testing.T) {
+	makeDon := func(nSigners int) RegisteredDon {
+		d := RegisteredDon{Name: "don"}
+		for i := 1; i <= nSigners; i++ {
+			d.Nodes = append(d.Nodes, &Ocr2Node{
+				ID:     fmt.Sprintf("node-%d", i),
+				P2PKey: p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID(),
+			})
+		}
+		// bootstraps do not count towards the quorum
+		d.Nodes = append(d.Nodes, &Ocr2Node{ID: "bootstrap", IsBoostrap: true})
+		return d
+	}
+	tests := []struct {
+		name    string
+		n       int
+		wantF   uint8
+		wantErr bool
+	}{
+		{name: "single node", n: 1, wantF: 0, wantErr: true},
+		{name: "four nodes", n: 4, wantF: 1},
+		{name: "large don", n: 31, wantF: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := makeDon(tt.n)
+			assert.Equal(t, tt.wantF, d.F())
+			err := d.ValidateQuorum()
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_joinInfoAndNodes_onlyBootstraps(t *testing.T) {
+	var nodes []*models.Node
+	for i := 1; i <= 2; i++ {
+		peerID := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID().String()
+		nodes = append(nodes, newTestCloNode(fmt.Sprintf("node-%d", i), peerID, fmt.Sprintf("%040x", i), true))
+	}
+	dons := []DonCapabilities{
+		{
+			Name:         "bootstraps",
+			Nops:         []*models.NodeOperator{{Name: "nop", Nodes: nodes}},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		},
+	}
+	_, err := joinInfoAndNodes(tests.Context(t), map[string]kcr.CapabilitiesRegistryDONInfo{"bootstraps": {Id: 1}}, dons, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+	require.Error(t, err)
+	assert.Equal(t, "don bootstraps has no signers: all of its nodes are bootstraps", err.Error())
+
+	t.Run("unregistered bootstrap don", func(t *testing.T) {
+		var workers []*models.Node
+		for i := 3; i <= 6; i++ {
+			peerID := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(i))).PeerID().String()
+			workers = append(workers, newTestCloNode(fmt.Sprintf("node-%d", i), peerID, fmt.Sprintf("%040x", i), false))
+		}
+		dons := append(dons, DonCapabilities{
+			Name:         "workers",
+			Nops:         []*models.NodeOperator{{Name: "nop", Nodes: workers}},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		})
+		bootstrap, _, err := PartitionDons(tests.Context(t), dons, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+		require.NoError(t, err)
+		require.Len(t, bootstrap, 1)
+
+		got, err := joinInfoAndNodes(tests.Context(t), map[string]kcr.CapabilitiesRegistryDONInfo{"workers": {Id: 1}}, dons, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, "workers", got[0].Name)
+		assert.Len(t, got[0].Nodes, 4)
+	})
+}
+
+func Test_validateDonMapsConsistent(t *testing.T) {
+	caps := map[DonName][]kcr.CapabilitiesRegistryCapability{
+		"don 1": {OCR3Cap},
+		"don 2": {WriteChainCap},
+	}
+	nodes := map[DonName][]*Ocr2Node{
+		"don 1": {{ID: "node-1"}},
+	}
+	err := validateDonMapsConsistent(caps, nodes)
+	require.Error(t, err)
+	assert.Equal(t, "inconsistent don maps: dons with only capabilities [don 2], dons with only nodes []", err.Error())
+
+	nodes["don 3"] = []*Ocr2Node{{ID: "node-3"}}
+	err = validateDonMapsConsistent(caps, nodes)
+	require.Error(t, err)
+	assert.Equal(t, "inconsistent don maps: dons with only capabilities [don 2], dons with only nodes [don 3]", err.Error())
+
+	delete(caps, "don 2")
+	caps["don 3"] = nil
+	require.NoError(t, validateDonMapsConsistent(caps, nodes))
+}
+
+func Test_joinInfoAndNodes_mismatchedDons(t *testing.T) {
+	dons := newTestTopology(2, 4)
+	donInfos := map[string]kcr.CapabilitiesRegistryDONInfo{
+		dons[0].Name: {Id: 1},
+		"onchain":    {Id: 2},
+	}
+	_, err := joinInfoAndNodes(tests.Context(t), donInfos, dons, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+	require.Error(t, err)
+	assert.Equal(t, "mismatched dons: dons only in the registry [onchain], dons only in the config ["+dons[1].Name+"]", err.Error())
+
+	donInfos = map[string]kcr.CapabilitiesRegistryDONInfo{dons[0].Name: {Id: 1}, dons[1].Name: {Id: 2}}
+	got, err := joinInfoAndNodes(tests.Context(t), donInfos, dons, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+
+	// the fault tolerance of the don is threaded through to the registered don
+	zero := uint8(0)
+	dons[1].F = &zero
+	got, err = joinInfoAndNodes(tests.Context(t), donInfos, dons, chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector)
+	require.NoError(t, err)
+	for _, d := range got {
+		if d.Name == dons[1].Name {
+			require.NotNil(t, d.FaultTolerance)
+			assert.Equal(t, uint8(0), d.F())
+		} else {
+			assert.Nil(t, d.FaultTolerance)
+			assert.Equal(t, uint8(1), d.F())
+		}
+	}
+}
+
+func Test_joinInfoAndNodes_nodeLabels(t *testing.T) {
+	sel := chainsel.ETHEREUM_TESTNET_SEPOLIA.Selector
+	dons := newTestTopology(1, 4)
+	labelled := dons[0].Nops[0].Nodes[0].ID
+	donInfos := map[string]kcr.CapabilitiesRegistryDONInfo{dons[0].Name: {Id: 1, F: 1}}
+	unlabelledDons, err := joinInfoAndNodes(tests.Context(t), donInfos, dons, sel)
+	require.NoError(t, err)
+
+	dons[0].NodeLabels = map[string]map[string]string{labelled: {"region": "us-east", "env": "staging"}}
+	cache := NewOcr2NodeCache()
+	got, err := joinInfoAndNodes(tests.Context(t), donInfos, dons, sel, withOcr2NodeCache(cache))
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	for _, n := range got[0].Nodes {
+		if n.ID == labelled {
+			assert.Equal(t, map[string]string{"region": "us-east", "env": "staging"}, n.Labels)
+		} else {
+			assert.Nil(t, n.Labels)
+		}
+	}
+	cached, err := cache.Get(tests.Context(t), dons[0].Nops[0].Nodes[0], sel)
+	require.NoError(t, err)
+	assert.Nil(t, cached.Labels, "the shared node is not modified")
+
+	// labels are not registered
+	want, err := ForwarderSetConfigCalldata(unlabelledDons[0])
+	require.NoError(t, err)
+	calldata, err := ForwarderSetConfigCalldata(got[0])
+	require.NoError(t, err)
+	assert.Equal(t, want, calldata)
+
+	b, err := json.Marshal(dons[0])
+	require.NoError(t, err)
+	var decoded DonCapabilities
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, dons[0].NodeLabels, decoded.NodeLabels)
+}
+
+// newTestCloNode returns a clo node with a single ocr2 config for the sepolia registry chain
+func newTestCloNode(id string, peerID string, signer string, isBootstrap bool) *models.Node {
+	pubKey := "03dacd15fc96c965c648e3623180de002b71a97cf6eeca9affb91f461dcd6ce1"
+	return &models.Node{
+		ID:        id,
+		Name:      id,
+		PublicKey: &pubKey,
+		ChainConfigs: []*models.NodeChainConfig{
+			{
+				ID: id + "-evm",
+				Network: &models.Network{
+					ChainType: models.ChainTypeEvm,
+					ChainID:   strconv.FormatUint(chainsel.ETHEREUM_TESTNET_SEPOLIA.EvmChainID, 10),
+				},
+				AdminAddress: "0x0000000000000000000000000000000000000001",
+				Ocr2Config: &models.NodeOCR2Config{
+					IsBootstrap: isBootstrap,
+					P2pKeyBundle: &models.NodeOCR2ConfigP2PKeyBundle{
+						PeerID: peerID,
+					},
+					OcrKeyBundle: &models.NodeOCR2ConfigOCRKeyBundle{
+						ConfigPublicKey:       pubKey,
+						OffchainPublicKey:     pubKey,
+						OnchainSigningAddress: signer,
+					},
+				},
+			},
+		},
+	}
+}
+
+// newTestTopology returns nDons dons with two nops each and nodesPerDon unique nodes per don
+func newTestTopology(nDons, nodesPerDon int) []DonCapabilities {
+	var dons []DonCapabilities
+	id := 0
+	for d := 0; d < nDons; d++ {
+		don := DonCapabilities{
+			Name:         fmt.Sprintf("don-%d", d),
+			Nops:         []*models.NodeOperator{{Name: fmt.Sprintf("nop-%d-a", d)}, {Name: fmt.Sprintf("nop-%d-b", d)}},
+			Capabilities: []kcr.CapabilitiesRegistryCapability{OCR3Cap},
+		}
+		for n := 0; n < nodesPerDon; n++ {
+			id++
+			peerID := p2pkey.MustNewV2XXXTestingOnly(big.NewInt(int64(id))).PeerID().String()
+			node := newTestCloNode(fmt.Sprintf("node-%d", id), peerID, fmt.Sprintf("%040x", id), false)
+			nop := don.Nops[n%2]
+			nop.Nodes = append(nop.Nodes, node)
+		}
+		dons = append(dons, don)
+	}
+	return dons
 }
 
 func loadTestNops(t *testing.T, pth string) []*models.NodeOperator {